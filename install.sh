@@ -46,11 +46,11 @@ else
     echo "  ✓ proxy-manager.sh encontrado"
 fi
 
-if [ ! -f "$SCRIPT_DIR/proxy-api/main.go" ]; then
-    echo "  ❌ proxy-api/main.go não encontrado em: $SCRIPT_DIR/proxy-api/main.go"
+if [ ! -f "$SCRIPT_DIR/proxy-api/main.go" ] || [ ! -f "$SCRIPT_DIR/proxy-api/go.mod" ]; then
+    echo "  ❌ proxy-api/main.go ou proxy-api/go.mod não encontrado em: $SCRIPT_DIR/proxy-api/"
     MISSING_FILES=1
 else
-    echo "  ✓ proxy-api/main.go encontrado"
+    echo "  ✓ proxy-api/main.go e proxy-api/go.mod encontrados"
 fi
 
 if [ $MISSING_FILES -eq 1 ]; then
@@ -62,6 +62,7 @@ if [ $MISSING_FILES -eq 1 ]; then
     echo "  ├── install.sh"
     echo "  ├── proxy-manager.sh"
     echo "  └── proxy-api/"
+    echo "      ├── go.mod"
     echo "      └── main.go"
     echo ""
     echo "Certifique-se de que os arquivos estão no mesmo diretório do install.sh"
@@ -138,38 +139,6 @@ echo "🔧 Habilitando ModemManager..."
 systemctl enable ModemManager
 systemctl start ModemManager
 
-echo ""
-echo "========================================="
-echo "📥 INSTALANDO 3PROXY"
-echo "========================================="
-echo ""
-
-cd /tmp
-if [ ! -d "3proxy-0.9.4" ]; then
-    echo "Baixando 3proxy 0.9.4..."
-    wget https://github.com/3proxy/3proxy/archive/refs/tags/0.9.4.tar.gz
-    tar -xzf 0.9.4.tar.gz
-else
-    echo "3proxy já baixado, pulando download..."
-fi
-
-cd 3proxy-0.9.4
-echo "Compilando 3proxy..."
-make -f Makefile.Linux
-
-echo "Instalando 3proxy..."
-mkdir -p /usr/local/bin
-mkdir -p /etc/3proxy
-mkdir -p /var/log/3proxy
-
-cp bin/3proxy /usr/local/bin/
-chmod +x /usr/local/bin/3proxy
-
-echo "✅ 3proxy instalado com sucesso!"
-
-# Voltar para o diretório do script
-cd "$SCRIPT_DIR"
-
 echo ""
 echo "========================================="
 echo "📂 CRIANDO ESTRUTURA DE DIRETÓRIOS"
@@ -179,8 +148,12 @@ echo ""
 mkdir -p "$USER_HOME/proxy-system"
 mkdir -p "$USER_HOME/proxy-system/logs"
 mkdir -p "$USER_HOME/proxy-api"
+mkdir -p /var/lib/proxy-api
+chown $REAL_USER:$REAL_USER /var/lib/proxy-api
+mkdir -p /etc/proxy-api
 
 echo "  ✓ Diretórios criados em $USER_HOME"
+echo "  ✓ Dados da API em /var/lib/proxy-api"
 
 echo ""
 echo "========================================="
@@ -196,13 +169,24 @@ cp "$SCRIPT_DIR/proxy-manager.sh" "$USER_HOME/proxy-system/"
 chmod +x "$USER_HOME/proxy-system/proxy-manager.sh"
 echo "  ✓ proxy-manager.sh copiado"
 
-# Copiar main.go
+# Copiar fontes da API
 echo ""
-echo "Copiando main.go..."
-echo "  De: $SCRIPT_DIR/proxy-api/main.go"
-echo "  Para: $USER_HOME/proxy-api/main.go"
-cp "$SCRIPT_DIR/proxy-api/main.go" "$USER_HOME/proxy-api/"
-echo "  ✓ main.go copiado"
+echo "Copiando fontes da API..."
+echo "  De: $SCRIPT_DIR/proxy-api/"
+echo "  Para: $USER_HOME/proxy-api/"
+cp "$SCRIPT_DIR"/proxy-api/*.go "$USER_HOME/proxy-api/"
+cp "$SCRIPT_DIR/proxy-api/go.mod" "$SCRIPT_DIR/proxy-api/go.sum" "$USER_HOME/proxy-api/"
+cp "$SCRIPT_DIR"/proxy-api/*.json "$SCRIPT_DIR/proxy-api/index.html" "$USER_HOME/proxy-api/" 2>/dev/null || true
+echo "  ✓ Fontes copiadas"
+
+# Configuração da API (preserva a existente)
+if [ ! -f /etc/proxy-api/config.yaml ]; then
+    sed "s|/home/squid|$USER_HOME|" "$SCRIPT_DIR/proxy-api/config.example.yaml" > /etc/proxy-api/config.yaml
+    echo "  ✓ Configuração criada em /etc/proxy-api/config.yaml"
+else
+    echo "  ✓ Configuração existente mantida em /etc/proxy-api/config.yaml"
+fi
+cp "$SCRIPT_DIR/proxy-api/config.example.yaml" /etc/proxy-api/
 
 # Ajustar permissões
 chown -R $REAL_USER:$REAL_USER "$USER_HOME/proxy-system"
@@ -219,12 +203,12 @@ cd "$USER_HOME/proxy-api"
 
 # Compilar como o usuário real
 echo "Compilando proxy-api..."
-if su - $REAL_USER -c "cd $USER_HOME/proxy-api && go build -o proxy-api main.go" 2>&1; then
+if su - $REAL_USER -c "cd $USER_HOME/proxy-api && go build -o proxy-api ." 2>&1; then
     chmod +x "$USER_HOME/proxy-api/proxy-api"
     echo "  ✅ API compilada com sucesso"
 else
     echo "  ❌ Erro ao compilar API. Você precisará compilar manualmente depois."
-    echo "     cd ~/proxy-api && go build -o proxy-api main.go"
+    echo "     cd ~/proxy-api && go build -o proxy-api ."
 fi
 
 # Voltar para o diretório do script
@@ -242,7 +226,6 @@ $REAL_USER ALL=(ALL) NOPASSWD: /usr/bin/mmcli
 $REAL_USER ALL=(ALL) NOPASSWD: /usr/sbin/ip
 $REAL_USER ALL=(ALL) NOPASSWD: /usr/sbin/iptables
 $REAL_USER ALL=(ALL) NOPASSWD: /usr/bin/killall
-$REAL_USER ALL=(ALL) NOPASSWD: /usr/local/bin/3proxy
 $REAL_USER ALL=(ALL) NOPASSWD: /usr/bin/pgrep
 $REAL_USER ALL=(ALL) NOPASSWD: /usr/bin/netstat
 $REAL_USER ALL=(ALL) NOPASSWD: /usr/bin/uptime
@@ -317,6 +300,7 @@ Type=simple
 User=$REAL_USER
 WorkingDirectory=$USER_HOME/proxy-api
 ExecStart=$USER_HOME/proxy-api/proxy-api
+ExecReload=/bin/kill -HUP \$MAINPID
 Restart=always
 RestartSec=10
 StandardOutput=journal
@@ -372,9 +356,6 @@ echo ""
 echo -n "  ModemManager: "
 systemctl is-active ModemManager >/dev/null 2>&1 && echo "✓ RODANDO" || echo "✗ PARADO"
 
-echo -n "  3proxy: "
-/usr/local/bin/3proxy --version > /dev/null 2>&1 && echo "✓ INSTALADO" || echo "✗ NÃO INSTALADO"
-
 echo -n "  Go: "
 go version > /dev/null 2>&1 && echo "✓ INSTALADO" || echo "✗ NÃO INSTALADO"
 
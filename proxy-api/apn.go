@@ -0,0 +1,598 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// PERFIS DE APN
+// ============================================================================
+
+const (
+	APNSourceOverride = "override"
+	APNSourceOperator = "operator"
+	APNSourceDefault  = "default"
+)
+
+var (
+	apnProfileIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+	operatorCodePattern = regexp.MustCompile(`^\d{5,6}$`)
+)
+
+// APNProfile são os dados de conexão de uma operadora. Operators lista os
+// MCC/MNC (ex.: "72406") dos SIMs que usam o perfil.
+type APNProfile struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Operators []string  `json:"operators"`
+	APN       string    `json:"apn"`
+	User      string    `json:"user"`
+	Password  string    `json:"password"`
+	IPType    string    `json:"ip_type"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// APNProfileInput são os campos aceitos via API. No PUT, campos omitidos
+// mantêm o valor atual.
+type APNProfileInput struct {
+	ID        string   `json:"id"`
+	Name      *string  `json:"name"`
+	Operators []string `json:"operators"`
+	APN       *string  `json:"apn"`
+	User      *string  `json:"user"`
+	Password  *string  `json:"password"`
+	IPType    *string  `json:"ip_type"`
+}
+
+// APNOverride fixa o APN de um modem: ou um perfil (Profile) ou os dados de
+// conexão explícitos. Vale para a identidade (IMEI/ICCID); ModemID é só o
+// índice do modem quando o APN foi fixado.
+type APNOverride struct {
+	Identity  string    `json:"identity"`
+	ModemID   string    `json:"modem_id"`
+	Profile   string    `json:"profile,omitempty"`
+	APN       string    `json:"apn,omitempty"`
+	User      string    `json:"user,omitempty"`
+	Password  string    `json:"password,omitempty"`
+	IPType    string    `json:"ip_type,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ResolvedAPN é o APN que o connect vai usar e de onde ele veio.
+type ResolvedAPN struct {
+	Source       string        `json:"source"`
+	Profile      string        `json:"profile,omitempty"`
+	OperatorCode string        `json:"operator_code,omitempty"`
+	Params       ConnectParams `json:"params"`
+}
+
+// Perfis criados no primeiro start. Os MCC/MNC são os das operadoras
+// brasileiras (724).
+func defaultAPNProfiles() []*APNProfile {
+	return []*APNProfile{
+		{ID: "vivo", Name: "Vivo", Operators: []string{"72406", "72410", "72411", "72423"}, APN: DEFAULT_APN, User: DEFAULT_APN_USER, Password: DEFAULT_APN_PASS, IPType: "ipv4"},
+		{ID: "claro", Name: "Claro", Operators: []string{"72405", "72438"}, APN: "claro.com.br", User: "claro", Password: "claro", IPType: "ipv4"},
+		{ID: "tim", Name: "TIM", Operators: []string{"72402", "72403", "72404"}, APN: "timbrasil.br", User: "tim", Password: "tim", IPType: "ipv4"},
+		{ID: "oi", Name: "Oi", Operators: []string{"72416", "72424", "72431"}, APN: "gprs.oi.com.br", User: "oi", Password: "oi", IPType: "ipv4"},
+	}
+}
+
+func validIPType(ipType string) bool {
+	_, ok := mmIPFamilies[ipType]
+	return ok
+}
+
+func (p *APNProfile) validate() error {
+	if !apnProfileIDPattern.MatchString(p.ID) {
+		return fmt.Errorf("id inválido: %q (use letras minúsculas, números, - e _)", p.ID)
+	}
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("nome é obrigatório")
+	}
+	if strings.TrimSpace(p.APN) == "" {
+		return fmt.Errorf("apn é obrigatório")
+	}
+	if !validIPType(p.IPType) {
+		return fmt.Errorf("ip_type inválido: %s (use ipv4, ipv6 ou ipv4v6)", p.IPType)
+	}
+	for _, code := range p.Operators {
+		if !operatorCodePattern.MatchString(code) {
+			return fmt.Errorf("código de operadora inválido: %q (use MCC+MNC, ex.: 72406)", code)
+		}
+	}
+	return nil
+}
+
+func (p *APNProfile) params() ConnectParams {
+	return ConnectParams{APN: p.APN, User: p.User, Password: p.Password, IPType: p.IPType}
+}
+
+func (p *APNProfile) copy() APNProfile {
+	copied := *p
+	copied.Operators = append([]string{}, p.Operators...)
+	return copied
+}
+
+// apply copia os campos enviados para o perfil.
+func (input APNProfileInput) apply(profile *APNProfile) {
+	if input.Name != nil {
+		profile.Name = *input.Name
+	}
+	if input.Operators != nil {
+		profile.Operators = input.Operators
+	}
+	if input.APN != nil {
+		profile.APN = *input.APN
+	}
+	if input.User != nil {
+		profile.User = *input.User
+	}
+	if input.Password != nil {
+		profile.Password = *input.Password
+	}
+	if input.IPType != nil {
+		profile.IPType = *input.IPType
+	}
+}
+
+// APNStore guarda os perfis em DATA_DIR/apn-profiles.json e os overrides
+// por identidade do modem em DATA_DIR/apn-overrides.json. O proxy-manager.sh
+// lê os mesmos arquivos para conectar os modems no start.
+type APNStore struct {
+	mu            sync.Mutex
+	profiles      map[string]*APNProfile
+	overrides     map[string]*APNOverride
+	legacy        []*APNOverride // gravados por índice, aguardando MigrateModemRefs
+	profilesPath  string
+	overridesPath string
+}
+
+var apnStore = &APNStore{
+	profiles:  make(map[string]*APNProfile),
+	overrides: make(map[string]*APNOverride),
+}
+
+func (s *APNStore) SetDataDir(dir string) {
+	s.mu.Lock()
+	s.profilesPath = filepath.Join(dir, "apn-profiles.json")
+	s.overridesPath = filepath.Join(dir, "apn-overrides.json")
+	s.mu.Unlock()
+}
+
+// Load lê perfis e overrides. Sem arquivo de perfis, grava os perfis
+// padrão das operadoras.
+func (s *APNStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var profiles []*APNProfile
+	err := readJSONFile(s.profilesPath, &profiles)
+	switch {
+	case err == nil:
+		for _, profile := range profiles {
+			s.profiles[profile.ID] = profile
+		}
+	case os.IsNotExist(err):
+		now := time.Now()
+		for i, profile := range defaultAPNProfiles() {
+			// Horários distintos mantêm a ordem da lista padrão
+			profile.CreatedAt = now.Add(time.Duration(i) * time.Millisecond)
+			profile.UpdatedAt = profile.CreatedAt
+			s.profiles[profile.ID] = profile
+		}
+		if err := s.saveProfiles(); err != nil {
+			return err
+		}
+		log.Printf("📡 Perfis de APN padrão criados em %s", s.profilesPath)
+	default:
+		return fmt.Errorf("erro ao ler perfis de APN: %v", err)
+	}
+
+	var overrides []*APNOverride
+	if err := readJSONFile(s.overridesPath, &overrides); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("erro ao ler overrides de APN: %v", err)
+	}
+	for _, override := range overrides {
+		if override.Identity == "" {
+			s.legacy = append(s.legacy, override)
+			continue
+		}
+		s.overrides[override.Identity] = override
+	}
+	return nil
+}
+
+// MigrateModemRefs passa para a identidade do modem os overrides que
+// versões anteriores gravaram pelo índice. Os de índices sem modem continuam
+// em legacy (e no arquivo, sem identity) até um modem aparecer neles: é
+// chamado no start e a cada modem conectado pelo hotplug.
+func (s *APNStore) MigrateModemRefs(ctx context.Context, backend ModemBackend) {
+	s.mu.Lock()
+	refs := make([]string, 0, len(s.legacy))
+	for _, override := range s.legacy {
+		refs = append(refs, override.ModemID)
+	}
+	s.mu.Unlock()
+	if len(refs) == 0 {
+		return
+	}
+
+	migrated := migrateModemRefs(ctx, backend, refs)
+	if len(migrated) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := make([]*APNOverride, 0, len(s.legacy))
+	for _, override := range s.legacy {
+		identity, ok := migrated[override.ModemID]
+		if !ok {
+			pending = append(pending, override)
+			continue
+		}
+		if _, exists := s.overrides[identity]; exists {
+			continue
+		}
+		override.Identity = identity
+		s.overrides[identity] = override
+		log.Printf("📡 APN fixado no modem %s → %s", override.ModemID, identity)
+	}
+	s.legacy = pending
+	if err := s.saveOverrides(); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+}
+
+func (s *APNStore) saveProfiles() error {
+	if s.profilesPath == "" {
+		return nil
+	}
+
+	profiles := make([]*APNProfile, 0, len(s.profiles))
+	for _, profile := range s.profiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].CreatedAt.Before(profiles[j].CreatedAt) })
+
+	if err := writeJSONFile(s.profilesPath, profiles); err != nil {
+		return fmt.Errorf("erro ao salvar perfis de APN: %v", err)
+	}
+	return nil
+}
+
+func (s *APNStore) saveOverrides() error {
+	if s.overridesPath == "" {
+		return nil
+	}
+
+	overrides := make([]*APNOverride, 0, len(s.overrides)+len(s.legacy))
+	for _, override := range s.overrides {
+		overrides = append(overrides, override)
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].Identity < overrides[j].Identity })
+	overrides = append(overrides, s.legacy...)
+
+	if err := writeJSONFile(s.overridesPath, overrides); err != nil {
+		return fmt.Errorf("erro ao salvar overrides de APN: %v", err)
+	}
+	return nil
+}
+
+// conflict recusa códigos de operadora já usados por outro perfil: o mesmo
+// SIM não pode casar com dois perfis.
+func (s *APNStore) conflict(profile *APNProfile) error {
+	for _, other := range s.profiles {
+		if other.ID == profile.ID {
+			continue
+		}
+		for _, code := range profile.Operators {
+			for _, used := range other.Operators {
+				if code == used {
+					return fmt.Errorf("operadora %s já está no perfil %s", code, other.ID)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *APNStore) Profiles() []APNProfile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	profiles := make([]APNProfile, 0, len(s.profiles))
+	for _, profile := range s.profiles {
+		profiles = append(profiles, profile.copy())
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].CreatedAt.Before(profiles[j].CreatedAt) })
+	return profiles
+}
+
+func (s *APNStore) CreateProfile(input APNProfileInput) (APNProfile, error) {
+	now := time.Now()
+	profile := &APNProfile{
+		ID:        strings.ToLower(strings.TrimSpace(input.ID)),
+		Operators: make([]string, 0),
+		IPType:    "ipv4",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if profile.ID == "" {
+		profile.ID = newJobID()
+	}
+	input.apply(profile)
+	if err := profile.validate(); err != nil {
+		return APNProfile{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.profiles[profile.ID]; exists {
+		return APNProfile{}, fmt.Errorf("perfil %s já existe", profile.ID)
+	}
+	if err := s.conflict(profile); err != nil {
+		return APNProfile{}, err
+	}
+
+	s.profiles[profile.ID] = profile
+	if err := s.saveProfiles(); err != nil {
+		delete(s.profiles, profile.ID)
+		return APNProfile{}, err
+	}
+
+	log.Printf("📡 Perfil de APN %s (%s) criado", profile.ID, profile.APN)
+	return profile.copy(), nil
+}
+
+func (s *APNStore) UpdateProfile(id string, input APNProfileInput) (APNProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.profiles[id]
+	if !ok {
+		return APNProfile{}, fmt.Errorf("perfil %s não encontrado", id)
+	}
+
+	updated := current.copy()
+	input.apply(&updated)
+	if err := updated.validate(); err != nil {
+		return APNProfile{}, err
+	}
+	if err := s.conflict(&updated); err != nil {
+		return APNProfile{}, err
+	}
+	updated.UpdatedAt = time.Now()
+
+	s.profiles[id] = &updated
+	if err := s.saveProfiles(); err != nil {
+		s.profiles[id] = current
+		return APNProfile{}, err
+	}
+
+	log.Printf("📡 Perfil de APN %s (%s) atualizado", id, updated.APN)
+	return updated.copy(), nil
+}
+
+// DeleteProfile recusa perfis ainda usados por algum override.
+func (s *APNStore) DeleteProfile(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	profile, ok := s.profiles[id]
+	if !ok {
+		return fmt.Errorf("perfil %s não encontrado", id)
+	}
+	for _, override := range s.overrides {
+		if override.Profile == id {
+			return fmt.Errorf("perfil %s em uso pelo modem %s", id, override.Identity)
+		}
+	}
+
+	delete(s.profiles, id)
+	if err := s.saveProfiles(); err != nil {
+		s.profiles[id] = profile
+		return err
+	}
+
+	log.Printf("📡 Perfil de APN %s removido", id)
+	return nil
+}
+
+func (s *APNStore) Override(identity string) *APNOverride {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	override, ok := s.overrides[identity]
+	if !ok {
+		return nil
+	}
+	copied := *override
+	return &copied
+}
+
+// SetOverride fixa o APN do modem. Com profile, os dados explícitos são
+// ignorados.
+func (s *APNStore) SetOverride(override APNOverride) (APNOverride, error) {
+	if override.Identity == "" {
+		return APNOverride{}, fmt.Errorf("modem %s não informa IMEI nem ICCID", override.ModemID)
+	}
+	if override.Profile != "" {
+		override.APN, override.User, override.Password, override.IPType = "", "", "", ""
+	} else {
+		if strings.TrimSpace(override.APN) == "" {
+			return APNOverride{}, fmt.Errorf("informe profile ou apn")
+		}
+		if override.IPType == "" {
+			override.IPType = "ipv4"
+		}
+		if !validIPType(override.IPType) {
+			return APNOverride{}, fmt.Errorf("ip_type inválido: %s (use ipv4, ipv6 ou ipv4v6)", override.IPType)
+		}
+	}
+	override.UpdatedAt = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if override.Profile != "" {
+		if _, ok := s.profiles[override.Profile]; !ok {
+			return APNOverride{}, fmt.Errorf("perfil %s não encontrado", override.Profile)
+		}
+	}
+
+	previous := s.overrides[override.Identity]
+	s.overrides[override.Identity] = &override
+	if err := s.saveOverrides(); err != nil {
+		if previous != nil {
+			s.overrides[override.Identity] = previous
+		} else {
+			delete(s.overrides, override.Identity)
+		}
+		return APNOverride{}, err
+	}
+
+	log.Printf("📡 APN do modem %s (%s) fixado", override.ModemID, override.Identity)
+	return override, nil
+}
+
+func (s *APNStore) DeleteOverride(identity string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	override, ok := s.overrides[identity]
+	if !ok {
+		return s.deleteLegacy(identity)
+	}
+
+	delete(s.overrides, identity)
+	if err := s.saveOverrides(); err != nil {
+		s.overrides[identity] = override
+		return err
+	}
+
+	log.Printf("📡 APN do modem %s voltou para o perfil da operadora", identity)
+	return nil
+}
+
+// deleteLegacy remove um override antigo, ainda por índice, de um modem que
+// não voltou desde a migração.
+func (s *APNStore) deleteLegacy(modemID string) error {
+	for i, override := range s.legacy {
+		if override.ModemID != modemID {
+			continue
+		}
+
+		previous := s.legacy
+		s.legacy = append(append([]*APNOverride{}, s.legacy[:i]...), s.legacy[i+1:]...)
+		if err := s.saveOverrides(); err != nil {
+			s.legacy = previous
+			return err
+		}
+		log.Printf("📡 APN fixado no índice %s removido", modemID)
+		return nil
+	}
+	return fmt.Errorf("modem %s não tem APN fixado", modemID)
+}
+
+// Resolve escolhe o APN do modem pela identidade: o override, o perfil que
+// lista o MCC/MNC do SIM ou, por fim, o APN padrão.
+func (s *APNStore) Resolve(identity, operatorCode string) ResolvedAPN {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resolved := ResolvedAPN{OperatorCode: operatorCode}
+
+	if override, ok := s.overrides[identity]; ok && identity != "" {
+		resolved.Source = APNSourceOverride
+		if profile, ok := s.profiles[override.Profile]; ok {
+			resolved.Profile = profile.ID
+			resolved.Params = profile.params()
+			return resolved
+		}
+		if override.APN != "" {
+			resolved.Params = ConnectParams{APN: override.APN, User: override.User, Password: override.Password, IPType: override.IPType}
+			return resolved
+		}
+	}
+
+	if operatorCode != "" {
+		for _, profile := range s.profiles {
+			for _, code := range profile.Operators {
+				if code == operatorCode {
+					resolved.Source = APNSourceOperator
+					resolved.Profile = profile.ID
+					resolved.Params = profile.params()
+					return resolved
+				}
+			}
+		}
+	}
+
+	resolved.Source = APNSourceDefault
+	resolved.Params = ConnectParams{APN: DEFAULT_APN, User: DEFAULT_APN_USER, Password: DEFAULT_APN_PASS, IPType: "ipv4"}
+	return resolved
+}
+
+// describe resume a origem para os logs: "perfil claro, operadora 72405".
+func (r ResolvedAPN) describe() string {
+	parts := make([]string, 0, 2)
+	switch r.Source {
+	case APNSourceOverride:
+		parts = append(parts, "fixado no modem")
+	case APNSourceDefault:
+		parts = append(parts, "padrão")
+	}
+	if r.Profile != "" {
+		parts = append(parts, "perfil "+r.Profile)
+	}
+	if r.OperatorCode != "" {
+		parts = append(parts, "operadora "+r.OperatorCode)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ReadSIM lê o SIM ativo do modem; nil se o modem não tem SIM.
+func ReadSIM(ctx context.Context, backend ModemBackend, modemID string) (*SIMInfo, error) {
+	info, err := backend.GetModem(ctx, modemID)
+	if err != nil {
+		return nil, err
+	}
+	if info.SIM == "" {
+		return nil, nil
+	}
+	return backend.GetSIM(ctx, info.SIM)
+}
+
+// ResolveModemAPN lê o SIM e resolve o APN do modem. Sem conseguir ler o
+// SIM, valem o override ou o APN padrão; sem a identidade do modem, o
+// override não vale.
+func ResolveModemAPN(ctx context.Context, backend ModemBackend, modemID string) ResolvedAPN {
+	identity, _, err := ModemIdentity(ctx, backend, modemID)
+	if err != nil {
+		log.Printf("⚠️  %v: APN fixado ignorado", err)
+	}
+
+	operatorCode := ""
+	sim, err := ReadSIM(ctx, backend, modemID)
+	if err != nil {
+		log.Printf("⚠️  Erro ao ler SIM do modem %s: %v", modemID, err)
+	} else if sim != nil {
+		operatorCode = sim.OperatorCode
+	}
+	return apnStore.Resolve(identity, operatorCode)
+}
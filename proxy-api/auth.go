@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// AUTENTICAÇÃO DA API - CHAVES E PAPÉIS
+// ============================================================================
+
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+
+	apiKeyPrefix = "pk_"
+)
+
+var roleLevels = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// Allows informa se o papel cobre o papel exigido (admin > operator > viewer).
+func (r Role) Allows(required Role) bool {
+	return roleLevels[r] >= roleLevels[required]
+}
+
+// APIKey é uma chave de acesso à API. Apenas o hash do token é guardado; o
+// token em si é mostrado uma única vez, na criação.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Role       Role       `json:"role"`
+	TokenHash  string     `json:"token_hash,omitempty"`
+	Hint       string     `json:"hint"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+type APIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]*APIKey
+	path string
+}
+
+var apiKeyStore = &APIKeyStore{
+	keys: make(map[string]*APIKey),
+}
+
+func (ks *APIKeyStore) SetDataDir(dir string) {
+	ks.mu.Lock()
+	ks.path = filepath.Join(dir, "api-keys.json")
+	ks.mu.Unlock()
+}
+
+func (ks *APIKeyStore) Load() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	var keys []*APIKey
+	if err := readJSONFile(ks.path, &keys); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("erro ao ler chaves da API: %v", err)
+	}
+
+	for _, key := range keys {
+		ks.keys[key.ID] = key
+	}
+	return nil
+}
+
+func (ks *APIKeyStore) save() error {
+	if ks.path == "" {
+		return nil
+	}
+
+	keys := make([]*APIKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+
+	if err := writeJSONFile(ks.path, keys); err != nil {
+		return fmt.Errorf("erro ao salvar chaves da API: %v", err)
+	}
+	return nil
+}
+
+// Bootstrap cria uma chave admin no primeiro start e grava o token em
+// DATA_DIR/admin.key, para o dono do sistema conseguir entrar.
+func (ks *APIKeyStore) Bootstrap(dir string) error {
+	ks.mu.RLock()
+	empty := len(ks.keys) == 0
+	ks.mu.RUnlock()
+
+	if !empty {
+		return nil
+	}
+
+	key, token, err := ks.Create("admin", RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, "admin.key")
+	if err := os.WriteFile(path, []byte(token+"\n"), 0o600); err != nil {
+		return fmt.Errorf("erro ao gravar %s: %v", path, err)
+	}
+
+	log.Printf("🔑 Chave admin inicial %s criada em %s", key.ID, path)
+	return nil
+}
+
+func (key *APIKey) public() APIKey {
+	copied := *key
+	copied.TokenHash = ""
+	return copied
+}
+
+func (ks *APIKeyStore) Create(name string, role Role) (APIKey, string, error) {
+	if _, ok := roleLevels[role]; !ok {
+		return APIKey{}, "", fmt.Errorf("papel inválido: %s (use viewer, operator ou admin)", role)
+	}
+	if strings.TrimSpace(name) == "" {
+		return APIKey{}, "", fmt.Errorf("nome é obrigatório")
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, "", err
+	}
+	token := apiKeyPrefix + hex.EncodeToString(secret)
+
+	key := &APIKey{
+		ID:        newJobID(),
+		Name:      name,
+		Role:      role,
+		TokenHash: quickHash(token),
+		Hint:      token[:len(apiKeyPrefix)+4] + "…" + token[len(token)-4:],
+		CreatedAt: time.Now(),
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.keys[key.ID] = key
+	if err := ks.save(); err != nil {
+		delete(ks.keys, key.ID)
+		return APIKey{}, "", err
+	}
+
+	log.Printf("🔑 Chave da API %s (%s, %s) criada", key.ID, key.Name, key.Role)
+	return key.public(), token, nil
+}
+
+func (ks *APIKeyStore) List() []APIKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	keys := make([]APIKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		keys = append(keys, key.public())
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+func (ks *APIKeyStore) Delete(id string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.keys[id]
+	if !ok {
+		return fmt.Errorf("chave %s não encontrada", id)
+	}
+
+	if key.Role == RoleAdmin {
+		admins := 0
+		for _, other := range ks.keys {
+			if other.Role == RoleAdmin {
+				admins++
+			}
+		}
+		if admins == 1 {
+			return fmt.Errorf("não é possível remover a última chave admin")
+		}
+	}
+
+	delete(ks.keys, id)
+	if err := ks.save(); err != nil {
+		ks.keys[id] = key
+		return err
+	}
+
+	log.Printf("🔑 Chave da API %s (%s) removida", key.ID, key.Name)
+	return nil
+}
+
+// Lookup encontra a chave pelo token e registra o uso.
+func (ks *APIKeyStore) Lookup(token string) (APIKey, bool) {
+	if token == "" {
+		return APIKey{}, false
+	}
+	hash := []byte(quickHash(token))
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	for _, key := range ks.keys {
+		if subtle.ConstantTimeCompare([]byte(key.TokenHash), hash) == 1 {
+			now := time.Now()
+			key.LastUsedAt = &now
+			return key.public(), true
+		}
+	}
+	return APIKey{}, false
+}
+
+// ============================================================================
+// AUTENTICAÇÃO DA API - MIDDLEWARE
+// ============================================================================
+
+// requestToken aceita "Authorization: Bearer <token>" ou "X-API-Key".
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// allowQueryToken aceita a chave também em ?token=, para clientes que não
+// conseguem enviar cabeçalhos (EventSource e WebSocket do navegador). Usado
+// só em /events: em outras rotas a chave na URL acabaria em históricos e
+// logs de proxies.
+func allowQueryToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := strings.TrimSpace(r.URL.Query().Get("token")); token != "" && requestToken(r) == "" {
+			r.Header.Set("X-API-Key", token)
+		}
+		next(w, r)
+	}
+}
+
+// requireRole protege um handler exigindo uma chave com o papel mínimo.
+func requireRole(role Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := apiKeyStore.Lookup(requestToken(r))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="proxy-api"`)
+			respondJSONStatus(w, http.StatusUnauthorized, APIResponse{
+				Success: false,
+				Message: "Chave da API ausente ou inválida",
+			})
+			return
+		}
+
+		if !key.Role.Allows(role) {
+			respondJSONStatus(w, http.StatusForbidden, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Papel %s não tem acesso (exige %s)", key.Role, role),
+			})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// allowedOrigins vem de cors_origins na configuração (CORS_ORIGINS, lista
+// separada por vírgula). Vazio significa apenas a própria origem, que é como
+// o dashboard é servido.
+func allowedOrigins() map[string]bool {
+	origins := make(map[string]bool)
+	for _, origin := range currentConfig().CORSOrigins {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins[strings.TrimSuffix(origin, "/")] = true
+		}
+	}
+	return origins
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ============================================================================
+// CONFIGURAÇÃO
+// ============================================================================
+
+const DEFAULT_CONFIG_FILE = "/etc/proxy-api/config.yaml"
+
+// Config reúne as configurações da API. Precedência: valores padrão, arquivo
+// YAML (CONFIG_FILE) e variáveis de ambiente. Campos com reload:"true" são
+// aplicados no SIGHUP ou em POST /admin/reload; os demais só no restart.
+type Config struct {
+	Listen           string       `json:"listen" yaml:"listen"`
+	DataDir          string       `json:"data_dir" yaml:"data_dir"`
+	ProxyManagerPath string       `json:"proxy_manager_path" yaml:"proxy_manager_path" reload:"true"`
+	StatusCacheTTL   jsonDuration `json:"status_cache_ttl" yaml:"status_cache_ttl" reload:"true"`
+	CORSOrigins      []string     `json:"cors_origins" yaml:"cors_origins" reload:"true"`
+
+	Ports     PortsConfig     `json:"ports" yaml:"ports"`
+	Modems    ModemsConfig    `json:"modems" yaml:"modems"`
+	Network   NetworkSettings `json:"network" yaml:"network"`
+	Proxy     ProxyConfig     `json:"proxy" yaml:"proxy"`
+	SMS       SMSConfig       `json:"sms" yaml:"sms"`
+	Gateway   GatewayConfig   `json:"gateway" yaml:"gateway"`
+	Scheduler SchedulerConfig `json:"scheduler" yaml:"scheduler"`
+	PublicIP  PublicIPConfig  `json:"public_ip" yaml:"public_ip"`
+	Probe     ProbeConfig     `json:"probe" yaml:"probe"`
+}
+
+type PortsConfig struct {
+	BaseHTTP  int `json:"base_http" yaml:"base_http"`
+	BaseSOCKS int `json:"base_socks" yaml:"base_socks"`
+	MaxModems int `json:"max_modems" yaml:"max_modems"`
+}
+
+// ModemsConfig: com Hotplug, modems que aparecem ganham proxies e os que
+// somem têm os proxies desfeitos, sem restart. HistoryInterval é o
+// intervalo de amostragem do histórico de sinal, guardado por
+// HistoryRetention.
+type ModemsConfig struct {
+	Backend          string       `json:"backend" yaml:"backend"`
+	FakeScript       string       `json:"fake_script" yaml:"fake_script"`
+	DBusAddress      string       `json:"dbus_address" yaml:"dbus_address"`
+	Hotplug          bool         `json:"hotplug" yaml:"hotplug" reload:"true"`
+	HistoryInterval  jsonDuration `json:"history_interval" yaml:"history_interval" reload:"true"`
+	HistoryRetention jsonDuration `json:"history_retention" yaml:"history_retention" reload:"true"`
+}
+
+// NetworkSettings: Mode vazio escolhe pelo backend (noop com o fake);
+// RenewReleaseWait zero usa a espera padrão do backend.
+type NetworkSettings struct {
+	Mode             string       `json:"mode" yaml:"mode"`
+	RenewReleaseWait jsonDuration `json:"renew_release_wait" yaml:"renew_release_wait"`
+}
+
+type ProxyConfig struct {
+	Bind string `json:"bind" yaml:"bind"`
+}
+
+type SMSConfig struct {
+	CheckInterval jsonDuration `json:"check_interval" yaml:"check_interval" reload:"true"`
+	MaxHistory    int          `json:"max_history" yaml:"max_history" reload:"true"`
+}
+
+type GatewayConfig struct {
+	Strategy   string       `json:"strategy" yaml:"strategy" reload:"true"`
+	SessionTTL jsonDuration `json:"session_ttl" yaml:"session_ttl" reload:"true"`
+}
+
+type SchedulerConfig struct {
+	Stagger jsonDuration `json:"stagger" yaml:"stagger" reload:"true"`
+}
+
+// PublicIPConfig: Providers vazio usa os provedores padrão (ou "fake" com o
+// backend fake).
+type PublicIPConfig struct {
+	Providers []string     `json:"providers" yaml:"providers" reload:"true"`
+	Consensus string       `json:"consensus" yaml:"consensus" reload:"true"`
+	Timeout   jsonDuration `json:"timeout" yaml:"timeout" reload:"true"`
+}
+
+type ProbeConfig struct {
+	Targets  []string     `json:"targets" yaml:"targets" reload:"true"`
+	Interval jsonDuration `json:"interval" yaml:"interval" reload:"true"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Listen:           "0.0.0.0:5000",
+		DataDir:          DEFAULT_DATA_DIR,
+		ProxyManagerPath: PROXY_MANAGER_PATH,
+		StatusCacheTTL:   jsonDuration(STATUS_CACHE_TTL),
+		CORSOrigins:      []string{},
+		Ports: PortsConfig{
+			BaseHTTP:  BASE_PROXY_PORT,
+			BaseSOCKS: BASE_SOCKS_PORT,
+			MaxModems: MAX_MODEMS,
+		},
+		Modems: ModemsConfig{
+			Backend:          "mmcli",
+			DBusAddress:      "system",
+			Hotplug:          true,
+			HistoryInterval:  jsonDuration(SIGNAL_HISTORY_INTERVAL),
+			HistoryRetention: jsonDuration(SIGNAL_HISTORY_RETENTION),
+		},
+		Proxy: ProxyConfig{Bind: PROXY_BIND_HOST},
+		SMS: SMSConfig{
+			CheckInterval: jsonDuration(SMS_CHECK_INTERVAL),
+			MaxHistory:    SMS_MAX_HISTORY,
+		},
+		Gateway: GatewayConfig{
+			Strategy:   GATEWAY_STRATEGY,
+			SessionTTL: jsonDuration(SESSION_TTL),
+		},
+		Scheduler: SchedulerConfig{Stagger: jsonDuration(SCHEDULER_STAGGER)},
+		PublicIP: PublicIPConfig{
+			Providers: []string{},
+			Consensus: ConsensusMajority,
+			Timeout:   jsonDuration(PUBLIC_IP_TIMEOUT),
+		},
+		Probe: ProbeConfig{
+			Targets:  append([]string{}, defaultProbeTargets...),
+			Interval: jsonDuration(PROBE_INTERVAL),
+		},
+	}
+}
+
+// loadConfig monta a configuração a partir do arquivo e do ambiente. Sem
+// CONFIG_FILE, a ausência do arquivo padrão não é erro.
+func loadConfig() (*Config, string, error) {
+	cfg := defaultConfig()
+
+	path := os.Getenv("CONFIG_FILE")
+	explicit := path != ""
+	if !explicit {
+		path = DEFAULT_CONFIG_FILE
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, path, fmt.Errorf("erro ao ler %s: %v", path, err)
+		}
+	case os.IsNotExist(err) && !explicit:
+		path = ""
+	default:
+		return nil, path, fmt.Errorf("erro ao ler %s: %v", path, err)
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, path, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, path, fmt.Errorf("configuração inválida: %v", err)
+	}
+	return cfg, path, nil
+}
+
+// envReader aplica as variáveis de ambiente definidas (vazias são
+// ignoradas, como em getEnv), guardando o primeiro erro de conversão.
+type envReader struct {
+	err error
+}
+
+func (e *envReader) string(name string, target *string) {
+	if value := getEnv(name, ""); value != "" {
+		*target = value
+	}
+}
+
+func (e *envReader) list(name string, target *[]string) {
+	value := getEnv(name, "")
+	if value == "" {
+		return
+	}
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*target = items
+}
+
+func (e *envReader) int(name string, target *int) {
+	value := getEnv(name, "")
+	if value == "" || e.err != nil {
+		return
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		e.err = fmt.Errorf("%s inválido: %q", name, value)
+		return
+	}
+	*target = parsed
+}
+
+func (e *envReader) bool(name string, target *bool) {
+	value := getEnv(name, "")
+	if value == "" || e.err != nil {
+		return
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		e.err = fmt.Errorf("%s inválido: %q", name, value)
+		return
+	}
+	*target = parsed
+}
+
+func (e *envReader) duration(name string, target *jsonDuration) {
+	value := getEnv(name, "")
+	if value == "" || e.err != nil {
+		return
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		e.err = fmt.Errorf("%s inválido: %q", name, value)
+		return
+	}
+	*target = jsonDuration(parsed)
+}
+
+func (c *Config) applyEnv() error {
+	e := &envReader{}
+
+	e.string("LISTEN_ADDR", &c.Listen)
+	e.string("DATA_DIR", &c.DataDir)
+	e.string("PROXY_MANAGER_PATH", &c.ProxyManagerPath)
+	e.duration("STATUS_CACHE_TTL", &c.StatusCacheTTL)
+	e.list("CORS_ORIGINS", &c.CORSOrigins)
+
+	e.int("BASE_PROXY_PORT", &c.Ports.BaseHTTP)
+	e.int("BASE_SOCKS_PORT", &c.Ports.BaseSOCKS)
+	e.int("MAX_MODEMS", &c.Ports.MaxModems)
+
+	e.string("MODEM_BACKEND", &c.Modems.Backend)
+	e.string("FAKE_MODEM_SCRIPT", &c.Modems.FakeScript)
+	e.string("MM_DBUS_ADDRESS", &c.Modems.DBusAddress)
+	e.bool("MODEM_HOTPLUG", &c.Modems.Hotplug)
+	e.duration("MODEM_HISTORY_INTERVAL", &c.Modems.HistoryInterval)
+	e.duration("MODEM_HISTORY_RETENTION", &c.Modems.HistoryRetention)
+
+	e.string("NETWORK_CONFIG", &c.Network.Mode)
+	e.duration("RENEW_RELEASE_WAIT", &c.Network.RenewReleaseWait)
+
+	e.string("PROXY_BIND", &c.Proxy.Bind)
+
+	e.duration("SMS_CHECK_INTERVAL", &c.SMS.CheckInterval)
+	e.int("SMS_MAX_HISTORY", &c.SMS.MaxHistory)
+
+	e.string("GATEWAY_STRATEGY", &c.Gateway.Strategy)
+	e.duration("SESSION_TTL", &c.Gateway.SessionTTL)
+
+	e.duration("SCHEDULER_STAGGER", &c.Scheduler.Stagger)
+
+	e.list("IP_PROVIDERS", &c.PublicIP.Providers)
+	e.string("IP_CONSENSUS", &c.PublicIP.Consensus)
+	e.duration("IP_PROVIDER_TIMEOUT", &c.PublicIP.Timeout)
+
+	e.list("PROBE_TARGETS", &c.Probe.Targets)
+	e.duration("PROBE_INTERVAL", &c.Probe.Interval)
+
+	c.Modems.Backend = strings.ToLower(c.Modems.Backend)
+	return e.err
+}
+
+func (c *Config) validate() error {
+	if _, port, err := net.SplitHostPort(c.Listen); err != nil || port == "" {
+		return fmt.Errorf("listen inválido: %q (use host:porta)", c.Listen)
+	}
+	if c.DataDir == "" {
+		return fmt.Errorf("data_dir é obrigatório")
+	}
+
+	p := c.Ports
+	if p.MaxModems < 1 || p.MaxModems > 1000 {
+		return fmt.Errorf("ports.max_modems deve estar entre 1 e 1000")
+	}
+	for _, base := range []int{p.BaseHTTP, p.BaseSOCKS} {
+		if base < 1 || base+p.MaxModems > 65535 {
+			return fmt.Errorf("faixa de portas %d-%d inválida", base, base+p.MaxModems)
+		}
+	}
+	if p.BaseHTTP <= p.BaseSOCKS+p.MaxModems && p.BaseSOCKS <= p.BaseHTTP+p.MaxModems {
+		return fmt.Errorf("faixas HTTP (%d-%d) e SOCKS5 (%d-%d) se sobrepõem",
+			p.BaseHTTP, p.BaseHTTP+p.MaxModems, p.BaseSOCKS, p.BaseSOCKS+p.MaxModems)
+	}
+
+	switch c.Modems.Backend {
+	case "mmcli", "dbus", "fake":
+	default:
+		return fmt.Errorf("modems.backend desconhecido: %s (use mmcli, dbus ou fake)", c.Modems.Backend)
+	}
+	switch c.Network.Mode {
+	case "", "ip", "noop":
+	default:
+		return fmt.Errorf("network.mode desconhecido: %s (use ip ou noop)", c.Network.Mode)
+	}
+	if c.Network.RenewReleaseWait < 0 {
+		return fmt.Errorf("network.renew_release_wait não pode ser negativo")
+	}
+
+	if c.StatusCacheTTL < 0 || c.Scheduler.Stagger < 0 {
+		return fmt.Errorf("status_cache_ttl e scheduler.stagger não podem ser negativos")
+	}
+	positive := map[string]jsonDuration{
+		"sms.check_interval":      c.SMS.CheckInterval,
+		"gateway.session_ttl":     c.Gateway.SessionTTL,
+		"public_ip.timeout":       c.PublicIP.Timeout,
+		"probe.interval":          c.Probe.Interval,
+		"modems.history_interval": c.Modems.HistoryInterval,
+	}
+	for name, value := range positive {
+		if value <= 0 {
+			return fmt.Errorf("%s deve ser maior que zero", name)
+		}
+	}
+	if c.Modems.HistoryRetention < jsonDuration(24*time.Hour) {
+		return fmt.Errorf("modems.history_retention deve ser pelo menos 24h")
+	}
+	if c.SMS.MaxHistory < 1 {
+		return fmt.Errorf("sms.max_history deve ser pelo menos 1")
+	}
+
+	if !validStrategy(c.Gateway.Strategy) {
+		return fmt.Errorf("gateway.strategy desconhecida: %s", c.Gateway.Strategy)
+	}
+	if c.PublicIP.Consensus != ConsensusMajority && c.PublicIP.Consensus != ConsensusFirst {
+		return fmt.Errorf("public_ip.consensus desconhecido: %s (use majority ou first)", c.PublicIP.Consensus)
+	}
+
+	if len(c.Probe.Targets) == 0 {
+		return fmt.Errorf("probe.targets precisa de pelo menos um alvo")
+	}
+	for _, target := range c.Probe.Targets {
+		if parsed, err := url.Parse(target); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return fmt.Errorf("alvo de sondagem inválido: %q", target)
+		}
+	}
+	return nil
+}
+
+// ============================================================================
+// CONFIGURAÇÃO - RECARGA
+// ============================================================================
+
+// ConfigReload descreve o resultado de uma recarga pelos caminhos YAML
+// ("sms.max_history").
+type ConfigReload struct {
+	File            string   `json:"file,omitempty"`
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+type ConfigStore struct {
+	mu      sync.RWMutex
+	path    string
+	current *Config
+}
+
+var configStore = &ConfigStore{current: defaultConfig()}
+
+func currentConfig() *Config {
+	configStore.mu.RLock()
+	defer configStore.mu.RUnlock()
+	return configStore.current
+}
+
+func (cs *ConfigStore) Path() string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.path
+}
+
+func (cs *ConfigStore) Load() error {
+	cfg, path, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	cs.mu.Lock()
+	cs.current = cfg
+	cs.path = path
+	cs.mu.Unlock()
+	return nil
+}
+
+// Reload relê arquivo e ambiente. Os campos que só valem no restart mantêm
+// o valor em uso e são listados em RestartRequired.
+func (cs *ConfigStore) Reload() (ConfigReload, error) {
+	cfg, path, err := loadConfig()
+	if err != nil {
+		return ConfigReload{}, err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	reload := ConfigReload{File: path, Applied: make([]string, 0), RestartRequired: make([]string, 0)}
+	diffConfig(reflect.ValueOf(cs.current).Elem(), reflect.ValueOf(cfg).Elem(), "", &reload)
+
+	changed := make(map[string]bool)
+	for _, key := range reload.Applied {
+		changed[key] = true
+	}
+
+	// A descoberta de IP é montada antes de trocar a configuração: um
+	// provedor inválido cancela a recarga inteira.
+	var discovery *IPDiscovery
+	if changed["public_ip.providers"] || changed["public_ip.consensus"] || changed["public_ip.timeout"] {
+		if discovery, err = newIPDiscovery(cfg.PublicIP, modemBackend); err != nil {
+			return ConfigReload{}, err
+		}
+	}
+
+	cs.current = cfg
+	cs.path = path
+
+	if discovery != nil {
+		setIPDiscovery(discovery)
+	}
+	if changed["sms.max_history"] {
+		smsManager.SetMaxHistory(cfg.SMS.MaxHistory)
+	}
+	if changed["gateway.strategy"] && gateway != nil {
+		gateway.SetStrategy(cfg.Gateway.Strategy)
+	}
+	if changed["gateway.session_ttl"] {
+		sessionManager.SetTTL(time.Duration(cfg.Gateway.SessionTTL))
+	}
+	if changed["scheduler.stagger"] {
+		scheduler.SetStagger(time.Duration(cfg.Scheduler.Stagger))
+	}
+	if changed["probe.targets"] {
+		healthChecker.SetTargets(cfg.Probe.Targets)
+	}
+	if changed["probe.interval"] {
+		healthChecker.SetInterval(time.Duration(cfg.Probe.Interval))
+	}
+
+	for _, key := range reload.Applied {
+		log.Printf("⚙️  Configuração %s recarregada", key)
+	}
+	for _, key := range reload.RestartRequired {
+		log.Printf("⚠️  Configuração %s alterada: vale só depois do restart", key)
+	}
+	return reload, nil
+}
+
+// diffConfig compara os campos folha de old e new. Os que mudaram sem
+// reload:"true" voltam ao valor de old em new.
+func diffConfig(old, new reflect.Value, prefix string, reload *ConfigReload) {
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		path := prefix + name
+
+		if field.Type.Kind() == reflect.Struct {
+			diffConfig(old.Field(i), new.Field(i), path+".", reload)
+			continue
+		}
+		if reflect.DeepEqual(old.Field(i).Interface(), new.Field(i).Interface()) {
+			continue
+		}
+
+		if field.Tag.Get("reload") == "true" {
+			reload.Applied = append(reload.Applied, path)
+		} else {
+			reload.RestartRequired = append(reload.RestartRequired, path)
+			new.Field(i).Set(old.Field(i))
+		}
+	}
+}
+
+// watchReloadSignal recarrega a configuração a cada SIGHUP
+// (systemctl reload proxy-api).
+func watchReloadSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		log.Println("⚙️  SIGHUP recebido: recarregando configuração")
+		if _, err := configStore.Reload(); err != nil {
+			log.Printf("❌ Erro ao recarregar configuração: %v", err)
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// ============================================================================
+// BACKEND DE MODEMS - D-BUS (ModemManager1)
+// ============================================================================
+
+const (
+	mmService        = "org.freedesktop.ModemManager1"
+	mmPath           = dbus.ObjectPath("/org/freedesktop/ModemManager1")
+	mmModemIface     = mmService + ".Modem"
+	mmSimpleIface    = mmModemIface + ".Simple"
+	mm3gppIface      = mmModemIface + ".Modem3gpp"
+	mmSignalIface    = mmModemIface + ".Signal"
+	mmMessagingIface = mmModemIface + ".Messaging"
+	mmBearerIface    = mmService + ".Bearer"
+	mmSmsIface       = mmService + ".Sms"
+	mmSimIface       = mmService + ".Sim"
+
+	dbusPropertiesIface    = "org.freedesktop.DBus.Properties"
+	dbusObjectManagerIface = "org.freedesktop.DBus.ObjectManager"
+)
+
+// Enums do ModemManager, com os mesmos nomes exibidos pelo mmcli.
+var (
+	mmModemStates = map[int32]string{
+		-1: "failed", 0: "unknown", 1: "initializing", 2: "locked", 3: "disabled",
+		4: "disabling", 5: "enabling", 6: "enabled", 7: "searching",
+		8: "registered", 9: "disconnecting", 10: "connecting", 11: "connected",
+	}
+	mmPowerStates = []string{"unknown", "off", "low", "on"}
+	mmSmsStates   = []string{"unknown", "stored", "receiving", "received", "sending", "sent"}
+	mmIPFamilies  = map[string]uint32{"ipv4": 1, "ipv6": 2, "ipv4v6": 4}
+	mmCellTypes   = []string{"unknown", "cdma", "gsm", "umts", "tdscdma", "lte", "5gnr"}
+
+	mmRegistrationStates = []string{
+		"idle", "home", "searching", "denied", "unknown", "roaming",
+		"home-sms-only", "roaming-sms-only", "emergency-only",
+		"home-csfb-not-preferred", "roaming-csfb-not-preferred", "attached-rlos",
+	}
+
+	// Dicionários da interface Signal, na ordem de preferência.
+	mmSignalTechs = []struct {
+		property, name string
+	}{
+		{"Lte", "lte"}, {"Nr5g", "5gnr"}, {"Umts", "umts"}, {"Gsm", "gsm"},
+	}
+
+	// AccessTechnologies é uma máscara de bits: o bit i corresponde ao nome i.
+	mmAccessTechs = []string{
+		"pots", "gsm", "gsm-compact", "gprs", "edge", "umts", "hsdpa", "hsupa",
+		"hspa", "hspa-plus", "1xrtt", "evdo0", "evdoa", "evdob", "lte", "5gnr",
+		"lte-cat-m", "lte-nb-iot",
+	}
+)
+
+// DBusBackend fala diretamente com o org.freedesktop.ModemManager1.
+type DBusBackend struct {
+	conn *dbus.Conn
+}
+
+func NewDBusBackend(conn *dbus.Conn) *DBusBackend {
+	return &DBusBackend{conn: conn}
+}
+
+// ConnectDBusBackend abre a conexão indicada: "system" (padrão), "session"
+// ou um endereço D-Bus explícito (ex.: barramento privado com um mock).
+func ConnectDBusBackend(address string) (*DBusBackend, error) {
+	var conn *dbus.Conn
+	var err error
+
+	switch address {
+	case "", "system":
+		conn, err = dbus.ConnectSystemBus()
+	case "session":
+		conn, err = dbus.ConnectSessionBus()
+	default:
+		conn, err = dbus.Connect(address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao conectar no D-Bus: %v", err)
+	}
+
+	return NewDBusBackend(conn), nil
+}
+
+func (b *DBusBackend) Name() string {
+	return "dbus"
+}
+
+func modemPath(modemID string) dbus.ObjectPath {
+	return mmPath + "/Modem/" + dbus.ObjectPath(modemID)
+}
+
+func bearerPath(bearerID string) dbus.ObjectPath {
+	return mmPath + "/Bearer/" + dbus.ObjectPath(bearerID)
+}
+
+func smsPath(smsID string) dbus.ObjectPath {
+	return mmPath + "/SMS/" + dbus.ObjectPath(smsID)
+}
+
+func simPath(simID string) dbus.ObjectPath {
+	return mmPath + "/SIM/" + dbus.ObjectPath(simID)
+}
+
+func objectID(p dbus.ObjectPath) string {
+	return path.Base(string(p))
+}
+
+func (b *DBusBackend) call(ctx context.Context, p dbus.ObjectPath, method string, args ...interface{}) *dbus.Call {
+	return b.conn.Object(mmService, p).CallWithContext(ctx, method, 0, args...)
+}
+
+func (b *DBusBackend) properties(ctx context.Context, p dbus.ObjectPath, iface string) (map[string]dbus.Variant, error) {
+	props := make(map[string]dbus.Variant)
+	if err := b.call(ctx, p, dbusPropertiesIface+".GetAll", iface).Store(&props); err != nil {
+		return nil, fmt.Errorf("erro ao ler propriedades de %s: %v", p, err)
+	}
+	return props, nil
+}
+
+func (b *DBusBackend) ListModems(ctx context.Context) ([]string, error) {
+	var objects map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	if err := b.call(ctx, mmPath, dbusObjectManagerIface+".GetManagedObjects").Store(&objects); err != nil {
+		return nil, fmt.Errorf("erro ao listar modems: %v", err)
+	}
+
+	ids := make([]string, 0, len(objects))
+	for p := range objects {
+		if strings.HasPrefix(string(p), string(mmPath)+"/Modem/") {
+			ids = append(ids, objectID(p))
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, _ := strconv.Atoi(ids[i])
+		c, _ := strconv.Atoi(ids[j])
+		return a < c
+	})
+	return ids, nil
+}
+
+func (b *DBusBackend) GetModem(ctx context.Context, modemID string) (*ModemInfo, error) {
+	props, err := b.properties(ctx, modemPath(modemID), mmModemIface)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ModemInfo{
+		ID:            modemID,
+		IMEI:          variantString(props["EquipmentIdentifier"]),
+		State:         mmModemStates[variantInt32(props["State"])],
+		PowerState:    enumName(mmPowerStates, variantUint32(props["PowerState"])),
+		SignalQuality: -1,
+		Bearers:       make([]string, 0),
+	}
+
+	if info.State == "" {
+		info.State = "unknown"
+	}
+
+	// SignalQuality é (ub): percentual e se o valor é recente.
+	if quality, ok := props["SignalQuality"].Value().([]interface{}); ok && len(quality) > 0 {
+		if value, ok := quality[0].(uint32); ok {
+			info.SignalQuality = int(value)
+		}
+	}
+
+	if bearers, ok := props["Bearers"].Value().([]dbus.ObjectPath); ok {
+		for _, p := range bearers {
+			info.Bearers = append(info.Bearers, objectID(p))
+		}
+	}
+
+	info.AccessTech = accessTechNames(variantUint32(props["AccessTechnologies"]))
+
+	// Sem SIM o ModemManager publica "/"
+	if sim, ok := props["Sim"].Value().(dbus.ObjectPath); ok && sim != "/" {
+		info.SIM = objectID(sim)
+	}
+
+	return info, nil
+}
+
+// GetModemDetails lê as interfaces Modem, Modem3gpp e Signal e a célula
+// servidora (GetCellInfo, ModemManager 1.20+). Sinal e célula são opcionais.
+func (b *DBusBackend) GetModemDetails(ctx context.Context, modemID string) (*ModemDetails, error) {
+	p := modemPath(modemID)
+
+	props, err := b.properties(ctx, p, mmModemIface)
+	if err != nil {
+		return nil, err
+	}
+
+	details := &ModemDetails{
+		Manufacturer: variantString(props["Manufacturer"]),
+		Model:        variantString(props["Model"]),
+		Firmware:     variantString(props["Revision"]),
+	}
+	details.OwnNumbers, _ = props["OwnNumbers"].Value().([]string)
+
+	if gpp, err := b.properties(ctx, p, mm3gppIface); err == nil {
+		details.OperatorName = variantString(gpp["OperatorName"])
+		details.OperatorCode = variantString(gpp["OperatorCode"])
+		details.RegistrationState = enumName(mmRegistrationStates, variantUint32(gpp["RegistrationState"]))
+	}
+
+	details.Signal = b.extendedSignal(ctx, p)
+	details.Cell = b.servingCell(ctx, p)
+	return details, nil
+}
+
+// extendedSignal lê a interface Signal. Com Rate 0 o ModemManager não mede
+// nada: a leitura é configurada e o sinal só aparece na próxima consulta.
+func (b *DBusBackend) extendedSignal(ctx context.Context, p dbus.ObjectPath) *SignalInfo {
+	props, err := b.properties(ctx, p, mmSignalIface)
+	if err != nil {
+		return nil
+	}
+
+	if variantUint32(props["Rate"]) == 0 {
+		if err := b.call(ctx, p, mmSignalIface+".Setup", uint32(signalRefreshRate)).Err; err != nil {
+			log.Printf("⚠️  Erro ao configurar sinal estendido do modem %s: %v", objectID(p), err)
+		}
+		return nil
+	}
+
+	for _, tech := range mmSignalTechs {
+		values, ok := props[tech.property].Value().(map[string]dbus.Variant)
+		if !ok {
+			continue
+		}
+		signal := &SignalInfo{
+			Tech: tech.name,
+			RSSI: variantFloat(values["rssi"]),
+			RSRP: variantFloat(values["rsrp"]),
+			RSRQ: variantFloat(values["rsrq"]),
+			SINR: variantFloat(values["snr"]),
+		}
+		if signal.RSSI != nil || signal.RSRP != nil {
+			return signal
+		}
+	}
+	return nil
+}
+
+func (b *DBusBackend) servingCell(ctx context.Context, p dbus.ObjectPath) *CellInfo {
+	var cells []map[string]dbus.Variant
+	if err := b.call(ctx, p, mmModemIface+".GetCellInfo").Store(&cells); err != nil {
+		return nil
+	}
+
+	for _, c := range cells {
+		if serving, _ := c["serving"].Value().(bool); !serving {
+			continue
+		}
+		cell := &CellInfo{
+			Type:         enumName(mmCellTypes, variantUint32(c["cell-type"])),
+			OperatorCode: variantString(c["operator-id"]),
+			CellID:       variantString(c["ci"]),
+			AreaCode:     variantString(c["tac"]),
+			PhysicalID:   variantString(c["physical-ci"]),
+		}
+		if cell.AreaCode == "" {
+			cell.AreaCode = variantString(c["lac"])
+		}
+		for _, key := range []string{"earfcn", "nrarfcn", "uarfcn", "arfcn"} {
+			if n := variantUint32(c[key]); n > 0 {
+				cell.ARFCN = int(n)
+				break
+			}
+		}
+		cell.fillBand()
+		return cell
+	}
+	return nil
+}
+
+func (b *DBusBackend) GetSIM(ctx context.Context, simID string) (*SIMInfo, error) {
+	props, err := b.properties(ctx, simPath(simID), mmSimIface)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SIMInfo{
+		ID:           simID,
+		ICCID:        variantString(props["SimIdentifier"]),
+		IMSI:         variantString(props["Imsi"]),
+		OperatorCode: variantString(props["OperatorIdentifier"]),
+		OperatorName: variantString(props["OperatorName"]),
+	}, nil
+}
+
+func (b *DBusBackend) GetBearer(ctx context.Context, bearerID string) (*BearerInfo, error) {
+	props, err := b.properties(ctx, bearerPath(bearerID), mmBearerIface)
+	if err != nil {
+		return nil, err
+	}
+
+	bearer := &BearerInfo{
+		ID:        bearerID,
+		Interface: variantString(props["Interface"]),
+	}
+	bearer.Connected, _ = props["Connected"].Value().(bool)
+
+	if config, ok := props["Ip4Config"].Value().(map[string]dbus.Variant); ok {
+		bearer.Address = variantString(config["address"])
+		bearer.Prefix = int(variantUint32(config["prefix"]))
+		bearer.Gateway = variantString(config["gateway"])
+		bearer.MTU = int(variantUint32(config["mtu"]))
+
+		for _, key := range []string{"dns1", "dns2", "dns3"} {
+			if dns := variantString(config[key]); dns != "" {
+				bearer.DNS = append(bearer.DNS, dns)
+			}
+		}
+	}
+
+	return bearer, nil
+}
+
+func (b *DBusBackend) ListSMS(ctx context.Context, modemID string) ([]string, error) {
+	var paths []dbus.ObjectPath
+	if err := b.call(ctx, modemPath(modemID), mmMessagingIface+".List").Store(&paths); err != nil {
+		return nil, fmt.Errorf("erro ao listar SMS: %v", err)
+	}
+
+	ids := make([]string, 0, len(paths))
+	for _, p := range paths {
+		ids = append(ids, objectID(p))
+	}
+	return ids, nil
+}
+
+func (b *DBusBackend) GetSMS(ctx context.Context, modemID, smsID string) (*SMS, error) {
+	props, err := b.properties(ctx, smsPath(smsID), mmSmsIface)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SMS{
+		ID:        smsID,
+		ModemID:   modemID,
+		Number:    variantString(props["Number"]),
+		Text:      variantString(props["Text"]),
+		Timestamp: variantString(props["Timestamp"]),
+		State:     enumName(mmSmsStates, variantUint32(props["State"])),
+		Received:  time.Now(),
+	}, nil
+}
+
+func (b *DBusBackend) CreateSMS(ctx context.Context, modemID, number, text string) (string, error) {
+	properties := map[string]dbus.Variant{
+		"number": dbus.MakeVariant(number),
+		"text":   dbus.MakeVariant(text),
+	}
+
+	var p dbus.ObjectPath
+	if err := b.call(ctx, modemPath(modemID), mmMessagingIface+".Create", properties).Store(&p); err != nil {
+		return "", fmt.Errorf("erro ao criar SMS: %v", err)
+	}
+	return objectID(p), nil
+}
+
+func (b *DBusBackend) SendSMS(ctx context.Context, modemID, smsID string) error {
+	return b.call(ctx, smsPath(smsID), mmSmsIface+".Send").Err
+}
+
+func (b *DBusBackend) DeleteSMS(ctx context.Context, modemID, smsID string) error {
+	return b.call(ctx, modemPath(modemID), mmMessagingIface+".Delete", smsPath(smsID)).Err
+}
+
+func (b *DBusBackend) SetPowerState(ctx context.Context, modemID string, state PowerState) error {
+	for i, name := range mmPowerStates {
+		if name == string(state) {
+			return b.call(ctx, modemPath(modemID), mmModemIface+".SetPowerState", uint32(i)).Err
+		}
+	}
+	return fmt.Errorf("estado de energia inválido: %s", state)
+}
+
+func (b *DBusBackend) Connect(ctx context.Context, modemID string, params ConnectParams) (string, error) {
+	ipType := params.IPType
+	if ipType == "" {
+		ipType = "ipv4"
+	}
+
+	properties := map[string]dbus.Variant{
+		"apn":     dbus.MakeVariant(params.APN),
+		"ip-type": dbus.MakeVariant(mmIPFamilies[ipType]),
+	}
+	if params.User != "" {
+		properties["user"] = dbus.MakeVariant(params.User)
+	}
+	if params.Password != "" {
+		properties["password"] = dbus.MakeVariant(params.Password)
+	}
+
+	var p dbus.ObjectPath
+	if err := b.call(ctx, modemPath(modemID), mmSimpleIface+".Connect", properties).Store(&p); err != nil {
+		return "", fmt.Errorf("erro ao conectar modem %s: %v", modemID, err)
+	}
+	return objectID(p), nil
+}
+
+func (b *DBusBackend) Disconnect(ctx context.Context, modemID string) error {
+	// "/" desconecta todos os bearers do modem.
+	return b.call(ctx, modemPath(modemID), mmSimpleIface+".Disconnect", dbus.ObjectPath("/")).Err
+}
+
+func variantString(v dbus.Variant) string {
+	s, _ := v.Value().(string)
+	return s
+}
+
+func variantUint32(v dbus.Variant) uint32 {
+	n, _ := v.Value().(uint32)
+	return n
+}
+
+// variantFloat lê medidas em double; nil se ausentes.
+func variantFloat(v dbus.Variant) *float64 {
+	n, ok := v.Value().(float64)
+	if !ok {
+		return nil
+	}
+	return &n
+}
+
+func variantInt32(v dbus.Variant) int32 {
+	n, _ := v.Value().(int32)
+	return n
+}
+
+// accessTechNames formata a máscara como o mmcli ("umts, lte").
+func accessTechNames(mask uint32) string {
+	names := make([]string, 0)
+	for i, name := range mmAccessTechs {
+		if mask&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func enumName(names []string, value uint32) string {
+	if int(value) < len(names) {
+		return names[value]
+	}
+	return names[0]
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// startPrivateBus sobe um dbus-daemon de sessão só para o teste e devolve o
+// endereço dele.
+func startPrivateBus(t *testing.T) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon não instalado")
+	}
+
+	cmd := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("erro ao iniciar dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("erro ao ler endereço do dbus-daemon: %v", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+// newMockedDBusBackend publica o backend fake como ModemManager num
+// barramento privado e devolve um DBusBackend conectado a ele.
+func newMockedDBusBackend(t *testing.T, script *FakeScript) *DBusBackend {
+	t.Helper()
+	address := startPrivateBus(t)
+
+	service, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatalf("erro ao conectar o mock: %v", err)
+	}
+	t.Cleanup(func() { service.Close() })
+	if err := ExportMockModemManager(service, NewFakeBackend(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatalf("erro ao conectar o cliente: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return NewDBusBackend(client)
+}
+
+func TestDBusBackendRoundTrip(t *testing.T) {
+	script := testFakeScript()
+	script.Modems[1].PluggedAt = 0
+	b := newMockedDBusBackend(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	modems, err := b.ListModems(ctx)
+	if err != nil {
+		t.Fatalf("ListModems: %v", err)
+	}
+	if strings.Join(modems, ",") != "0,1" {
+		t.Fatalf("modems = %v, esperado [0 1]", modems)
+	}
+
+	info, err := b.GetModem(ctx, "0")
+	if err != nil {
+		t.Fatalf("GetModem: %v", err)
+	}
+	if info.IMEI != "866000000000010" || info.State != "connected" || info.SignalQuality != 80 || info.AccessTech != "lte" {
+		t.Errorf("GetModem = %+v", info)
+	}
+
+	sim, err := b.GetSIM(ctx, info.SIM)
+	if err != nil {
+		t.Fatalf("GetSIM: %v", err)
+	}
+	if sim.ICCID != "8955101000000000001" || sim.OperatorCode != "72406" {
+		t.Errorf("GetSIM = %+v", sim)
+	}
+
+	details, err := b.GetModemDetails(ctx, "0")
+	if err != nil {
+		t.Fatalf("GetModemDetails: %v", err)
+	}
+	if details.RegistrationState != "home" || details.OperatorCode != "72406" {
+		t.Errorf("GetModemDetails = %+v", details)
+	}
+
+	// Connect: falha roteirizada, APN recusado e, por fim, sucesso
+	params := ConnectParams{APN: "zap.vivo.com.br", IPType: "ipv4"}
+	if _, err := b.Connect(ctx, "0", params); err == nil {
+		t.Fatal("primeiro connect deveria falhar (connect_failures: 1)")
+	}
+	if _, err := b.Connect(ctx, "0", ConnectParams{APN: "claro.com.br", IPType: "ipv4"}); err == nil {
+		t.Fatal("connect com APN de outra operadora deveria falhar")
+	}
+	bearerID, err := b.Connect(ctx, "0", params)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	bearer, err := b.GetBearer(ctx, bearerID)
+	if err != nil {
+		t.Fatalf("GetBearer: %v", err)
+	}
+	if !bearer.Connected || bearer.Interface != "wwan0" || bearer.Address != "10.64.0.6" || bearer.Gateway != "10.64.0.1" {
+		t.Errorf("GetBearer = %+v", bearer)
+	}
+
+	if err := b.Disconnect(ctx, "0"); err != nil {
+		t.Fatalf("Disconnect: %v", err)
+	}
+	if info, err := b.GetModem(ctx, "0"); err != nil || info.State != "registered" {
+		t.Errorf("após Disconnect: %+v, %v", info, err)
+	}
+
+	// SMS: criar, enviar, ler e apagar
+	smsID, err := b.CreateSMS(ctx, "1", "+5511988887777", "olá")
+	if err != nil {
+		t.Fatalf("CreateSMS: %v", err)
+	}
+	if err := b.SendSMS(ctx, "1", smsID); err != nil {
+		t.Fatalf("SendSMS: %v", err)
+	}
+	ids, err := b.ListSMS(ctx, "1")
+	if err != nil || len(ids) != 1 || ids[0] != smsID {
+		t.Fatalf("ListSMS = %v, %v; esperado [%s]", ids, err, smsID)
+	}
+	sms, err := b.GetSMS(ctx, "1", smsID)
+	if err != nil {
+		t.Fatalf("GetSMS: %v", err)
+	}
+	if sms.Number != "+5511988887777" || sms.Text != "olá" || sms.State != "sent" {
+		t.Errorf("GetSMS = %+v", sms)
+	}
+	if err := b.DeleteSMS(ctx, "1", smsID); err != nil {
+		t.Fatalf("DeleteSMS: %v", err)
+	}
+	if ids, err := b.ListSMS(ctx, "1"); err != nil || len(ids) != 0 {
+		t.Errorf("ListSMS após DeleteSMS = %v, %v", ids, err)
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ============================================================================
+// EVENTOS
+// ============================================================================
+
+const (
+	EventModemAdded        = "modem.added"
+	EventModemRemoved      = "modem.removed"
+	EventModemStateChanged = "modem.state_changed"
+	EventModemAttached     = "modem.attached"
+	EventModemAttachFailed = "modem.attach_failed"
+	EventModemDetached     = "modem.detached"
+	EventProxyUp           = "proxy.up"
+	EventProxyDown         = "proxy.down"
+	EventRenewStarted      = "renew.started"
+	EventRenewProgress     = "renew.progress"
+	EventRenewFinished     = "renew.finished"
+	EventSMSReceived       = "sms.received"
+	EventSMSSent           = "sms.sent"
+
+	eventsBuffer     = 256 // eventos guardados para reenvio (Last-Event-ID)
+	eventsClientSize = 64  // eventos pendentes por cliente antes de desconectá-lo
+)
+
+// eventTypes são todos os tipos publicados, usados para validar filtros.
+var eventTypes = []string{
+	EventModemAdded, EventModemRemoved, EventModemStateChanged,
+	EventModemAttached, EventModemAttachFailed, EventModemDetached,
+	EventProxyUp, EventProxyDown,
+	EventRenewStarted, EventRenewProgress, EventRenewFinished,
+	EventSMSReceived, EventSMSSent,
+	"supervisor." + EventModemDown, "supervisor." + EventReconnectFailed, "supervisor." + EventReconnected,
+}
+
+// Event é o que chega aos clientes de /events. ID cresce a cada evento e
+// serve para retomar o stream de onde parou.
+type Event struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	At   time.Time   `json:"at"`
+	Data interface{} `json:"data"`
+}
+
+type eventSubscriber struct {
+	ch    chan Event
+	types []string
+}
+
+// EventBus distribui os eventos publicados para os inscritos. Publish nunca
+// bloqueia: um inscrito com a fila cheia é desconectado e, se quiser, volta
+// com Last-Event-ID para receber o que perdeu.
+type EventBus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	recent      []Event
+	subscribers map[*eventSubscriber]bool
+}
+
+var eventBus = &EventBus{
+	recent:      make([]Event, 0, eventsBuffer),
+	subscribers: make(map[*eventSubscriber]bool),
+}
+
+// matchEventType aceita o tipo exato ("renew.finished") ou a categoria
+// ("renew"). Sem filtros, tudo passa.
+func matchEventType(types []string, eventType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, filter := range types {
+		if filter == eventType || strings.HasPrefix(eventType, filter+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *EventBus) Publish(eventType string, data interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	event := Event{ID: b.nextID, Type: eventType, At: time.Now(), Data: data}
+
+	b.recent = append(b.recent, event)
+	if len(b.recent) > eventsBuffer {
+		b.recent = b.recent[len(b.recent)-eventsBuffer:]
+	}
+
+	for sub := range b.subscribers {
+		if !matchEventType(sub.types, eventType) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// Subscribe inscreve um cliente. Com lastID > 0, os eventos guardados depois
+// dele são entregues primeiro.
+func (b *EventBus) Subscribe(types []string, lastID uint64) *eventSubscriber {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &eventSubscriber{ch: make(chan Event, eventsClientSize+eventsBuffer), types: types}
+	if lastID > 0 {
+		for _, event := range b.recent {
+			if event.ID > lastID && matchEventType(types, event.Type) {
+				sub.ch <- event
+			}
+		}
+	}
+	b.subscribers[sub] = true
+	return sub
+}
+
+func (b *EventBus) Unsubscribe(sub *eventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscribers[sub] {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// ============================================================================
+// EVENTOS - MODEMS
+// ============================================================================
+
+// watchModems compara a lista e o estado dos modems a cada
+// MODEM_WATCH_INTERVAL, publica o que mudou e repassa ao hotplug os modems
+// removidos e, depois, os que apareceram. A primeira leitura só serve de
+// referência.
+func watchModems() {
+	states := make(map[string]string)
+	first := true
+
+	ticker := time.NewTicker(MODEM_WATCH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ids, err := modemBackend.ListModems(ctx)
+		if err == nil {
+			seen := make(map[string]bool)
+			added := make([]string, 0)
+			for _, id := range ids {
+				seen[id] = true
+
+				info, err := modemBackend.GetModem(ctx, id)
+				if err != nil {
+					continue
+				}
+
+				previous, known := states[id]
+				states[id] = info.State
+				switch {
+				case first:
+				case !known:
+					log.Printf("📶 Modem %s apareceu (%s)", id, info.State)
+					eventBus.Publish(EventModemAdded, map[string]interface{}{
+						"modem_id": id,
+						"state":    info.State,
+					})
+					added = append(added, id)
+				case previous != info.State:
+					eventBus.Publish(EventModemStateChanged, map[string]interface{}{
+						"modem_id": id,
+						"state":    info.State,
+						"previous": previous,
+					})
+				}
+			}
+
+			for id := range states {
+				if !seen[id] {
+					delete(states, id)
+					log.Printf("📶 Modem %s sumiu", id)
+					eventBus.Publish(EventModemRemoved, map[string]interface{}{
+						"modem_id": id,
+					})
+					hotplug.Removed(id)
+				}
+			}
+
+			for _, id := range added {
+				hotplug.Added(id)
+			}
+			first = false
+		}
+		cancel()
+
+		<-ticker.C
+	}
+}
+
+// ============================================================================
+// HANDLERS - EVENTOS
+// ============================================================================
+
+var eventsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || strings.TrimPrefix(strings.TrimPrefix(origin, "http://"), "https://") == r.Host {
+			return true
+		}
+		origins := allowedOrigins()
+		return origins["*"] || origins[origin]
+	},
+}
+
+// eventsHandler serve /events como Server-Sent Events ou, se o cliente pedir
+// upgrade, como WebSocket (uma mensagem JSON por evento). ?types= filtra por
+// tipo ou categoria; Last-Event-ID (ou ?last_event_id=) retoma o stream.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	var types []string
+	for _, t := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	lastID, _ := strconv.ParseUint(lastEventID, 10, 64)
+
+	if websocket.IsWebSocketUpgrade(r) {
+		serveEventsWebSocket(w, r, types, lastID)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Streaming não suportado",
+		})
+		return
+	}
+
+	sub := eventBus.Subscribe(types, lastID)
+	defer eventBus.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", EVENTS_RETRY.Milliseconds())
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(EVENTS_HEARTBEAT)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func serveEventsWebSocket(w http.ResponseWriter, r *http.Request, types []string, lastID uint64) {
+	conn, err := eventsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sub := eventBus.Subscribe(types, lastID)
+	defer eventBus.Unsubscribe(sub)
+
+	// O cliente não envia nada; a leitura só detecta o fechamento.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(EVENTS_HEARTBEAT)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
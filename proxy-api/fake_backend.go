@@ -0,0 +1,600 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// BACKEND DE MODEMS - FAKE (ROTEIRO EM MEMÓRIA)
+// ============================================================================
+
+// FakeScript descreve os modems simulados. Cada modem reproduz sua timeline
+// (estado/sinal em instantes relativos ao início) e recebe os SMS roteirizados;
+// as operações chamadas pela API (connect, disconnect, power) alteram o estado
+// até o próximo frame da timeline.
+type FakeScript struct {
+	Modems []FakeModemScript `json:"modems"`
+}
+
+type FakeModemScript struct {
+	ID              string         `json:"id"`
+	Interface       string         `json:"interface"`
+	Addresses       []string       `json:"addresses"`
+	Gateway         string         `json:"gateway"`
+	Prefix          int            `json:"prefix"`
+	PublicIPs       []string       `json:"public_ips"`
+	ConnectFailures int            `json:"connect_failures"`
+	Timeline        []FakeFrame    `json:"timeline"`
+	Inbox           []FakeInboxSMS `json:"sms"`
+
+	// SIM é o cartão do modem (o ID é o do modem). Com APN definido, o
+	// connect com outro APN é recusado, como faria a operadora.
+	SIM  *SIMInfo `json:"sim,omitempty"`
+	APN  string   `json:"apn,omitempty"`
+	IMEI string   `json:"imei,omitempty"`
+
+	// Hardware e célula exibidos em GET /modems/{id}. O sinal estendido é
+	// derivado do percentual da timeline.
+	Manufacturer string    `json:"manufacturer,omitempty"`
+	Model        string    `json:"model,omitempty"`
+	Firmware     string    `json:"firmware,omitempty"`
+	OwnNumber    string    `json:"own_number,omitempty"`
+	Cell         *CellInfo `json:"cell,omitempty"`
+
+	// PluggedAt e UnpluggedAt simulam o hotplug: o modem só aparece na
+	// listagem nesse intervalo (zero é desde o início e até o fim).
+	PluggedAt   jsonDuration `json:"plugged_at,omitempty"`
+	UnpluggedAt jsonDuration `json:"unplugged_at,omitempty"`
+}
+
+type FakeFrame struct {
+	At         jsonDuration `json:"at"`
+	State      string       `json:"state"`
+	Signal     *int         `json:"signal,omitempty"`
+	AccessTech string       `json:"access_tech,omitempty"`
+}
+
+type FakeInboxSMS struct {
+	At     jsonDuration `json:"at"`
+	Number string       `json:"number"`
+	Text   string       `json:"text"`
+}
+
+// jsonDuration aceita durações no formato do Go ("30s", "2m") em JSON e,
+// pelos métodos de texto, no arquivo de configuração YAML.
+type jsonDuration time.Duration
+
+func (d *jsonDuration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(parsed)
+	return nil
+}
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *jsonDuration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(parsed)
+	return nil
+}
+
+func (d jsonDuration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func LoadFakeScript(path string) (*FakeScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler roteiro fake: %v", err)
+	}
+
+	var script FakeScript
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("roteiro fake inválido: %v", err)
+	}
+	return &script, nil
+}
+
+func defaultFakeScript() *FakeScript {
+	strong, weak := 78, 21
+	return &FakeScript{
+		Modems: []FakeModemScript{
+			{
+				ID:           "0",
+				Interface:    "wwan0",
+				Addresses:    []string{"10.64.0.2", "10.64.0.6", "10.64.0.10"},
+				Gateway:      "10.64.0.1",
+				Prefix:       30,
+				PublicIPs:    []string{"177.25.10.1", "177.25.10.2", "177.25.10.3"},
+				IMEI:         "866000000000010",
+				SIM:          &SIMInfo{ICCID: "8955101000000000001", IMSI: "724060000000001", OperatorCode: "72406", OperatorName: "VIVO"},
+				APN:          "zap.vivo.com.br",
+				Manufacturer: "Quectel",
+				Model:        "EC25",
+				Firmware:     "EC25AFFAR07A14M4G",
+				OwnNumber:    "+5511988880001",
+				Cell:         &CellInfo{Type: "lte", OperatorCode: "72406", CellID: "0A1B2C01", AreaCode: "2B3C", PhysicalID: "101", ARFCN: 1300},
+				Timeline: []FakeFrame{
+					{At: 0, State: "connected", Signal: &strong},
+				},
+				Inbox: []FakeInboxSMS{
+					{At: jsonDuration(time.Minute), Number: "+5511999990000", Text: "STATUS"},
+				},
+			},
+			{
+				ID:              "1",
+				Interface:       "wwan1",
+				Addresses:       []string{"10.65.0.2", "10.65.0.6"},
+				Gateway:         "10.65.0.1",
+				Prefix:          30,
+				PublicIPs:       []string{"189.40.20.1", "189.40.20.2"},
+				ConnectFailures: 1,
+				IMEI:            "866000000000028",
+				SIM:             &SIMInfo{ICCID: "8955050000000000002", IMSI: "724050000000002", OperatorCode: "72405", OperatorName: "Claro BR"},
+				APN:             "claro.com.br",
+				Manufacturer:    "Quectel",
+				Model:           "EG25-G",
+				Firmware:        "EG25GGBR07A08M2G",
+				OwnNumber:       "+5511977770002",
+				Cell:            &CellInfo{Type: "lte", OperatorCode: "72405", CellID: "0B2C3D02", AreaCode: "1A2B", PhysicalID: "57", ARFCN: 9410},
+				Timeline: []FakeFrame{
+					{At: 0, State: "connected", Signal: &strong},
+					{At: jsonDuration(10 * time.Minute), State: "registered", Signal: &weak},
+					{At: jsonDuration(12 * time.Minute), State: "connected", Signal: &strong},
+				},
+			},
+		},
+	}
+}
+
+type fakeModem struct {
+	script       FakeModemScript
+	state        string
+	powerState   string
+	signal       int
+	accessTech   string
+	bearerID     string
+	connects     int
+	failuresLeft int
+	nextFrame    int
+	nextInbox    int
+	messages     map[string]*SMS
+}
+
+// FakeBackend implementa ModemBackend inteiramente em memória.
+type FakeBackend struct {
+	mu         sync.Mutex
+	start      time.Time
+	modems     map[string]*fakeModem
+	bearers    map[string]*BearerInfo
+	nextBearer int
+	nextSMS    int
+}
+
+func NewFakeBackend(script *FakeScript) *FakeBackend {
+	b := &FakeBackend{
+		start:   time.Now(),
+		modems:  make(map[string]*fakeModem),
+		bearers: make(map[string]*BearerInfo),
+	}
+
+	for _, ms := range script.Modems {
+		m := &fakeModem{
+			script:       ms,
+			state:        "registered",
+			powerState:   "on",
+			signal:       -1,
+			accessTech:   "lte",
+			failuresLeft: ms.ConnectFailures,
+			messages:     make(map[string]*SMS),
+		}
+		b.modems[ms.ID] = m
+	}
+
+	return b
+}
+
+func (b *FakeBackend) Name() string {
+	return "fake"
+}
+
+// advance aplica os frames e SMS da timeline que já venceram.
+func (b *FakeBackend) advance(m *fakeModem) {
+	elapsed := time.Since(b.start)
+
+	for m.nextFrame < len(m.script.Timeline) && time.Duration(m.script.Timeline[m.nextFrame].At) <= elapsed {
+		frame := m.script.Timeline[m.nextFrame]
+		m.nextFrame++
+
+		if frame.Signal != nil {
+			m.signal = *frame.Signal
+		}
+		if frame.AccessTech != "" {
+			m.accessTech = frame.AccessTech
+		}
+		if frame.State == "" {
+			continue
+		}
+
+		m.state = frame.State
+		if frame.State == "connected" && (m.bearerID == "" || !b.bearers[m.bearerID].Connected) {
+			// Reconexão: novo bearer com o próximo endereço
+			b.dropBearer(m)
+			b.attachBearer(m)
+		} else if frame.State != "connected" && m.bearerID != "" {
+			b.bearers[m.bearerID].Connected = false
+		}
+	}
+
+	for m.nextInbox < len(m.script.Inbox) && time.Duration(m.script.Inbox[m.nextInbox].At) <= elapsed {
+		sms := m.script.Inbox[m.nextInbox]
+		m.nextInbox++
+		b.storeSMS(m, sms.Number, sms.Text, "received")
+	}
+}
+
+func (b *FakeBackend) attachBearer(m *fakeModem) {
+	b.nextBearer++
+	bearerID := strconv.Itoa(b.nextBearer)
+
+	address := ""
+	if len(m.script.Addresses) > 0 {
+		address = m.script.Addresses[m.connects%len(m.script.Addresses)]
+	}
+	m.connects++
+
+	b.bearers[bearerID] = &BearerInfo{
+		ID:        bearerID,
+		Interface: m.script.Interface,
+		Connected: true,
+		Address:   address,
+		Prefix:    m.script.Prefix,
+		Gateway:   m.script.Gateway,
+		DNS:       []string{"8.8.8.8", "8.8.4.4"},
+		MTU:       1500,
+	}
+	m.bearerID = bearerID
+}
+
+func (b *FakeBackend) storeSMS(m *fakeModem, number, text, state string) string {
+	b.nextSMS++
+	smsID := strconv.Itoa(b.nextSMS)
+	m.messages[smsID] = &SMS{
+		ID:        smsID,
+		ModemID:   m.script.ID,
+		Number:    number,
+		Text:      text,
+		Timestamp: time.Now().Format(time.RFC3339),
+		State:     state,
+	}
+	return smsID
+}
+
+// plugged informa se o modem está conectado à máquina agora.
+func (b *FakeBackend) plugged(m *fakeModem) bool {
+	elapsed := time.Since(b.start)
+	if elapsed < time.Duration(m.script.PluggedAt) {
+		return false
+	}
+	return m.script.UnpluggedAt == 0 || elapsed < time.Duration(m.script.UnpluggedAt)
+}
+
+func (b *FakeBackend) modem(modemID string) (*fakeModem, error) {
+	m, ok := b.modems[modemID]
+	if !ok || !b.plugged(m) {
+		return nil, fmt.Errorf("modem %s não encontrado", modemID)
+	}
+	b.advance(m)
+	return m, nil
+}
+
+func (b *FakeBackend) ListModems(ctx context.Context) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ids := make([]string, 0, len(b.modems))
+	for id, m := range b.modems {
+		if b.plugged(m) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, _ := strconv.Atoi(ids[i])
+		c, _ := strconv.Atoi(ids[j])
+		return a < c
+	})
+	return ids, nil
+}
+
+func (b *FakeBackend) GetModem(ctx context.Context, modemID string) (*ModemInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m, err := b.modem(modemID)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ModemInfo{
+		ID:            modemID,
+		IMEI:          m.script.IMEI,
+		State:         m.state,
+		PowerState:    m.powerState,
+		SignalQuality: m.signal,
+		AccessTech:    m.accessTech,
+		Bearers:       make([]string, 0),
+	}
+	if m.bearerID != "" {
+		info.Bearers = append(info.Bearers, m.bearerID)
+	}
+	if m.script.SIM != nil {
+		info.SIM = modemID
+	}
+	return info, nil
+}
+
+// GetModemDetails monta os detalhes a partir do roteiro: registrado, o modem
+// está na rede do próprio SIM (sem roaming).
+func (b *FakeBackend) GetModemDetails(ctx context.Context, modemID string) (*ModemDetails, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m, err := b.modem(modemID)
+	if err != nil {
+		return nil, err
+	}
+
+	details := &ModemDetails{
+		Manufacturer:      m.script.Manufacturer,
+		Model:             m.script.Model,
+		Firmware:          m.script.Firmware,
+		RegistrationState: "idle",
+	}
+	if m.script.OwnNumber != "" {
+		details.OwnNumbers = []string{m.script.OwnNumber}
+	}
+
+	switch m.state {
+	case "registered", "connecting", "connected", "disconnecting":
+		details.RegistrationState = "home"
+	case "searching":
+		details.RegistrationState = "searching"
+	}
+	if details.RegistrationState != "home" {
+		return details, nil
+	}
+
+	if m.script.SIM != nil {
+		details.OperatorCode = m.script.SIM.OperatorCode
+		details.OperatorName = m.script.SIM.OperatorName
+	}
+	if m.signal >= 0 {
+		details.Signal = fakeExtendedSignal(m.accessTech, m.signal)
+	}
+	if m.script.Cell != nil {
+		cell := *m.script.Cell
+		cell.fillBand()
+		details.Cell = &cell
+	}
+	return details, nil
+}
+
+// fakeExtendedSignal converte o percentual em medidas plausíveis (100% ≈
+// RSRP -44 dBm, 0% ≈ -140 dBm).
+func fakeExtendedSignal(tech string, quality int) *SignalInfo {
+	measure := func(base, step, offset float64) *float64 {
+		v := math.Round((base+float64(quality)*step+offset)*10) / 10
+		return &v
+	}
+	return &SignalInfo{
+		Tech: tech,
+		RSSI: measure(-140, 0.96, 20),
+		RSRP: measure(-140, 0.96, 0),
+		RSRQ: measure(-20, 0.14, 0),
+		SINR: measure(-5, 0.3, 0),
+	}
+}
+
+func (b *FakeBackend) GetSIM(ctx context.Context, simID string) (*SIMInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m, ok := b.modems[simID]
+	if !ok || m.script.SIM == nil {
+		return nil, fmt.Errorf("SIM %s não encontrado", simID)
+	}
+	sim := *m.script.SIM
+	sim.ID = simID
+	return &sim, nil
+}
+
+func (b *FakeBackend) GetBearer(ctx context.Context, bearerID string) (*BearerInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bearer, ok := b.bearers[bearerID]
+	if !ok {
+		return nil, fmt.Errorf("bearer %s não encontrado", bearerID)
+	}
+	copied := *bearer
+	return &copied, nil
+}
+
+func (b *FakeBackend) ListSMS(ctx context.Context, modemID string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m, err := b.modem(modemID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(m.messages))
+	for id := range m.messages {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (b *FakeBackend) GetSMS(ctx context.Context, modemID, smsID string) (*SMS, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m, err := b.modem(modemID)
+	if err != nil {
+		return nil, err
+	}
+
+	sms, ok := m.messages[smsID]
+	if !ok {
+		return nil, fmt.Errorf("SMS %s não encontrado no modem %s", smsID, modemID)
+	}
+	copied := *sms
+	copied.Received = time.Now()
+	return &copied, nil
+}
+
+func (b *FakeBackend) CreateSMS(ctx context.Context, modemID, number, text string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m, err := b.modem(modemID)
+	if err != nil {
+		return "", err
+	}
+	return b.storeSMS(m, number, text, "stored"), nil
+}
+
+func (b *FakeBackend) SendSMS(ctx context.Context, modemID, smsID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m, err := b.modem(modemID)
+	if err != nil {
+		return err
+	}
+
+	sms, ok := m.messages[smsID]
+	if !ok {
+		return fmt.Errorf("SMS %s não encontrado no modem %s", smsID, modemID)
+	}
+	sms.State = "sent"
+	return nil
+}
+
+func (b *FakeBackend) DeleteSMS(ctx context.Context, modemID, smsID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m, err := b.modem(modemID)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := m.messages[smsID]; !ok {
+		return fmt.Errorf("SMS %s não encontrado no modem %s", smsID, modemID)
+	}
+	delete(m.messages, smsID)
+	return nil
+}
+
+func (b *FakeBackend) SetPowerState(ctx context.Context, modemID string, state PowerState) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m, err := b.modem(modemID)
+	if err != nil {
+		return err
+	}
+
+	m.powerState = string(state)
+	if state == PowerStateLow {
+		b.dropBearer(m)
+		m.state = "disabled"
+	} else if m.state == "disabled" {
+		m.state = "registered"
+	}
+	return nil
+}
+
+func (b *FakeBackend) Connect(ctx context.Context, modemID string, params ConnectParams) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m, err := b.modem(modemID)
+	if err != nil {
+		return "", err
+	}
+
+	if m.powerState != string(PowerStateOn) {
+		return "", fmt.Errorf("modem %s em baixo consumo", modemID)
+	}
+	if m.failuresLeft > 0 {
+		m.failuresLeft--
+		return "", fmt.Errorf("falha simulada ao conectar modem %s", modemID)
+	}
+	if m.script.APN != "" && params.APN != m.script.APN {
+		return "", fmt.Errorf("APN %q recusado pela operadora do modem %s", params.APN, modemID)
+	}
+
+	b.dropBearer(m)
+	b.attachBearer(m)
+	m.state = "connected"
+	return m.bearerID, nil
+}
+
+func (b *FakeBackend) Disconnect(ctx context.Context, modemID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m, err := b.modem(modemID)
+	if err != nil {
+		return err
+	}
+
+	b.dropBearer(m)
+	if m.state == "connected" {
+		m.state = "registered"
+	}
+	return nil
+}
+
+// PublicIP devolve o IP público roteirizado da conexão atual do modem.
+func (b *FakeBackend) PublicIP(modemID string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m, err := b.modem(modemID)
+	if err != nil || m.bearerID == "" || len(m.script.PublicIPs) == 0 {
+		return "N/A"
+	}
+	return m.script.PublicIPs[(m.connects-1)%len(m.script.PublicIPs)]
+}
+
+func (b *FakeBackend) dropBearer(m *fakeModem) {
+	if m.bearerID != "" {
+		delete(b.bearers, m.bearerID)
+		m.bearerID = ""
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func testFakeScript() *FakeScript {
+	strong, weak, mid := 80, 20, 55
+	return &FakeScript{
+		Modems: []FakeModemScript{
+			{
+				ID:              "0",
+				Interface:       "wwan0",
+				Addresses:       []string{"10.64.0.2", "10.64.0.6"},
+				Gateway:         "10.64.0.1",
+				Prefix:          30,
+				PublicIPs:       []string{"177.25.10.1", "177.25.10.2"},
+				ConnectFailures: 1,
+				IMEI:            "866000000000010",
+				SIM:             &SIMInfo{ICCID: "8955101000000000001", OperatorCode: "72406", OperatorName: "VIVO"},
+				APN:             "zap.vivo.com.br",
+				Timeline: []FakeFrame{
+					{At: 0, State: "connected", Signal: &strong},
+					{At: jsonDuration(10 * time.Minute), State: "registered", Signal: &weak},
+					{At: jsonDuration(12 * time.Minute), State: "connected", Signal: &mid, AccessTech: "umts"},
+				},
+				Inbox: []FakeInboxSMS{
+					{At: jsonDuration(time.Minute), Number: "+5511999990000", Text: "STATUS"},
+				},
+			},
+			{
+				ID:          "1",
+				Interface:   "wwan1",
+				IMEI:        "866000000000028",
+				PluggedAt:   jsonDuration(5 * time.Minute),
+				UnpluggedAt: jsonDuration(20 * time.Minute),
+				Timeline: []FakeFrame{
+					{At: 0, State: "registered", Signal: &mid},
+				},
+			},
+		},
+	}
+}
+
+// at faz o backend se comportar como se tivesse começado elapsed atrás.
+func (b *FakeBackend) at(elapsed time.Duration) {
+	b.mu.Lock()
+	b.start = time.Now().Add(-elapsed)
+	b.mu.Unlock()
+}
+
+func TestFakeBackendTimeline(t *testing.T) {
+	ctx := context.Background()
+	b := NewFakeBackend(testFakeScript())
+
+	steps := []struct {
+		elapsed   time.Duration
+		modems    []string
+		state     string
+		signal    int
+		tech      string
+		connected bool
+		address   string
+		inbox     int
+	}{
+		{0, []string{"0"}, "connected", 80, "lte", true, "10.64.0.2", 0},
+		{2 * time.Minute, []string{"0"}, "connected", 80, "lte", true, "10.64.0.2", 1},
+		{6 * time.Minute, []string{"0", "1"}, "connected", 80, "lte", true, "10.64.0.2", 1},
+		{11 * time.Minute, []string{"0", "1"}, "registered", 20, "lte", false, "10.64.0.2", 1},
+		{13 * time.Minute, []string{"0", "1"}, "connected", 55, "umts", true, "10.64.0.6", 1},
+		{25 * time.Minute, []string{"0"}, "connected", 55, "umts", true, "10.64.0.6", 1},
+	}
+
+	for _, step := range steps {
+		b.at(step.elapsed)
+
+		modems, err := b.ListModems(ctx)
+		if err != nil {
+			t.Fatalf("%v: ListModems: %v", step.elapsed, err)
+		}
+		if len(modems) != len(step.modems) {
+			t.Fatalf("%v: modems = %v, esperado %v", step.elapsed, modems, step.modems)
+		}
+		for i := range modems {
+			if modems[i] != step.modems[i] {
+				t.Fatalf("%v: modems = %v, esperado %v", step.elapsed, modems, step.modems)
+			}
+		}
+
+		info, err := b.GetModem(ctx, "0")
+		if err != nil {
+			t.Fatalf("%v: GetModem: %v", step.elapsed, err)
+		}
+		if info.State != step.state || info.SignalQuality != step.signal || info.AccessTech != step.tech {
+			t.Errorf("%v: estado %s sinal %d tech %s, esperado %s %d %s",
+				step.elapsed, info.State, info.SignalQuality, info.AccessTech, step.state, step.signal, step.tech)
+		}
+
+		bearer, err := b.GetBearer(ctx, info.CurrentBearer())
+		if err != nil {
+			t.Fatalf("%v: GetBearer: %v", step.elapsed, err)
+		}
+		if bearer.Connected != step.connected || bearer.Address != step.address {
+			t.Errorf("%v: bearer conectado=%v %s, esperado %v %s",
+				step.elapsed, bearer.Connected, bearer.Address, step.connected, step.address)
+		}
+
+		inbox, err := b.ListSMS(ctx, "0")
+		if err != nil {
+			t.Fatalf("%v: ListSMS: %v", step.elapsed, err)
+		}
+		if len(inbox) != step.inbox {
+			t.Errorf("%v: %d SMS, esperado %d", step.elapsed, len(inbox), step.inbox)
+		}
+	}
+
+	if _, err := b.GetModem(ctx, "1"); err == nil {
+		t.Error("modem 1 desconectado em 25m ainda é encontrado")
+	}
+}
+
+func TestFakeBackendConnect(t *testing.T) {
+	ctx := context.Background()
+	b := NewFakeBackend(testFakeScript())
+	params := ConnectParams{APN: "zap.vivo.com.br", IPType: "ipv4"}
+
+	if _, err := b.Connect(ctx, "0", params); err == nil {
+		t.Fatal("primeiro connect deveria falhar (connect_failures: 1)")
+	}
+	if _, err := b.Connect(ctx, "0", ConnectParams{APN: "claro.com.br"}); err == nil {
+		t.Fatal("connect com APN de outra operadora deveria falhar")
+	}
+
+	if ip := b.PublicIP("0"); ip != "177.25.10.1" {
+		t.Errorf("IP público antes da renovação = %s", ip)
+	}
+	bearerID, err := b.Connect(ctx, "0", params)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	bearer, err := b.GetBearer(ctx, bearerID)
+	if err != nil {
+		t.Fatalf("GetBearer: %v", err)
+	}
+	if bearer.Address != "10.64.0.6" {
+		t.Errorf("endereço após renovação = %s, esperado 10.64.0.6", bearer.Address)
+	}
+	if ip := b.PublicIP("0"); ip != "177.25.10.2" {
+		t.Errorf("IP público após renovação = %s, esperado 177.25.10.2", ip)
+	}
+
+	if err := b.SetPowerState(ctx, "0", PowerStateLow); err != nil {
+		t.Fatalf("SetPowerState: %v", err)
+	}
+	if _, err := b.Connect(ctx, "0", params); err == nil {
+		t.Error("connect em baixo consumo deveria falhar")
+	}
+	if info, _ := b.GetModem(ctx, "0"); info.State != "disabled" || len(info.Bearers) != 0 {
+		t.Errorf("baixo consumo: estado %s, bearers %v", info.State, info.Bearers)
+	}
+}
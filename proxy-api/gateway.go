@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// GATEWAY ROTATIVO
+// ============================================================================
+
+const (
+	StrategyRoundRobin           = "round-robin"
+	StrategyRandom               = "random"
+	StrategyLeastConnections     = "least-connections"
+	StrategyLeastRecentlyRenewed = "least-recently-renewed"
+)
+
+var gatewayStrategies = []string{
+	StrategyRoundRobin,
+	StrategyRandom,
+	StrategyLeastConnections,
+	StrategyLeastRecentlyRenewed,
+}
+
+var errNoModemAvailable = errors.New("nenhum modem disponível no pool")
+
+// Gateway expõe uma porta HTTP e uma SOCKS5 únicas e distribui cada nova
+// conexão entre os proxies de modem disponíveis.
+type Gateway struct {
+	mu        sync.Mutex
+	strategy  string
+	cursor    int
+	httpPort  int
+	socksPort int
+
+	dispatched map[int]int64
+}
+
+// GatewayMember descreve um modem do pool do gateway.
+type GatewayMember struct {
+	HTTPPort          int        `json:"http_port"`
+	SOCKSPort         int        `json:"socks_port"`
+	ModemID           string     `json:"modem_id"`
+	Available         bool       `json:"available"`
+	Reason            string     `json:"reason,omitempty"`
+	Health            string     `json:"health"`
+	ActiveConnections int        `json:"active_connections"`
+	Dispatched        int64      `json:"dispatched"`
+	RenewedAt         *time.Time `json:"renewed_at,omitempty"`
+}
+
+type GatewayStatus struct {
+	HTTPPort   int             `json:"http_port"`
+	SOCKSPort  int             `json:"socks_port"`
+	Strategy   string          `json:"strategy"`
+	Strategies []string        `json:"strategies"`
+	Pool       []GatewayMember `json:"pool"`
+}
+
+var gateway *Gateway
+
+func NewGateway(strategy string, httpPort, socksPort int) (*Gateway, error) {
+	if !validStrategy(strategy) {
+		return nil, fmt.Errorf("estratégia de gateway desconhecida: %s", strategy)
+	}
+	return &Gateway{
+		strategy:   strategy,
+		httpPort:   httpPort,
+		socksPort:  socksPort,
+		dispatched: make(map[int]int64),
+	}, nil
+}
+
+func validStrategy(strategy string) bool {
+	for _, s := range gatewayStrategies {
+		if s == strategy {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Gateway) Start(bindHost string) error {
+	httpListener, err := net.Listen("tcp", net.JoinHostPort(bindHost, strconv.Itoa(g.httpPort)))
+	if err != nil {
+		return fmt.Errorf("erro ao escutar HTTP do gateway: %v", err)
+	}
+
+	socksListener, err := net.Listen("tcp", net.JoinHostPort(bindHost, strconv.Itoa(g.socksPort)))
+	if err != nil {
+		httpListener.Close()
+		return fmt.Errorf("erro ao escutar SOCKS5 do gateway: %v", err)
+	}
+
+	server := &http.Server{
+		Handler:           proxyHandler(g.pick),
+		ReadHeaderTimeout: 30 * time.Second,
+		ConnContext:       withClientConn,
+	}
+	go server.Serve(httpListener)
+
+	go func() {
+		for {
+			conn, err := socksListener.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+			go handleSOCKS(conn, g.pick)
+		}
+	}()
+
+	log.Printf("🔀 Gateway rotativo | HTTP:%d SOCKS5:%d | estratégia %s", g.httpPort, g.socksPort, g.Strategy())
+	return nil
+}
+
+func (g *Gateway) Strategy() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.strategy
+}
+
+func (g *Gateway) SetStrategy(strategy string) error {
+	if !validStrategy(strategy) {
+		return fmt.Errorf("estratégia de gateway desconhecida: %s", strategy)
+	}
+
+	g.mu.Lock()
+	g.strategy = strategy
+	g.mu.Unlock()
+
+	log.Printf("🔀 Estratégia do gateway alterada para %s", strategy)
+	return nil
+}
+
+// unavailableReason explica por que um modem está fora do pool ("" se está
+// disponível).
+func unavailableReason(inst *proxyInstance) string {
+	link := inst.currentLink()
+	switch {
+	case jobManager.Renewing(link.HTTPPort):
+		return "renovando"
+	case !isProxyRunning(link.HTTPPort):
+		return "proxy parado"
+	case link.IP == "":
+		return "sem IP"
+	case healthChecker.State(inst) == HealthDead:
+		return "sem resposta"
+	}
+	return ""
+}
+
+// pool devolve os modems disponíveis e permitidos. Os degradados só entram
+// quando não há nenhum saudável.
+func (g *Gateway) pool(allowed func(*proxyInstance) bool) []*proxyInstance {
+	healthy := make([]*proxyInstance, 0)
+	degraded := make([]*proxyInstance, 0)
+	for _, inst := range proxyServer.All() {
+		if unavailableReason(inst) != "" || !allowed(inst) {
+			continue
+		}
+		if healthChecker.State(inst) == HealthDegraded {
+			degraded = append(degraded, inst)
+		} else {
+			healthy = append(healthy, inst)
+		}
+	}
+	if len(healthy) == 0 {
+		return degraded
+	}
+	return healthy
+}
+
+// pick respeita a sessão fixa do usuário, se houver; senão usa a estratégia.
+func (g *Gateway) pick(creds proxyCredentials) (*proxyInstance, error) {
+	if _, session := parseProxyUsername(creds.Username); session != "" {
+		return sessionManager.Resolve(creds.owner(), session, creds.allowed, func() (*proxyInstance, error) {
+			return g.next(creds.allowed)
+		})
+	}
+	return g.next(creds.allowed)
+}
+
+// next escolhe, entre os modems permitidos, o da próxima conexão segundo a
+// estratégia atual.
+func (g *Gateway) next(allowed func(*proxyInstance) bool) (*proxyInstance, error) {
+	pool := g.pool(allowed)
+	if len(pool) == 0 {
+		return nil, errNoModemAvailable
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var chosen *proxyInstance
+	switch g.strategy {
+	case StrategyRandom:
+		chosen = pool[rand.Intn(len(pool))]
+	case StrategyLeastConnections:
+		chosen = pool[0]
+		for _, inst := range pool[1:] {
+			if inst.activeConnections() < chosen.activeConnections() {
+				chosen = inst
+			}
+		}
+	case StrategyLeastRecentlyRenewed:
+		chosen = pool[0]
+		for _, inst := range pool[1:] {
+			if inst.lastRenewed().Before(chosen.lastRenewed()) {
+				chosen = inst
+			}
+		}
+	default:
+		chosen = pool[g.cursor%len(pool)]
+		g.cursor++
+	}
+
+	g.dispatched[chosen.currentLink().HTTPPort]++
+	return chosen, nil
+}
+
+func (g *Gateway) Status() GatewayStatus {
+	status := GatewayStatus{
+		HTTPPort:   g.httpPort,
+		SOCKSPort:  g.socksPort,
+		Strategy:   g.Strategy(),
+		Strategies: gatewayStrategies,
+		Pool:       make([]GatewayMember, 0),
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, inst := range proxyServer.All() {
+		link := inst.currentLink()
+		reason := unavailableReason(inst)
+
+		member := GatewayMember{
+			HTTPPort:          link.HTTPPort,
+			SOCKSPort:         link.SOCKSPort,
+			ModemID:           link.ModemID,
+			Available:         reason == "",
+			Reason:            reason,
+			Health:            healthChecker.State(inst),
+			ActiveConnections: inst.activeConnections(),
+			Dispatched:        g.dispatched[link.HTTPPort],
+		}
+		if renewed := inst.lastRenewed(); !renewed.IsZero() {
+			member.RenewedAt = &renewed
+		}
+		status.Pool = append(status.Pool, member)
+	}
+	return status
+}
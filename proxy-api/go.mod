@@ -2,4 +2,11 @@ module proxy-api
 
 go 1.25.0
 
-require github.com/gorilla/mux v1.8.1
+require (
+	github.com/godbus/dbus/v5 v5.2.2
+	github.com/gorilla/mux v1.8.1
+	github.com/gorilla/websocket v1.5.3
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.27.0 // indirect
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// SAÚDE DOS PROXIES - ESTRUTURAS
+// ============================================================================
+
+const (
+	HealthUnknown  = "unknown"
+	HealthHealthy  = "healthy"
+	HealthDegraded = "degraded"
+	HealthDead     = "dead"
+
+	// probeUsername autentica as sondagens nos proxies com uma senha
+	// aleatória gerada a cada start, mesmo com usuários cadastrados.
+	probeUsername = "__probe"
+)
+
+var healthRank = map[string]int{
+	HealthUnknown:  0,
+	HealthHealthy:  1,
+	HealthDegraded: 2,
+	HealthDead:     3,
+}
+
+// Alvos usados quando PROBE_TARGETS não é definido.
+var defaultProbeTargets = []string{
+	"http://www.gstatic.com/generate_204",
+	"https://www.cloudflare.com/cdn-cgi/trace",
+}
+
+type probeResult struct {
+	At        time.Time
+	OK        bool
+	Latency   time.Duration
+	ErrorType string
+	Error     string
+}
+
+// ProxyHealth resume as últimas PROBE_WINDOW sondagens de uma porta.
+type ProxyHealth struct {
+	Port                int            `json:"port"`
+	Protocol            string         `json:"protocol"`
+	State               string         `json:"state"`
+	Samples             int            `json:"samples"`
+	SuccessRate         float64        `json:"success_rate"`
+	LatencyP50Ms        int64          `json:"latency_p50_ms"`
+	LatencyP90Ms        int64          `json:"latency_p90_ms"`
+	LatencyP99Ms        int64          `json:"latency_p99_ms"`
+	ConsecutiveFailures int            `json:"consecutive_failures"`
+	Errors              map[string]int `json:"errors,omitempty"`
+	LastError           string         `json:"last_error,omitempty"`
+	LastCheckAt         *time.Time     `json:"last_check_at,omitempty"`
+}
+
+type portHealth struct {
+	protocol            string
+	results             []probeResult
+	consecutiveFailures int
+}
+
+// HealthChecker sonda periodicamente as portas HTTP e SOCKS5 de cada modem
+// e classifica cada uma como healthy, degraded ou dead.
+type HealthChecker struct {
+	mu       sync.RWMutex
+	ports    map[int]*portHealth
+	targets  []string
+	cursor   int
+	interval time.Duration
+	secret   string
+}
+
+var healthChecker = &HealthChecker{
+	ports:    make(map[int]*portHealth),
+	targets:  defaultProbeTargets,
+	interval: PROBE_INTERVAL,
+	secret:   newJobID() + newJobID(),
+}
+
+func (hc *HealthChecker) SetTargets(targets []string) {
+	hc.mu.Lock()
+	hc.targets = targets
+	hc.mu.Unlock()
+}
+
+func (hc *HealthChecker) SetInterval(interval time.Duration) {
+	hc.mu.Lock()
+	hc.interval = interval
+	hc.mu.Unlock()
+}
+
+// isProbe reconhece as credenciais das sondagens.
+func (hc *HealthChecker) isProbe(creds proxyCredentials) bool {
+	return creds.Username == probeUsername &&
+		subtle.ConstantTimeCompare([]byte(creds.Password), []byte(hc.secret)) == 1
+}
+
+// ============================================================================
+// SAÚDE DOS PROXIES - SONDAGEM
+// ============================================================================
+
+func (hc *HealthChecker) Run() {
+	for {
+		hc.probeAll()
+
+		hc.mu.RLock()
+		interval := hc.interval
+		hc.mu.RUnlock()
+		time.Sleep(interval)
+	}
+}
+
+// probeAll sonda todas as portas em paralelo, um alvo por rodada (em
+// rodízio). Portas renovando ficam de fora para não contarem como falha.
+func (hc *HealthChecker) probeAll() {
+	hc.mu.Lock()
+	if len(hc.targets) == 0 {
+		hc.mu.Unlock()
+		return
+	}
+	target := hc.targets[hc.cursor%len(hc.targets)]
+	hc.cursor++
+	hc.mu.Unlock()
+
+	active := make(map[int]bool)
+	var wg sync.WaitGroup
+	for _, inst := range proxyServer.All() {
+		link := inst.currentLink()
+		active[link.HTTPPort] = true
+		active[link.SOCKSPort] = true
+		if jobManager.Renewing(link.HTTPPort) {
+			continue
+		}
+
+		for port, protocol := range map[int]string{link.HTTPPort: "HTTP", link.SOCKSPort: "SOCKS5"} {
+			wg.Add(1)
+			go func(port int, protocol string) {
+				defer wg.Done()
+				hc.record(port, protocol, hc.probe(port, protocol, target))
+			}(port, protocol)
+		}
+	}
+	wg.Wait()
+
+	// Esquece portas que deixaram de existir
+	hc.mu.Lock()
+	for port := range hc.ports {
+		if !active[port] {
+			delete(hc.ports, port)
+		}
+	}
+	hc.mu.Unlock()
+}
+
+// probe faz um GET no alvo através da porta, como um cliente faria.
+func (hc *HealthChecker) probe(port int, protocol, target string) probeResult {
+	address := proxyServer.localAddress(port)
+	transport := &http.Transport{DisableKeepAlives: true}
+
+	switch protocol {
+	case "HTTP":
+		transport.Proxy = http.ProxyURL(&url.URL{
+			Scheme: "http",
+			User:   url.UserPassword(probeUsername, hc.secret),
+			Host:   address,
+		})
+	case "SOCKS5":
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialSOCKS5(ctx, address, probeUsername, hc.secret, addr)
+		}
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   PROBE_TIMEOUT,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	start := time.Now()
+	result := probeResult{At: start}
+
+	resp, err := client.Get(target)
+	if err == nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusProxyAuthRequired:
+			err = errors.New(resp.Status)
+		case resp.StatusCode >= 500:
+			err = errors.New(resp.Status)
+		}
+	}
+	result.Latency = time.Since(start)
+
+	if err != nil {
+		result.ErrorType = classifyProbeError(err)
+		result.Error = err.Error()
+		return result
+	}
+	result.OK = true
+	return result
+}
+
+// classifyProbeError agrupa os erros: timeout, refused, dns, auth, upstream
+// (o proxy não alcançou o destino) ou connect.
+func classifyProbeError(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	message := err.Error()
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case strings.Contains(message, "connection refused"):
+		return "refused"
+	case strings.Contains(message, "407"), strings.Contains(message, "autenticação SOCKS5"):
+		return "auth"
+	case strings.Contains(message, "502"), strings.Contains(message, "503"),
+		strings.Contains(message, "504"), strings.Contains(message, "SOCKS5 recusou"):
+		return "upstream"
+	}
+	return "connect"
+}
+
+// dialSOCKS5 abre uma conexão até target pelo proxy SOCKS5 em proxyAddr,
+// autenticando com usuário e senha (RFC 1929).
+func dialSOCKS5(ctx context.Context, proxyAddr, username, password, target string) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	fail := func(err error) (net.Conn, error) {
+		conn.Close()
+		return nil, err
+	}
+
+	if _, err := conn.Write([]byte{5, 2, 0, 2}); err != nil {
+		return fail(err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fail(err)
+	}
+
+	switch reply[1] {
+	case 0:
+	case 2:
+		auth := []byte{1, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return fail(err)
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fail(err)
+		}
+		if reply[1] != 0 {
+			return fail(errors.New("autenticação SOCKS5 recusada"))
+		}
+	default:
+		return fail(errors.New("nenhum método de autenticação SOCKS5 aceito"))
+	}
+
+	host, portText, err := net.SplitHostPort(target)
+	if err != nil {
+		return fail(err)
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return fail(err)
+	}
+
+	request := []byte{5, 1, 0, 3, byte(len(host))}
+	request = append(request, host...)
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return fail(err)
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fail(err)
+	}
+	if header[1] != 0 {
+		return fail(fmt.Errorf("SOCKS5 recusou a conexão (código %d)", header[1]))
+	}
+
+	// Descarta o endereço de bind da resposta
+	skip := 0
+	switch header[3] {
+	case 1:
+		skip = 4 + 2
+	case 4:
+		skip = 16 + 2
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return fail(err)
+		}
+		skip = int(length[0]) + 2
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip)); err != nil {
+		return fail(err)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// ============================================================================
+// SAÚDE DOS PROXIES - CLASSIFICAÇÃO
+// ============================================================================
+
+func (hc *HealthChecker) record(port int, protocol string, result probeResult) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	ph, ok := hc.ports[port]
+	if !ok {
+		ph = &portHealth{protocol: protocol}
+		hc.ports[port] = ph
+	}
+
+	before := ph.summary(port).State
+
+	ph.results = append(ph.results, result)
+	if len(ph.results) > PROBE_WINDOW {
+		ph.results = ph.results[len(ph.results)-PROBE_WINDOW:]
+	}
+	if result.OK {
+		ph.consecutiveFailures = 0
+	} else {
+		ph.consecutiveFailures++
+	}
+
+	after := ph.summary(port).State
+	if after != before && before != HealthUnknown {
+		log.Printf("🩺 Porta %d (%s): %s → %s", port, protocol, before, after)
+	}
+
+	// Para o stream de eventos só importa se a porta responde ou não
+	wasDown := before == HealthDead || before == HealthUnknown
+	if after == HealthDead && before != HealthDead {
+		eventBus.Publish(EventProxyDown, map[string]interface{}{
+			"port":       port,
+			"protocol":   protocol,
+			"state":      after,
+			"last_error": result.Error,
+		})
+	} else if after != HealthDead && after != HealthUnknown && wasDown {
+		eventBus.Publish(EventProxyUp, map[string]interface{}{
+			"port":     port,
+			"protocol": protocol,
+			"state":    after,
+		})
+	}
+}
+
+func (ph *portHealth) summary(port int) ProxyHealth {
+	health := ProxyHealth{
+		Port:                port,
+		Protocol:            ph.protocol,
+		State:               HealthUnknown,
+		Samples:             len(ph.results),
+		ConsecutiveFailures: ph.consecutiveFailures,
+	}
+	if len(ph.results) == 0 {
+		return health
+	}
+
+	latencies := make([]time.Duration, 0, len(ph.results))
+	for _, result := range ph.results {
+		if result.OK {
+			latencies = append(latencies, result.Latency)
+			continue
+		}
+		if health.Errors == nil {
+			health.Errors = make(map[string]int)
+		}
+		health.Errors[result.ErrorType]++
+		health.LastError = result.Error
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	health.SuccessRate = float64(len(latencies)) / float64(len(ph.results))
+	health.LatencyP50Ms = percentile(latencies, 50).Milliseconds()
+	health.LatencyP90Ms = percentile(latencies, 90).Milliseconds()
+	health.LatencyP99Ms = percentile(latencies, 99).Milliseconds()
+
+	last := ph.results[len(ph.results)-1].At
+	health.LastCheckAt = &last
+
+	switch {
+	case ph.consecutiveFailures >= PROBE_DEAD_AFTER:
+		health.State = HealthDead
+	case health.SuccessRate < PROBE_MIN_SUCCESS_RATE || percentile(latencies, 90) > PROBE_SLOW:
+		health.State = HealthDegraded
+	default:
+		health.State = HealthHealthy
+	}
+	return health
+}
+
+// percentile usa o método nearest-rank sobre valores já ordenados.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Health devolve o resumo da porta (nil se ainda não foi sondada).
+func (hc *HealthChecker) Health(port int) *ProxyHealth {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	ph, ok := hc.ports[port]
+	if !ok {
+		return nil
+	}
+	health := ph.summary(port)
+	return &health
+}
+
+// State é o pior estado entre a porta HTTP e a SOCKS5 do modem.
+func (hc *HealthChecker) State(inst *proxyInstance) string {
+	link := inst.currentLink()
+	state := HealthUnknown
+	for _, port := range []int{link.HTTPPort, link.SOCKSPort} {
+		if health := hc.Health(port); health != nil && healthRank[health.State] > healthRank[state] {
+			state = health.State
+		}
+	}
+	return state
+}
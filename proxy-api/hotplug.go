@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// HOTPLUG DE MODEMS
+// ============================================================================
+
+// Hotplug reage aos modems que aparecem e somem (detectados pelo
+// watchModems) sem tocar nos demais: um modem novo é conectado, roteado e
+// ganha proxies nas portas do mapa de portas; um modem removido tem proxy,
+// rotas e link desfeitos. As operações ocupam a vaga da porta, como as
+// renovações, para não concorrer com elas.
+type Hotplug struct {
+	orchestrator *RenewOrchestrator
+	network      NetworkConfigurator
+	proxies      ProxyRestarter
+	links        *LinkRegistry
+
+	mu        sync.Mutex
+	attaching map[string]bool
+}
+
+var hotplug *Hotplug
+
+func NewHotplug(orchestrator *RenewOrchestrator, network NetworkConfigurator, proxies ProxyRestarter, links *LinkRegistry) *Hotplug {
+	return &Hotplug{
+		orchestrator: orchestrator,
+		network:      network,
+		proxies:      proxies,
+		links:        links,
+		attaching:    make(map[string]bool),
+	}
+}
+
+// Added conecta em segundo plano um modem que apareceu.
+func (h *Hotplug) Added(modemID string) {
+	if !currentConfig().Modems.Hotplug {
+		return
+	}
+
+	h.mu.Lock()
+	if h.attaching[modemID] {
+		h.mu.Unlock()
+		return
+	}
+	h.attaching[modemID] = true
+	h.mu.Unlock()
+
+	go func() {
+		defer func() {
+			h.mu.Lock()
+			delete(h.attaching, modemID)
+			h.mu.Unlock()
+		}()
+		h.attach(modemID)
+	}()
+}
+
+// Removed desfaz em segundo plano os proxies de um modem que sumiu.
+func (h *Hotplug) Removed(modemID string) {
+	if !currentConfig().Modems.Hotplug {
+		return
+	}
+
+	link, ok := h.links.ByModem(modemID)
+	if !ok {
+		return
+	}
+	go h.detach(link)
+}
+
+// acquire ocupa a vaga da porta, esperando a renovação ou religação em
+// curso terminar.
+func (h *Hotplug) acquire(ctx context.Context, port int) (func(), error) {
+	slot := jobManager.portSlot(port)
+	select {
+	case slot <- struct{}{}:
+		return func() { <-slot }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("porta %d ocupada: %v", port, ctx.Err())
+	}
+}
+
+func (h *Hotplug) attach(modemID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	h.links.Refresh(ctx)
+	if _, ok := h.links.ByModem(modemID); ok {
+		return
+	}
+
+	identity, kind, err := ModemIdentity(ctx, modemBackend, modemID)
+	if err != nil {
+		h.attachFailed(modemID, 0, fmt.Errorf("%v: sem identidade as portas não podem ser fixadas, use POST /restart", err))
+		return
+	}
+
+	// APNs e usuários ainda fixados por índice podem ser deste modem
+	apnStore.MigrateModemRefs(ctx, modemBackend)
+	userStore.MigrateModemRefs(ctx, modemBackend)
+
+	link, known := h.links.ByIdentity(identity)
+	if known {
+		// Mesmo modem físico com outro índice (reset ou reconexão USB). Na
+		// renovação quem relocaliza o modem é o orquestrador.
+		if jobManager.Renewing(link.HTTPPort) {
+			return
+		}
+		log.Printf("🔌 Modem %s (%s %s) voltou como modem %s", link.ModemID, kind, identity, modemID)
+		link.ModemID = modemID
+	} else {
+		assignment, err := portMap.Assign(identity, kind, modemID, 0)
+		if err != nil {
+			h.attachFailed(modemID, 0, err)
+			return
+		}
+		if other, ok := h.links.ByPort(assignment.HTTPPort); ok {
+			h.attachFailed(modemID, assignment.HTTPPort, fmt.Errorf("porta %d em uso pelo modem %s", assignment.HTTPPort, other.ModemID))
+			return
+		}
+		link = ModemLink{
+			Index:     h.links.NextIndex(),
+			ModemID:   modemID,
+			Identity:  identity,
+			HTTPPort:  assignment.HTTPPort,
+			SOCKSPort: assignment.SOCKSPort,
+		}
+	}
+
+	release, err := h.acquire(ctx, link.HTTPPort)
+	if err != nil {
+		h.attachFailed(modemID, link.HTTPPort, err)
+		return
+	}
+	defer release()
+
+	// O link entra no registro antes da conexão: o Reconnect parte dele e,
+	// se falhar, o supervisor segue tentando com backoff.
+	if err := h.links.Update(link); err != nil {
+		h.attachFailed(modemID, link.HTTPPort, err)
+		return
+	}
+
+	log.Printf("🔌 Modem %s (%s %s) conectado à máquina: preparando portas %d/%d", modemID, kind, identity, link.HTTPPort, link.SOCKSPort)
+	result, err := h.orchestrator.Reconnect(ctx, link.HTTPPort, supervisorReporter{modemID: modemID})
+	invalidateCache()
+	if err != nil {
+		h.attachFailed(modemID, link.HTTPPort, err)
+		return
+	}
+
+	log.Printf("✅ Modem %s pronto: HTTP:%d SOCKS:%d | IP %s | IP público %s", modemID, link.HTTPPort, link.SOCKSPort, result.NewIP, result.NewPublicIP)
+	eventBus.Publish(EventModemAttached, map[string]interface{}{
+		"modem_id":   modemID,
+		"identity":   identity,
+		"http_port":  link.HTTPPort,
+		"socks_port": link.SOCKSPort,
+		"interface":  result.NewInterface,
+		"ip":         result.NewIP,
+		"public_ip":  result.NewPublicIP,
+	})
+}
+
+func (h *Hotplug) attachFailed(modemID string, port int, err error) {
+	log.Printf("❌ Hotplug do modem %s: %v", modemID, err)
+	eventBus.Publish(EventModemAttachFailed, map[string]interface{}{
+		"modem_id": modemID,
+		"port":     port,
+		"error":    err.Error(),
+	})
+}
+
+// detach espera a vaga da porta e confere de novo: se uma renovação ou o
+// hotplug já relocalizou o modem em outro índice, não há o que desfazer.
+func (h *Hotplug) detach(removed ModemLink) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	release, err := h.acquire(ctx, removed.HTTPPort)
+	if err != nil {
+		log.Printf("⚠️  Hotplug do modem %s: %v", removed.ModemID, err)
+		return
+	}
+	defer release()
+
+	link, ok := h.links.ByPort(removed.HTTPPort)
+	if !ok || link.ModemID != removed.ModemID {
+		return
+	}
+	if _, err := modemBackend.GetModem(ctx, link.ModemID); err == nil {
+		return
+	}
+
+	h.proxies.Stop(link.HTTPPort)
+	if err := h.network.RemoveRouting(ctx, link); err != nil {
+		log.Printf("⚠️  Erro ao remover rotas do modem %s: %v", link.ModemID, err)
+	}
+	if err := h.links.Remove(link.HTTPPort); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	supervisor.Forget(link.ModemID)
+	invalidateCache()
+
+	log.Printf("🔌 Modem %s removido: portas %d/%d desativadas (reservadas no mapa de portas)", link.ModemID, link.HTTPPort, link.SOCKSPort)
+	eventBus.Publish(EventModemDetached, map[string]interface{}{
+		"modem_id":   link.ModemID,
+		"identity":   link.Identity,
+		"http_port":  link.HTTPPort,
+		"socks_port": link.SOCKSPort,
+	})
+}
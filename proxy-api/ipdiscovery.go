@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// DESCOBERTA DO IP PÚBLICO
+// ============================================================================
+
+const (
+	ConsensusMajority = "majority"
+	ConsensusFirst    = "first"
+)
+
+// Provedores usados quando public_ip.providers (IP_PROVIDERS) não é definido.
+var defaultIPProviders = []string{
+	"text:https://api.ipify.org",
+	"text:https://checkip.amazonaws.com",
+	"stun:stun.l.google.com:19302",
+}
+
+// IPProvider descobre o IP público saindo pelo modem do link.
+type IPProvider interface {
+	Name() string
+	Timeout() time.Duration
+	Lookup(ctx context.Context, link ModemLink) (string, error)
+}
+
+// IPDiscovery consulta os provedores em paralelo. Com "majority" o IP
+// precisa ser a resposta de mais da metade dos provedores que responderam e
+// ter ao menos duas respostas iguais (ou mais da metade dos configurados,
+// para quem usa um provedor só); com "first" vale a primeira resposta
+// válida.
+type IPDiscovery struct {
+	providers []IPProvider
+	consensus string
+}
+
+var (
+	ipDiscovery      *IPDiscovery
+	ipDiscoveryMutex sync.RWMutex
+)
+
+// currentIPDiscovery devolve a descoberta em uso; a recarga da configuração
+// pode trocá-la com setIPDiscovery.
+func currentIPDiscovery() *IPDiscovery {
+	ipDiscoveryMutex.RLock()
+	defer ipDiscoveryMutex.RUnlock()
+	return ipDiscovery
+}
+
+func setIPDiscovery(discovery *IPDiscovery) {
+	ipDiscoveryMutex.Lock()
+	ipDiscovery = discovery
+	ipDiscoveryMutex.Unlock()
+}
+
+// newIPDiscovery monta os provedores da seção public_ip da configuração.
+// Sem provedores configurados, o backend fake usa o provedor "fake", que
+// devolve os IPs roteirizados sem sair para a internet.
+func newIPDiscovery(cfg PublicIPConfig, backend ModemBackend) (*IPDiscovery, error) {
+	specs := cfg.Providers
+	if len(specs) == 0 {
+		specs = defaultIPProviders
+		if backend.Name() == "fake" {
+			specs = []string{"fake"}
+		}
+	}
+
+	providers := make([]IPProvider, 0, len(specs))
+	for _, spec := range specs {
+		provider, err := parseIPProvider(strings.TrimSpace(spec), time.Duration(cfg.Timeout), backend)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+
+	return NewIPDiscovery(providers, cfg.Consensus)
+}
+
+func NewIPDiscovery(providers []IPProvider, consensus string) (*IPDiscovery, error) {
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("nenhum provedor de IP público configurado")
+	}
+	if consensus != ConsensusMajority && consensus != ConsensusFirst {
+		return nil, fmt.Errorf("consenso desconhecido: %s (use majority ou first)", consensus)
+	}
+	return &IPDiscovery{providers: providers, consensus: consensus}, nil
+}
+
+// parseIPProvider entende "tipo:destino[~timeout]":
+//
+//	text:https://api.ipify.org       corpo da resposta é o IP
+//	json:https://api.myip.com#ip     campo do JSON (padrão "ip")
+//	stun:stun.l.google.com:19302     Binding Request STUN (RFC 5389)
+//	fake                             IPs roteirizados do backend fake
+func parseIPProvider(spec string, timeout time.Duration, backend ModemBackend) (IPProvider, error) {
+	if i := strings.LastIndex(spec, "~"); i >= 0 {
+		parsed, err := time.ParseDuration(spec[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("timeout inválido no provedor %q", spec)
+		}
+		spec, timeout = spec[:i], parsed
+	}
+
+	kind, target, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "text":
+		return &httpIPProvider{url: target, timeout: timeout}, nil
+	case "json":
+		url, field, _ := strings.Cut(target, "#")
+		if field == "" {
+			field = "ip"
+		}
+		return &httpIPProvider{url: url, field: field, timeout: timeout}, nil
+	case "stun":
+		return &stunIPProvider{address: target, timeout: timeout}, nil
+	case "fake":
+		fake, ok := backend.(*FakeBackend)
+		if !ok {
+			return nil, fmt.Errorf("provedor fake exige MODEM_BACKEND=fake")
+		}
+		return &fakeIPProvider{backend: fake}, nil
+	}
+	return nil, fmt.Errorf("provedor de IP desconhecido: %q", spec)
+}
+
+type ipAnswer struct {
+	provider string
+	ip       string
+	err      error
+}
+
+// Discover devolve o IP público do link segundo o consenso configurado.
+func (d *IPDiscovery) Discover(ctx context.Context, link ModemLink) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	answers := make(chan ipAnswer, len(d.providers))
+	for _, provider := range d.providers {
+		go func(provider IPProvider) {
+			lookupCtx, cancel := context.WithTimeout(ctx, provider.Timeout())
+			defer cancel()
+
+			ip, err := provider.Lookup(lookupCtx, link)
+			if err == nil && net.ParseIP(ip) == nil {
+				err = fmt.Errorf("resposta não é um IP: %q", ip)
+			}
+			answers <- ipAnswer{provider: provider.Name(), ip: ip, err: err}
+		}(provider)
+	}
+
+	votes := make(map[string]int)
+	failures := make([]string, 0)
+	for range d.providers {
+		answer := <-answers
+		if answer.err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", answer.provider, answer.err))
+			continue
+		}
+		if d.consensus == ConsensusFirst {
+			return answer.ip, nil
+		}
+		votes[answer.ip]++
+	}
+
+	responded := 0
+	for _, count := range votes {
+		responded += count
+	}
+	if responded == 0 {
+		return "", fmt.Errorf("nenhum provedor respondeu (%s)", strings.Join(failures, "; "))
+	}
+
+	for ip, count := range votes {
+		if count*2 > responded {
+			if count < 2 && count*2 <= len(d.providers) {
+				return "", fmt.Errorf("só um provedor respondeu (%s), sem confirmação: %s", ip, strings.Join(failures, "; "))
+			}
+			if len(votes) > 1 {
+				log.Printf("⚠️  Porta %d: provedores de IP divergiram %v, usando %s", link.HTTPPort, votes, ip)
+			}
+			return ip, nil
+		}
+	}
+	return "", fmt.Errorf("provedores sem maioria: %v", votes)
+}
+
+// resolvePublicIP descobre o IP público da porta, ou "N/A". ctx limita a
+// consulta aos provedores (timeout do passo ou cancelamento do job).
+func resolvePublicIP(ctx context.Context, modemID string, port int) string {
+	link, ok := linkRegistry.ByPort(port)
+	if !ok {
+		link = ModemLink{ModemID: modemID, HTTPPort: port}
+	}
+
+	ip, err := currentIPDiscovery().Discover(ctx, link)
+	if err != nil {
+		log.Printf("⚠️  IP público da porta %d: %v", port, err)
+		return "N/A"
+	}
+	return ip
+}
+
+// ============================================================================
+// DESCOBERTA DO IP PÚBLICO - PROVEDORES
+// ============================================================================
+
+// httpIPProvider faz um GET saindo pelo proxy do modem. Sem field o corpo é
+// o IP; com field o corpo é um JSON e o IP está nesse campo.
+type httpIPProvider struct {
+	url     string
+	field   string
+	timeout time.Duration
+}
+
+func (p *httpIPProvider) Name() string {
+	return p.url
+}
+
+func (p *httpIPProvider) Timeout() time.Duration {
+	return p.timeout
+}
+
+func (p *httpIPProvider) Lookup(ctx context.Context, link ModemLink) (string, error) {
+	inst, ok := proxyServer.instance(link.HTTPPort)
+	if !ok {
+		return "", fmt.Errorf("proxy da porta %d parado", link.HTTPPort)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext:       inst.dialContext,
+			DisableKeepAlives: true,
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+
+	if p.field == "" {
+		return strings.TrimSpace(string(body)), nil
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return "", fmt.Errorf("JSON inválido: %v", err)
+	}
+	ip, _ := data[p.field].(string)
+	if ip == "" {
+		return "", fmt.Errorf("campo %q ausente", p.field)
+	}
+	return strings.TrimSpace(ip), nil
+}
+
+// stunIPProvider envia um Binding Request pelo IP do modem e lê o
+// XOR-MAPPED-ADDRESS (ou MAPPED-ADDRESS) da resposta.
+type stunIPProvider struct {
+	address string
+	timeout time.Duration
+}
+
+const (
+	stunMagicCookie      = 0x2112A442
+	stunBindingRequest   = 0x0001
+	stunBindingResponse  = 0x0101
+	stunMappedAddress    = 0x0001
+	stunXorMappedAddress = 0x0020
+)
+
+func (p *stunIPProvider) Name() string {
+	return "stun:" + p.address
+}
+
+func (p *stunIPProvider) Timeout() time.Duration {
+	return p.timeout
+}
+
+func (p *stunIPProvider) Lookup(ctx context.Context, link ModemLink) (string, error) {
+	inst, ok := proxyServer.instance(link.HTTPPort)
+	if !ok {
+		return "", fmt.Errorf("proxy da porta %d parado", link.HTTPPort)
+	}
+
+	conn, err := inst.dialContext(ctx, "udp4", p.address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	request := make([]byte, 20)
+	binary.BigEndian.PutUint16(request[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:], stunMagicCookie)
+	transactionID := request[8:20]
+	if _, err := rand.Read(transactionID); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write(request); err != nil {
+		return "", err
+	}
+
+	response := make([]byte, 1500)
+	n, err := conn.Read(response)
+	if err != nil {
+		return "", err
+	}
+	return parseSTUNResponse(response[:n], transactionID)
+}
+
+func parseSTUNResponse(msg, transactionID []byte) (string, error) {
+	if len(msg) < 20 || binary.BigEndian.Uint16(msg[0:]) != stunBindingResponse {
+		return "", errors.New("resposta STUN inválida")
+	}
+	if string(msg[8:20]) != string(transactionID) {
+		return "", errors.New("transação STUN não confere")
+	}
+
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if 20+length > len(msg) {
+		return "", errors.New("resposta STUN truncada")
+	}
+
+	mapped := ""
+	attrs := msg[20 : 20+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+attrLen > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+attrLen]
+
+		// Endereço IPv4: reservado, família (0x01), porta (2 bytes), IP (4 bytes)
+		if len(value) >= 8 && value[1] == 0x01 {
+			ip := make(net.IP, 4)
+			copy(ip, value[4:8])
+			switch attrType {
+			case stunXorMappedAddress:
+				binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(ip)^stunMagicCookie)
+				return ip.String(), nil
+			case stunMappedAddress:
+				mapped = ip.String()
+			}
+		}
+
+		// Atributos são alinhados em 4 bytes
+		padded := 4 + (attrLen+3)&^3
+		if padded > len(attrs) {
+			break
+		}
+		attrs = attrs[padded:]
+	}
+
+	if mapped != "" {
+		return mapped, nil
+	}
+	return "", errors.New("resposta STUN sem endereço mapeado")
+}
+
+// fakeIPProvider devolve os IPs roteirizados do backend fake.
+type fakeIPProvider struct {
+	backend *FakeBackend
+}
+
+func (p *fakeIPProvider) Name() string {
+	return "fake"
+}
+
+func (p *fakeIPProvider) Timeout() time.Duration {
+	return time.Second
+}
+
+func (p *fakeIPProvider) Lookup(ctx context.Context, link ModemLink) (string, error) {
+	if ip := p.backend.PublicIP(link.ModemID); ip != "N/A" {
+		return ip, nil
+	}
+	return "", fmt.Errorf("modem %s sem conexão", link.ModemID)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// stubIPProvider responde ip (ou err) depois de delay, respeitando o ctx.
+type stubIPProvider struct {
+	name  string
+	ip    string
+	err   error
+	delay time.Duration
+}
+
+func (p *stubIPProvider) Name() string           { return p.name }
+func (p *stubIPProvider) Timeout() time.Duration { return time.Second }
+
+func (p *stubIPProvider) Lookup(ctx context.Context, link ModemLink) (string, error) {
+	select {
+	case <-time.After(p.delay):
+		return p.ip, p.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func TestIPDiscoveryConsensus(t *testing.T) {
+	failed := errors.New("falhou")
+
+	tests := []struct {
+		name      string
+		consensus string
+		providers []*stubIPProvider
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "maioria",
+			consensus: ConsensusMajority,
+			providers: []*stubIPProvider{{ip: "177.25.10.1"}, {ip: "177.25.10.1"}, {ip: "189.40.20.1"}},
+			want:      "177.25.10.1",
+		},
+		{
+			name:      "maioria entre os que responderam",
+			consensus: ConsensusMajority,
+			providers: []*stubIPProvider{{ip: "177.25.10.1"}, {ip: "177.25.10.1"}, {err: failed}, {err: failed}},
+			want:      "177.25.10.1",
+		},
+		{
+			name:      "uma resposta só não é maioria",
+			consensus: ConsensusMajority,
+			providers: []*stubIPProvider{{ip: "177.25.10.1"}, {err: failed}, {err: failed}},
+			wantErr:   true,
+		},
+		{
+			name:      "um provedor configurado",
+			consensus: ConsensusMajority,
+			providers: []*stubIPProvider{{ip: "177.25.10.1"}},
+			want:      "177.25.10.1",
+		},
+		{
+			name:      "resposta que não é IP conta como falha",
+			consensus: ConsensusMajority,
+			providers: []*stubIPProvider{{ip: "<html>"}, {ip: "177.25.10.1"}, {ip: "177.25.10.1"}},
+			want:      "177.25.10.1",
+		},
+		{
+			name:      "empate sem maioria",
+			consensus: ConsensusMajority,
+			providers: []*stubIPProvider{{ip: "177.25.10.1"}, {ip: "189.40.20.1"}},
+			wantErr:   true,
+		},
+		{
+			name:      "nenhum respondeu",
+			consensus: ConsensusMajority,
+			providers: []*stubIPProvider{{err: failed}, {ip: "não é ip"}},
+			wantErr:   true,
+		},
+		{
+			name:      "first usa a resposta mais rápida",
+			consensus: ConsensusFirst,
+			providers: []*stubIPProvider{{ip: "177.25.10.1", delay: 200 * time.Millisecond}, {ip: "189.40.20.1"}},
+			want:      "189.40.20.1",
+		},
+		{
+			name:      "first ignora falhas",
+			consensus: ConsensusFirst,
+			providers: []*stubIPProvider{{err: failed}, {ip: "177.25.10.1", delay: 20 * time.Millisecond}},
+			want:      "177.25.10.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providers := make([]IPProvider, 0, len(tt.providers))
+			for i, p := range tt.providers {
+				p.name = string(rune('a' + i))
+				providers = append(providers, p)
+			}
+			discovery, err := NewIPDiscovery(providers, tt.consensus)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ip, err := discovery.Discover(context.Background(), ModemLink{HTTPPort: 6001})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("esperado erro, veio %s", ip)
+				}
+				return
+			}
+			if err != nil || ip != tt.want {
+				t.Fatalf("Discover = %q, %v; esperado %s", ip, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPDiscoveryCancel(t *testing.T) {
+	discovery, err := NewIPDiscovery([]IPProvider{&stubIPProvider{name: "lento", ip: "177.25.10.1", delay: time.Minute}}, ConsensusMajority)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	if _, err := discovery.Discover(ctx, ModemLink{HTTPPort: 6001}); err == nil {
+		t.Fatal("esperado erro com o contexto cancelado")
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Discover ignorou o contexto (%v)", elapsed)
+	}
+}
+
+// stunAttr monta um atributo de endereço IPv4 (família 0x01).
+func stunAttr(attrType uint16, ip string, xor bool) []byte {
+	attr := make([]byte, 12)
+	binary.BigEndian.PutUint16(attr[0:], attrType)
+	binary.BigEndian.PutUint16(attr[2:], 8)
+	attr[5] = 0x01
+	binary.BigEndian.PutUint16(attr[6:], 3478)
+	addr := binary.BigEndian.Uint32(net.ParseIP(ip).To4())
+	if xor {
+		addr ^= stunMagicCookie
+	}
+	binary.BigEndian.PutUint32(attr[8:], addr)
+	return attr
+}
+
+func stunMessage(msgType uint16, transactionID []byte, attrs ...[]byte) []byte {
+	body := make([]byte, 0)
+	for _, attr := range attrs {
+		body = append(body, attr...)
+	}
+	msg := make([]byte, 20, 20+len(body))
+	binary.BigEndian.PutUint16(msg[0:], msgType)
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(body)))
+	binary.BigEndian.PutUint32(msg[4:], stunMagicCookie)
+	copy(msg[8:], transactionID)
+	return append(msg, body...)
+}
+
+func TestParseSTUNResponse(t *testing.T) {
+	txID := []byte("abcdefghijkl")
+	otherTxID := []byte("zzzzzzzzzzzz")
+
+	// Atributo desconhecido de 5 bytes, alinhado em 8
+	software := []byte{0x80, 0x22, 0x00, 0x05, 'p', 'r', 'o', 'x', 'y', 0, 0, 0}
+	// Endereço IPv6 (família 0x02) não é usado
+	ipv6 := make([]byte, 24)
+	binary.BigEndian.PutUint16(ipv6[0:], stunXorMappedAddress)
+	binary.BigEndian.PutUint16(ipv6[2:], 20)
+	ipv6[5] = 0x02
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    string
+		wantErr bool
+	}{
+		{"xor-mapped", stunMessage(stunBindingResponse, txID, stunAttr(stunXorMappedAddress, "177.25.10.1", true)), "177.25.10.1", false},
+		{"mapped", stunMessage(stunBindingResponse, txID, stunAttr(stunMappedAddress, "189.40.20.1", false)), "189.40.20.1", false},
+		{"xor-mapped tem prioridade", stunMessage(stunBindingResponse, txID,
+			stunAttr(stunMappedAddress, "10.0.0.1", false), stunAttr(stunXorMappedAddress, "177.25.10.1", true)), "177.25.10.1", false},
+		{"atributo com padding antes", stunMessage(stunBindingResponse, txID, software, stunAttr(stunXorMappedAddress, "177.25.10.1", true)), "177.25.10.1", false},
+		{"só IPv6", stunMessage(stunBindingResponse, txID, ipv6), "", true},
+		{"sem endereço", stunMessage(stunBindingResponse, txID, software), "", true},
+		{"não é binding response", stunMessage(stunBindingRequest, txID, stunAttr(stunXorMappedAddress, "177.25.10.1", true)), "", true},
+		{"transação diferente", stunMessage(stunBindingResponse, otherTxID, stunAttr(stunXorMappedAddress, "177.25.10.1", true)), "", true},
+		{"truncada", stunMessage(stunBindingResponse, txID, stunAttr(stunXorMappedAddress, "177.25.10.1", true))[:28], "", true},
+		{"curta", []byte{0x01, 0x01, 0x00}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, err := parseSTUNResponse(tt.msg, txID)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("esperado erro, veio %s", ip)
+				}
+				return
+			}
+			if err != nil || ip != tt.want {
+				t.Fatalf("parseSTUNResponse = %q, %v; esperado %s", ip, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// HISTÓRICO DE IPS PÚBLICOS
+// ============================================================================
+
+const (
+	IPCauseDiscovered = "discovered"
+	IPCauseRenew      = "renew"
+	IPCauseCarrier    = "carrier"
+	IPCauseReconnect  = "reconnect"
+
+	ipHistoryLimit     = 500 // entradas por porta
+	ipHistoryFlushWait = time.Minute
+)
+
+// IPHistoryEntry é um período em que a porta saiu por um IP público. Cause
+// diz por que o IP mudou: renew (JobID indica o job), reconnect (o modem
+// reconectou e trocou o IP interno) ou carrier (a operadora trocou o IP
+// sem reconexão).
+type IPHistoryEntry struct {
+	IP         string    `json:"ip"`
+	ModemID    string    `json:"modem_id"`
+	Port       int       `json:"port"`
+	InternalIP string    `json:"internal_ip,omitempty"`
+	Cause      string    `json:"cause"`
+	JobID      string    `json:"job_id,omitempty"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// IPHistory guarda as entradas por porta HTTP, da mais antiga para a mais
+// recente, em DATA_DIR/ip-history.json.
+type IPHistory struct {
+	mu      sync.Mutex
+	entries map[int][]*IPHistoryEntry
+	path    string
+	savedAt time.Time
+}
+
+var ipHistory = &IPHistory{
+	entries: make(map[int][]*IPHistoryEntry),
+}
+
+func (h *IPHistory) SetDataDir(dir string) {
+	h.mu.Lock()
+	h.path = filepath.Join(dir, "ip-history.json")
+	h.mu.Unlock()
+}
+
+func (h *IPHistory) Load() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var entries []*IPHistoryEntry
+	if err := readJSONFile(h.path, &entries); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("erro ao ler histórico de IPs: %v", err)
+	}
+
+	for _, entry := range entries {
+		h.entries[entry.Port] = append(h.entries[entry.Port], entry)
+	}
+	for _, list := range h.entries {
+		sort.Slice(list, func(i, j int) bool { return list[i].FirstSeen.Before(list[j].FirstSeen) })
+	}
+	return nil
+}
+
+func (h *IPHistory) save() error {
+	if h.path == "" {
+		return nil
+	}
+
+	entries := make([]*IPHistoryEntry, 0)
+	for _, list := range h.entries {
+		entries = append(entries, list...)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Port != entries[j].Port {
+			return entries[i].Port < entries[j].Port
+		}
+		return entries[i].FirstSeen.Before(entries[j].FirstSeen)
+	})
+
+	if err := writeJSONFile(h.path, entries); err != nil {
+		return fmt.Errorf("erro ao salvar histórico de IPs: %v", err)
+	}
+	h.savedAt = time.Now()
+	return nil
+}
+
+// Flush grava o LastSeen ainda não salvo (no desligamento).
+func (h *IPHistory) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.save()
+}
+
+// Observe registra que a porta do link está saindo pelo IP. O mesmo IP da
+// última entrada só atualiza LastSeen, mesmo que o modem tenha mudado de
+// índice; um IP diferente abre uma entrada nova. Sem cause, o motivo é deduzido pela troca (ou não) do IP interno.
+func (h *IPHistory) Observe(link ModemLink, ip, cause, jobID string) {
+	if ip == "" || ip == "N/A" {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	list := h.entries[link.HTTPPort]
+
+	var last *IPHistoryEntry
+	if len(list) > 0 {
+		last = list[len(list)-1]
+	}
+
+	if last != nil && last.IP == ip {
+		last.LastSeen = now
+		last.ModemID = link.ModemID
+		if time.Since(h.savedAt) >= ipHistoryFlushWait {
+			if err := h.save(); err != nil {
+				log.Printf("⚠️  %v", err)
+			}
+		}
+		return
+	}
+
+	if cause == "" {
+		switch {
+		case last == nil:
+			cause = IPCauseDiscovered
+		case last.InternalIP != "" && link.IP != "" && last.InternalIP != link.IP:
+			cause = IPCauseReconnect
+		default:
+			cause = IPCauseCarrier
+		}
+	}
+
+	entry := &IPHistoryEntry{
+		IP:         ip,
+		ModemID:    link.ModemID,
+		Port:       link.HTTPPort,
+		InternalIP: link.IP,
+		Cause:      cause,
+		JobID:      jobID,
+		FirstSeen:  now,
+		LastSeen:   now,
+	}
+	list = append(list, entry)
+	if len(list) > ipHistoryLimit {
+		list = list[len(list)-ipHistoryLimit:]
+	}
+	h.entries[link.HTTPPort] = list
+
+	if last != nil {
+		log.Printf("🌐 Porta %d: IP público %s → %s (%s)", link.HTTPPort, last.IP, ip, cause)
+	}
+	if err := h.save(); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+}
+
+// ObserveStatus registra o IP lido no refresh de status. Portas renovando
+// ficam de fora: quem registra o IP novo é a própria renovação, com o job.
+func (h *IPHistory) ObserveStatus(modemID string, port int, ip string) {
+	if jobManager.Renewing(port) {
+		return
+	}
+
+	link, ok := linkRegistry.ByPort(port)
+	if !ok {
+		link = ModemLink{ModemID: modemID, HTTPPort: port}
+	}
+	h.Observe(link, ip, "", "")
+}
+
+// List devolve o histórico da porta, do mais recente para o mais antigo.
+func (h *IPHistory) List(port int) []IPHistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	list := h.entries[port]
+	entries := make([]IPHistoryEntry, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		entries = append(entries, *list[i])
+	}
+	return entries
+}
+
+// Recent devolve os últimos n IPs distintos vistos na porta. A porta segue
+// a identidade do modem, e não o índice, que muda após reset ou
+// renumeração USB.
+func (h *IPHistory) Recent(port, n int) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := append([]*IPHistoryEntry{}, h.entries[port]...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastSeen.After(entries[j].LastSeen) })
+
+	ips := make([]string, 0, n)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if len(ips) == n {
+			break
+		}
+		if !seen[entry.IP] {
+			seen[entry.IP] = true
+			ips = append(ips, entry.IP)
+		}
+	}
+	return ips
+}
+
+// HeldBy devolve o link de outra porta (exceto except) cujo IP atual é ip.
+// Só contam as portas com modem presente: o último IP de um modem removido
+// não está em uso.
+func (h *IPHistory) HeldBy(ip string, except int) (ModemLink, bool) {
+	links := linkRegistry.All()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, link := range links {
+		list := h.entries[link.HTTPPort]
+		if link.HTTPPort == except || len(list) == 0 {
+			continue
+		}
+		if list[len(list)-1].IP == ip {
+			return link, true
+		}
+	}
+	return ModemLink{}, false
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// JOBS DE RENOVAÇÃO - ESTRUTURAS
+// ============================================================================
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// Job registra uma renovação de IP do início ao fim. Source indica quem
+// disparou a renovação (api, sms, scheduler:<id do agendamento>).
+type Job struct {
+	ID          string        `json:"id"`
+	Type        string        `json:"type"`
+	Port        int           `json:"port"`
+	ModemID     string        `json:"modem_id,omitempty"`
+	Source      string        `json:"source"`
+	State       JobState      `json:"state"`
+	CreatedAt   time.Time     `json:"created_at"`
+	StartedAt   *time.Time    `json:"started_at,omitempty"`
+	FinishedAt  *time.Time    `json:"finished_at,omitempty"`
+	OldPublicIP string        `json:"old_public_ip,omitempty"`
+	NewPublicIP string        `json:"new_public_ip,omitempty"`
+	Attempts    int           `json:"attempts"`
+	Rejections  []IPRejection `json:"rejections,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Steps       []JobStep     `json:"steps"`
+	Log         []string      `json:"log"`
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type JobManager struct {
+	mu        sync.RWMutex
+	jobs      map[string]*Job
+	order     []string
+	maxJobs   int
+	portSlots map[int]chan struct{}
+}
+
+var jobManager = &JobManager{
+	jobs:      make(map[string]*Job),
+	order:     make([]string, 0),
+	maxJobs:   JOBS_MAX_HISTORY,
+	portSlots: make(map[int]chan struct{}),
+}
+
+// ============================================================================
+// JOBS DE RENOVAÇÃO - GERENCIAMENTO
+// ============================================================================
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (jm *JobManager) Create(jobType string, port int, source string) *Job {
+	job := &Job{
+		ID:        newJobID(),
+		Type:      jobType,
+		Port:      port,
+		Source:    source,
+		State:     JobQueued,
+		CreatedAt: time.Now(),
+		Steps:     make([]JobStep, 0),
+		Log:       make([]string, 0),
+		done:      make(chan struct{}),
+	}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	jm.jobs[job.ID] = job
+	jm.order = append(jm.order, job.ID)
+
+	// Descarta os jobs finalizados mais antigos acima do limite
+	for len(jm.order) > jm.maxJobs {
+		oldest := jm.jobs[jm.order[0]]
+		if oldest.State == JobQueued || oldest.State == JobRunning {
+			break
+		}
+		delete(jm.jobs, oldest.ID)
+		jm.order = jm.order[1:]
+	}
+
+	return job
+}
+
+// update aplica fn ao job sob o lock do gerenciador.
+func (jm *JobManager) update(id string, fn func(job *Job)) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	if job, ok := jm.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+func (jm *JobManager) Logf(id string, format string, args ...interface{}) {
+	line := fmt.Sprintf("[%s] %s", time.Now().Format("15:04:05"), fmt.Sprintf(format, args...))
+	jm.update(id, func(job *Job) {
+		job.Log = append(job.Log, line)
+	})
+}
+
+func (jm *JobManager) Start(id string) {
+	jm.update(id, func(job *Job) {
+		now := time.Now()
+		job.State = JobRunning
+		job.StartedAt = &now
+
+		eventBus.Publish(EventRenewStarted, map[string]interface{}{
+			"job_id": job.ID,
+			"port":   job.Port,
+			"source": job.Source,
+		})
+	})
+}
+
+func (jm *JobManager) Finish(id string, err error) {
+	jm.update(id, func(job *Job) {
+		now := time.Now()
+		job.FinishedAt = &now
+		switch {
+		case errors.Is(err, context.Canceled):
+			job.State = JobCancelled
+			job.Error = "cancelado"
+		case err != nil:
+			job.State = JobFailed
+			job.Error = err.Error()
+		default:
+			job.State = JobSucceeded
+		}
+
+		var duration time.Duration
+		if job.StartedAt != nil {
+			duration = now.Sub(*job.StartedAt)
+		}
+		metrics.ObserveRenew(job.Port, string(job.State), duration)
+
+		eventBus.Publish(EventRenewFinished, map[string]interface{}{
+			"job_id":        job.ID,
+			"port":          job.Port,
+			"modem_id":      job.ModemID,
+			"source":        job.Source,
+			"state":         job.State,
+			"old_public_ip": job.OldPublicIP,
+			"new_public_ip": job.NewPublicIP,
+			"attempts":      job.Attempts,
+			"error":         job.Error,
+			"duration":      duration.Round(time.Millisecond).String(),
+		})
+
+		job.cancel()
+		close(job.done)
+	})
+}
+
+// Cancel interrompe um job na fila ou em execução.
+func (jm *JobManager) Cancel(id string) bool {
+	jm.mu.RLock()
+	defer jm.mu.RUnlock()
+
+	job, ok := jm.jobs[id]
+	if !ok || (job.State != JobQueued && job.State != JobRunning) {
+		return false
+	}
+	job.cancel()
+	return true
+}
+
+// Wait bloqueia até o job terminar e devolve seu estado final.
+func (jm *JobManager) Wait(id string) (Job, bool) {
+	jm.mu.RLock()
+	job, ok := jm.jobs[id]
+	jm.mu.RUnlock()
+	if !ok {
+		return Job{}, false
+	}
+
+	<-job.done
+	return jm.Get(id)
+}
+
+func (jm *JobManager) Get(id string) (Job, bool) {
+	jm.mu.RLock()
+	defer jm.mu.RUnlock()
+
+	job, ok := jm.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return job.snapshot(), true
+}
+
+// List devolve os jobs do mais recente para o mais antigo.
+func (jm *JobManager) List() []Job {
+	jm.mu.RLock()
+	defer jm.mu.RUnlock()
+
+	jobs := make([]Job, 0, len(jm.order))
+	for _, id := range jm.order {
+		jobs = append(jobs, jm.jobs[id].snapshot())
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+func (job *Job) snapshot() Job {
+	copied := *job
+	copied.Steps = append([]JobStep(nil), job.Steps...)
+	copied.Log = append([]string(nil), job.Log...)
+	return copied
+}
+
+// portSlot serializa jobs da mesma porta: um segundo pedido fica "queued"
+// até o anterior terminar (ou até ser cancelado).
+func (jm *JobManager) portSlot(port int) chan struct{} {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	slot, ok := jm.portSlots[port]
+	if !ok {
+		slot = make(chan struct{}, 1)
+		jm.portSlots[port] = slot
+	}
+	return slot
+}
+
+// Renewing informa se há uma renovação em execução na porta.
+func (jm *JobManager) Renewing(port int) bool {
+	jm.mu.RLock()
+	defer jm.mu.RUnlock()
+
+	slot, ok := jm.portSlots[port]
+	return ok && len(slot) > 0
+}
+
+// ============================================================================
+// JOBS DE RENOVAÇÃO - EXECUÇÃO
+// ============================================================================
+
+// jobReporter encaminha o progresso do orquestrador para o job.
+type jobReporter struct {
+	jobID string
+}
+
+func (r jobReporter) Logf(format string, args ...interface{}) {
+	jobManager.Logf(r.jobID, format, args...)
+}
+
+func (r jobReporter) StepFinished(step JobStep) {
+	jobManager.update(r.jobID, func(job *Job) {
+		job.Steps = append(job.Steps, step)
+		job.Attempts = step.Attempt
+
+		eventBus.Publish(EventRenewProgress, map[string]interface{}{
+			"job_id": job.ID,
+			"port":   job.Port,
+			"step":   step,
+		})
+	})
+	if step.Error != "" {
+		jobManager.Logf(r.jobID, "Etapa %s (tentativa %d.%d) falhou em %s: %s", step.Name, step.Attempt, step.Try, step.Duration, step.Error)
+	} else {
+		jobManager.Logf(r.jobID, "Etapa %s (tentativa %d) ok em %s", step.Name, step.Attempt, step.Duration)
+	}
+}
+
+// startRenewJob cria o job e executa a renovação em background.
+func startRenewJob(port int, source string, opts RenewOptions) *Job {
+	job := jobManager.Create("renew", port, source)
+	opts.JobID = job.ID
+	go runRenewJob(job.ctx, job.ID, port, opts)
+	return job
+}
+
+func runRenewJob(ctx context.Context, jobID string, port int, opts RenewOptions) {
+	slot := jobManager.portSlot(port)
+
+	select {
+	case slot <- struct{}{}:
+		defer func() { <-slot }()
+	case <-ctx.Done():
+		jobManager.Finish(jobID, ctx.Err())
+		return
+	}
+
+	jobManager.Start(jobID)
+	jobManager.Logf(jobID, "Renovação da porta %d iniciada", port)
+
+	result, err := renewOrchestrator.Renew(ctx, port, opts, jobReporter{jobID})
+	if result != nil {
+		jobManager.update(jobID, func(job *Job) {
+			job.ModemID = result.ModemID
+			job.OldPublicIP = result.OldPublicIP
+			job.NewPublicIP = result.NewPublicIP
+			job.Attempts = result.Attempts
+			job.Rejections = result.Rejections
+		})
+	}
+
+	if err == nil && !result.Changed {
+		err = fmt.Errorf("IP não mudou (operadora pode ter mantido)")
+	}
+
+	if err != nil {
+		log.Printf("❌ Erro ao renovar porta %d: %v", port, err)
+		jobManager.Logf(jobID, "Falha: %v", err)
+	} else {
+		log.Printf("✅ IP da porta %d renovado com sucesso: %s → %s", port, result.OldPublicIP, result.NewPublicIP)
+		jobManager.Logf(jobID, "IP RENOVADO COM SUCESSO")
+	}
+
+	jobManager.Finish(jobID, err)
+	invalidateCache()
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// REGISTRO DE MODEMS E PORTAS
+// ============================================================================
+
+// ModemLink liga um modem à sua interface, rota e portas de proxy. Index é a
+// posição na detecção: tabela de roteamento 100+Index e métrica 10+Index,
+// como no proxy-manager.sh. As portas seguem o mapa de portas da Identity
+// (IMEI ou ICCID), não o índice do ModemManager.
+type ModemLink struct {
+	Index     int    `json:"index"`
+	ModemID   string `json:"id"`
+	Identity  string `json:"identity,omitempty"`
+	Interface string `json:"interface"`
+	IP        string `json:"ip"`
+	Gateway   string `json:"gateway"`
+	Prefix    int    `json:"prefix,omitempty"`
+	HTTPPort  int    `json:"http_port"`
+	SOCKSPort int    `json:"socks_port"`
+}
+
+// statusFile tem o mesmo formato do /var/run/proxy-status.json gerado pelo
+// save_status do proxy-manager.sh.
+type statusFile struct {
+	Timestamp  string      `json:"timestamp"`
+	ModemCount int         `json:"modem_count"`
+	Modems     []ModemLink `json:"modems"`
+}
+
+// LinkRegistry mantém os ModemLinks. A fonte é o arquivo de status do
+// script (recarregado quando ele muda, p.ex. após um restart) ou o arquivo
+// próprio da API em DATA_DIR, atualizado pelas renovações feitas em Go.
+type LinkRegistry struct {
+	mu         sync.RWMutex
+	links      []ModemLink
+	statusPath string
+	savePath   string
+	loadedAt   time.Time
+}
+
+var linkRegistry = &LinkRegistry{
+	statusPath: STATUS_FILE_PATH,
+}
+
+func (lr *LinkRegistry) SetDataDir(dir string) {
+	lr.mu.Lock()
+	lr.savePath = filepath.Join(dir, "modems.json")
+	lr.mu.Unlock()
+}
+
+// Refresh recarrega os links se algum arquivo de origem mudou. Sem arquivo,
+// os links são derivados da ordem dos modems no backend. As consultas ao
+// backend são feitas sem a trava; ela só é tomada para trocar os links, e a
+// troca é descartada se o registro mudou nesse meio-tempo.
+func (lr *LinkRegistry) Refresh(ctx context.Context) {
+	lr.mu.RLock()
+	source, modTime := lr.newestSource()
+	loadedAt, empty := lr.loadedAt, len(lr.links) == 0
+	lr.mu.RUnlock()
+
+	if source != "" {
+		if !modTime.After(loadedAt) {
+			return
+		}
+
+		var status statusFile
+		if err := readJSONFile(source, &status); err != nil {
+			log.Printf("⚠️  Erro ao ler %s: %v", source, err)
+			return
+		}
+		links := assignPorts(ctx, normalizeLinks(status.Modems))
+
+		lr.mu.Lock()
+		defer lr.mu.Unlock()
+		if !modTime.After(lr.loadedAt) {
+			return
+		}
+		lr.links = links
+		lr.loadedAt = modTime
+		return
+	}
+
+	if !empty {
+		return
+	}
+
+	modemIDs, err := modemBackend.ListModems(ctx)
+	if err != nil {
+		return
+	}
+
+	ports := currentConfig().Ports
+	links := make([]ModemLink, 0, len(modemIDs))
+	for i, modemID := range modemIDs {
+		link := ModemLink{
+			Index:     i,
+			ModemID:   modemID,
+			HTTPPort:  ports.BaseHTTP + i + 1,
+			SOCKSPort: ports.BaseSOCKS + i + 1,
+		}
+
+		if info, err := modemBackend.GetModem(ctx, modemID); err == nil && info.CurrentBearer() != "" {
+			if bearer, err := modemBackend.GetBearer(ctx, info.CurrentBearer()); err == nil {
+				link.Interface = bearer.Interface
+				link.IP = bearer.Address
+				link.Gateway = bearer.Gateway
+				link.Prefix = bearer.Prefix
+			}
+		}
+
+		links = append(links, link)
+	}
+	links = assignPorts(ctx, links)
+
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	if len(lr.links) > 0 {
+		return
+	}
+	lr.links = links
+}
+
+// assignPorts aplica o mapa de portas aos links. Uma identidade nova fica
+// com a porta que o link já tinha, se livre; sem identidade legível, o link
+// mantém as portas de origem. Links de arquivo com índice desatualizado
+// (modems renumerados desde que foi gravado) são corrigidos pela identidade.
+func assignPorts(ctx context.Context, links []ModemLink) []ModemLink {
+	for i := range links {
+		link := &links[i]
+
+		var kind string
+		if link.Identity != "" {
+			kind = identityKind(link.Identity)
+			if modemID, err := LocateModem(ctx, modemBackend, link.Identity, link.ModemID); err == nil && modemID != link.ModemID {
+				log.Printf("📌 Modem %s (porta %d) mudou de índice: %s → %s", link.Identity, link.HTTPPort, link.ModemID, modemID)
+				link.ModemID = modemID
+			}
+		} else {
+			identity, identityType, err := ModemIdentity(ctx, modemBackend, link.ModemID)
+			if err != nil {
+				log.Printf("⚠️  Portas do modem %s sem identidade: %v", link.ModemID, err)
+				continue
+			}
+			link.Identity, kind = identity, identityType
+		}
+
+		assignment, err := portMap.Assign(link.Identity, kind, link.ModemID, link.HTTPPort)
+		if err != nil {
+			log.Printf("⚠️  %v", err)
+			continue
+		}
+		link.HTTPPort = assignment.HTTPPort
+		link.SOCKSPort = assignment.SOCKSPort
+	}
+	return links
+}
+
+// newestSource escolhe entre o arquivo do script e o da API o mais recente.
+func (lr *LinkRegistry) newestSource() (string, time.Time) {
+	var source string
+	var newest time.Time
+
+	for _, p := range []string{lr.statusPath, lr.savePath} {
+		if p == "" {
+			continue
+		}
+		stat, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if stat.ModTime().After(newest) {
+			source = p
+			newest = stat.ModTime()
+		}
+	}
+	return source, newest
+}
+
+func normalizeLinks(links []ModemLink) []ModemLink {
+	ports := currentConfig().Ports
+	normalized := make([]ModemLink, 0, len(links))
+	for i, link := range links {
+		if link.Index == 0 && i > 0 {
+			link.Index = i
+		}
+		if link.SOCKSPort == 0 {
+			link.SOCKSPort = link.HTTPPort + (ports.BaseSOCKS - ports.BaseHTTP)
+		}
+		normalized = append(normalized, link)
+	}
+	return normalized
+}
+
+func (lr *LinkRegistry) All() []ModemLink {
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+
+	links := make([]ModemLink, len(lr.links))
+	copy(links, lr.links)
+	return links
+}
+
+// ByPort aceita tanto a porta HTTP quanto a SOCKS5 do modem.
+func (lr *LinkRegistry) ByPort(port int) (ModemLink, bool) {
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+
+	for _, link := range lr.links {
+		if link.HTTPPort == port || link.SOCKSPort == port {
+			return link, true
+		}
+	}
+	return ModemLink{}, false
+}
+
+func (lr *LinkRegistry) ByModem(modemID string) (ModemLink, bool) {
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+
+	for _, link := range lr.links {
+		if link.ModemID == modemID {
+			return link, true
+		}
+	}
+	return ModemLink{}, false
+}
+
+func (lr *LinkRegistry) ByIdentity(identity string) (ModemLink, bool) {
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+
+	for _, link := range lr.links {
+		if identity != "" && link.Identity == identity {
+			return link, true
+		}
+	}
+	return ModemLink{}, false
+}
+
+// NextIndex devolve o menor Index livre, que define a tabela de roteamento
+// de um modem novo.
+func (lr *LinkRegistry) NextIndex() int {
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+
+	used := make(map[int]bool)
+	for _, link := range lr.links {
+		used[link.Index] = true
+	}
+	index := 0
+	for used[index] {
+		index++
+	}
+	return index
+}
+
+// Update substitui o link da mesma porta HTTP e persiste o registro.
+func (lr *LinkRegistry) Update(updated ModemLink) error {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	found := false
+	for i, link := range lr.links {
+		if link.HTTPPort == updated.HTTPPort {
+			lr.links[i] = updated
+			found = true
+		}
+	}
+	if !found {
+		lr.links = append(lr.links, updated)
+		sort.Slice(lr.links, func(i, j int) bool { return lr.links[i].HTTPPort < lr.links[j].HTTPPort })
+	}
+
+	return lr.save()
+}
+
+// Remove tira o link da porta HTTP do registro (modem removido).
+func (lr *LinkRegistry) Remove(httpPort int) error {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	links := make([]ModemLink, 0, len(lr.links))
+	for _, link := range lr.links {
+		if link.HTTPPort != httpPort {
+			links = append(links, link)
+		}
+	}
+	if len(links) == len(lr.links) {
+		return nil
+	}
+
+	previous := lr.links
+	lr.links = links
+	if err := lr.save(); err != nil {
+		lr.links = previous
+		return err
+	}
+	return nil
+}
+
+// Remap move o modem da identidade para novas portas (mapa de portas
+// alterado via API). O proxy das portas antigas para no próximo sync.
+// Retorna false se o modem não está no registro.
+func (lr *LinkRegistry) Remap(identity string, httpPort, socksPort int) (bool, error) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	for i, link := range lr.links {
+		if link.Identity != identity {
+			continue
+		}
+		if link.HTTPPort == httpPort && link.SOCKSPort == socksPort {
+			return true, nil
+		}
+
+		lr.links[i].HTTPPort = httpPort
+		lr.links[i].SOCKSPort = socksPort
+		log.Printf("📌 Modem %s: portas %d/%d → %d/%d", link.ModemID, link.HTTPPort, link.SOCKSPort, httpPort, socksPort)
+		return true, lr.save()
+	}
+	return false, nil
+}
+
+func (lr *LinkRegistry) save() error {
+	if lr.savePath == "" {
+		return nil
+	}
+
+	status := statusFile{
+		Timestamp:  time.Now().Format(time.RFC3339),
+		ModemCount: len(lr.links),
+		Modems:     lr.links,
+	}
+	if err := writeJSONFile(lr.savePath, status); err != nil {
+		return fmt.Errorf("erro ao salvar registro de modems: %v", err)
+	}
+
+	if stat, err := os.Stat(lr.savePath); err == nil {
+		lr.loadedAt = stat.ModTime()
+	}
+	return nil
+}
+
+// ============================================================================
+// PERSISTÊNCIA EM ARQUIVOS JSON
+// ============================================================================
+
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONFile grava em arquivo temporário e renomeia, para nunca deixar
+// um JSON pela metade em caso de queda.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -1,16 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -32,15 +35,19 @@ type Modem struct {
 	InternalIP string `json:"internal_ip"`
 	State      string `json:"state"`
 	Signal     string `json:"signal"`
+	AccessTech string `json:"access_tech,omitempty"`
 }
 
 type Proxy struct {
-	Port      int    `json:"port"`
-	PublicIP  string `json:"public_ip"`
-	Protocol  string `json:"protocol"`
-	Modem     string `json:"modem"`
-	Running   bool   `json:"running"`
-	Interface string `json:"interface,omitempty"`
+	Port        int    `json:"port"`
+	PublicIP    string `json:"public_ip"`
+	Protocol    string `json:"protocol"`
+	Modem       string `json:"modem"`
+	Running     bool   `json:"running"`
+	Connections int    `json:"connections"`
+	Interface   string `json:"interface,omitempty"`
+
+	Health *ProxyHealth `json:"health,omitempty"`
 }
 
 type SystemStatus struct {
@@ -56,8 +63,13 @@ type Status struct {
 	System  SystemStatus `json:"system"`
 }
 
+// RenewRequest aceita as opções de IP novo: reject_recent recusa os últimos
+// N IPs do modem e reject_in_use os IPs de outros modems.
 type RenewRequest struct {
-	Port int `json:"port"`
+	Port         int  `json:"port"`
+	RejectRecent int  `json:"reject_recent"`
+	RejectInUse  bool `json:"reject_in_use"`
+	MaxIPRetries int  `json:"max_ip_retries"`
 }
 
 // ============================================================================
@@ -99,6 +111,49 @@ const (
 	MAX_MODEMS         = 100
 	SMS_CHECK_INTERVAL = 10 * time.Second
 	SMS_MAX_HISTORY    = 100
+	STATUS_CACHE_TTL   = 30 * time.Second
+	JOBS_MAX_HISTORY   = 200
+	RENEW_MAX_ATTEMPTS = 3
+
+	RENEW_MAX_IP_RETRIES = 5
+
+	STATUS_FILE_PATH    = "/var/run/proxy-status.json"
+	DEFAULT_DATA_DIR    = "/var/lib/proxy-api"
+	PROXY_BIND_HOST     = "0.0.0.0"
+	PROXY_SYNC_INTERVAL = 30 * time.Second
+	GATEWAY_STRATEGY    = StrategyRoundRobin
+	SESSION_TTL         = 10 * time.Minute
+	PUBLIC_IP_TIMEOUT   = 5 * time.Second
+
+	PROBE_INTERVAL         = 30 * time.Second
+	PROBE_TIMEOUT          = 10 * time.Second
+	PROBE_WINDOW           = 20
+	PROBE_DEAD_AFTER       = 3
+	PROBE_MIN_SUCCESS_RATE = 0.8
+	PROBE_SLOW             = 3 * time.Second
+
+	SUPERVISOR_INTERVAL   = 15 * time.Second
+	RECONNECT_BACKOFF_MIN = 10 * time.Second
+	RECONNECT_BACKOFF_MAX = 10 * time.Minute
+
+	MODEM_WATCH_INTERVAL = 10 * time.Second
+	EVENTS_HEARTBEAT     = 15 * time.Second
+	EVENTS_RETRY         = 3 * time.Second
+
+	SIGNAL_HISTORY_INTERVAL  = time.Minute
+	SIGNAL_HISTORY_RETENTION = 30 * 24 * time.Hour
+
+	WEBHOOK_TICK         = 5 * time.Second
+	WEBHOOK_TIMEOUT      = 10 * time.Second
+	WEBHOOK_BACKOFF_MIN  = 10 * time.Second
+	WEBHOOK_BACKOFF_MAX  = time.Hour
+	WEBHOOK_MAX_ATTEMPTS = 10
+	SCHEDULER_TICK       = 15 * time.Second
+	SCHEDULER_STAGGER    = 60 * time.Second
+
+	DEFAULT_APN      = "zap.vivo.com.br"
+	DEFAULT_APN_USER = "vivo"
+	DEFAULT_APN_PASS = "vivo"
 )
 
 // ============================================================================
@@ -109,8 +164,9 @@ var (
 	statusCache      *Status
 	statusCacheMutex sync.RWMutex
 	statusCacheTime  time.Time
-	cacheTTL         = 30 * time.Second
 	smsManager       *SMSManager
+	modemBackend     ModemBackend
+	dataDir          string
 )
 
 // ============================================================================
@@ -126,127 +182,1437 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "mock-modemmanager" {
+		runMockModemManager()
+		return
+	}
+
+	if err := configStore.Load(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	cfg := currentConfig()
+
+	backend, err := newModemBackend(cfg.Modems)
+	if err != nil {
+		log.Fatalf("❌ Erro ao iniciar backend de modems: %v", err)
+	}
+	modemBackend = backend
+
+	discovery, err := newIPDiscovery(cfg.PublicIP, modemBackend)
+	if err != nil {
+		log.Fatalf("❌ Erro ao configurar descoberta de IP público: %v", err)
+	}
+	setIPDiscovery(discovery)
+
+	dataDir = cfg.DataDir
+	linkRegistry.SetDataDir(dataDir)
+	userStore.SetDataDir(dataDir)
+	if err := userStore.Load(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if !userStore.Enabled() {
+		log.Println("⚠️  Nenhum usuário de proxy cadastrado: proxies abertos sem autenticação")
+	}
+	apiKeyStore.SetDataDir(dataDir)
+	if err := apiKeyStore.Load(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if err := apiKeyStore.Bootstrap(dataDir); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	ipHistory.SetDataDir(dataDir)
+	if err := ipHistory.Load(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	scheduler.SetDataDir(dataDir)
+	if err := scheduler.Load(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	scheduler.SetStagger(time.Duration(cfg.Scheduler.Stagger))
+	webhooks.SetDataDir(dataDir)
+	if err := webhooks.Load(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	apnStore.SetDataDir(dataDir)
+	if err := apnStore.Load(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	migrateCtx, cancelMigrate := context.WithTimeout(context.Background(), time.Minute)
+	userStore.MigrateModemRefs(migrateCtx, modemBackend)
+	apnStore.MigrateModemRefs(migrateCtx, modemBackend)
+	cancelMigrate()
+	portMap.SetDataDir(dataDir)
+	if err := portMap.Load(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	signalHistory.SetDataDir(dataDir)
+	if err := signalHistory.Load(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	trafficStats.SetDataDir(dataDir)
+	if err := trafficStats.Load(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	network := newNetwork(cfg.Network.Mode, modemBackend)
+	_, noop := network.(noopNetwork)
+	proxyServer = NewProxyServer(cfg.Proxy.Bind, !noop)
+	renewOrchestrator = NewRenewOrchestrator(modemBackend, network, proxyServer, linkRegistry)
+	hotplug = NewHotplug(renewOrchestrator, network, proxyServer, linkRegistry)
+
+	healthChecker.SetTargets(cfg.Probe.Targets)
+	healthChecker.SetInterval(time.Duration(cfg.Probe.Interval))
+	sessionManager.SetTTL(time.Duration(cfg.Gateway.SessionTTL))
+	smsManager.SetMaxHistory(cfg.SMS.MaxHistory)
+
+	gateway, err = NewGateway(cfg.Gateway.Strategy, cfg.Ports.BaseHTTP, cfg.Ports.BaseSOCKS)
+	if err != nil {
+		log.Fatalf("❌ Erro ao iniciar gateway: %v", err)
+	}
+
 	router := mux.NewRouter()
 
-	// Rotas do sistema
-	router.HandleFunc("/health", healthHandler).Methods("GET")
-	router.HandleFunc("/status", statusHandler).Methods("GET")
-	router.HandleFunc("/restart", restartHandler).Methods("POST")
-	router.HandleFunc("/renew", renewHandler).Methods("POST")
+	// Rotas do sistema
+	router.HandleFunc("/health", healthHandler).Methods("GET")
+	router.HandleFunc("/auth/me", requireRole(RoleViewer, authMeHandler)).Methods("GET")
+	router.HandleFunc("/status", requireRole(RoleViewer, statusHandler)).Methods("GET")
+	router.HandleFunc("/restart", requireRole(RoleAdmin, restartHandler)).Methods("POST")
+	router.HandleFunc("/admin/config", requireRole(RoleAdmin, configHandler)).Methods("GET")
+	router.HandleFunc("/admin/reload", requireRole(RoleAdmin, configReloadHandler)).Methods("POST")
+	router.HandleFunc("/renew", requireRole(RoleOperator, renewHandler)).Methods("POST")
+	router.HandleFunc("/jobs", requireRole(RoleViewer, jobsHandler)).Methods("GET")
+	router.HandleFunc("/jobs/{id}", requireRole(RoleViewer, jobHandler)).Methods("GET")
+	router.HandleFunc("/jobs/{id}/cancel", requireRole(RoleOperator, jobCancelHandler)).Methods("POST")
+	router.HandleFunc("/proxies/{port}/connections", requireRole(RoleViewer, proxyConnectionsHandler)).Methods("GET")
+	router.HandleFunc("/proxies/{port}/ip-history", requireRole(RoleViewer, proxyIPHistoryHandler)).Methods("GET")
+	router.HandleFunc("/proxies/{port}/traffic", requireRole(RoleViewer, proxyTrafficHandler)).Methods("GET")
+	router.HandleFunc("/gateway", requireRole(RoleViewer, gatewayHandler)).Methods("GET")
+	router.HandleFunc("/gateway", requireRole(RoleAdmin, gatewayUpdateHandler)).Methods("POST")
+	router.HandleFunc("/sessions", requireRole(RoleViewer, sessionsHandler)).Methods("GET")
+	router.HandleFunc("/sessions/{key}", requireRole(RoleOperator, sessionDeleteHandler)).Methods("DELETE")
+	router.HandleFunc("/events", allowQueryToken(requireRole(RoleViewer, eventsHandler))).Methods("GET")
+	router.HandleFunc("/metrics", requireRole(RoleViewer, metricsHandler)).Methods("GET")
+	router.HandleFunc("/supervisor", requireRole(RoleViewer, supervisorHandler)).Methods("GET")
+	router.HandleFunc("/schedules", requireRole(RoleViewer, schedulesHandler)).Methods("GET")
+	router.HandleFunc("/schedules", requireRole(RoleOperator, scheduleCreateHandler)).Methods("POST")
+	router.HandleFunc("/schedules/{id}", requireRole(RoleOperator, scheduleUpdateHandler)).Methods("PUT")
+	router.HandleFunc("/schedules/{id}", requireRole(RoleOperator, scheduleDeleteHandler)).Methods("DELETE")
+	router.HandleFunc("/webhooks", requireRole(RoleAdmin, webhooksHandler)).Methods("GET")
+	router.HandleFunc("/webhooks", requireRole(RoleAdmin, webhookCreateHandler)).Methods("POST")
+	router.HandleFunc("/webhooks/{id}", requireRole(RoleAdmin, webhookUpdateHandler)).Methods("PUT")
+	router.HandleFunc("/webhooks/{id}", requireRole(RoleAdmin, webhookDeleteHandler)).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id}/deliveries", requireRole(RoleOperator, webhookDeliveriesHandler)).Methods("GET")
+	router.HandleFunc("/webhooks/{id}/replay", requireRole(RoleOperator, webhookReplayHandler)).Methods("POST")
+	router.HandleFunc("/webhooks/{id}/deliveries/{delivery}/replay", requireRole(RoleOperator, webhookReplayHandler)).Methods("POST")
+	router.HandleFunc("/apn-profiles", requireRole(RoleViewer, apnProfilesHandler)).Methods("GET")
+	router.HandleFunc("/apn-profiles", requireRole(RoleAdmin, apnProfileCreateHandler)).Methods("POST")
+	router.HandleFunc("/apn-profiles/{id}", requireRole(RoleAdmin, apnProfileUpdateHandler)).Methods("PUT")
+	router.HandleFunc("/apn-profiles/{id}", requireRole(RoleAdmin, apnProfileDeleteHandler)).Methods("DELETE")
+	router.HandleFunc("/modems/{id}", requireRole(RoleViewer, modemHandler)).Methods("GET")
+	router.HandleFunc("/modems/{id}/history", requireRole(RoleViewer, modemHistoryHandler)).Methods("GET")
+	router.HandleFunc("/modems/{id}/apn", requireRole(RoleViewer, modemAPNHandler)).Methods("GET")
+	router.HandleFunc("/modems/{id}/apn", requireRole(RoleAdmin, modemAPNUpdateHandler)).Methods("PUT")
+	router.HandleFunc("/modems/{id}/apn", requireRole(RoleAdmin, modemAPNDeleteHandler)).Methods("DELETE")
+	router.HandleFunc("/port-map", requireRole(RoleViewer, portMapHandler)).Methods("GET")
+	router.HandleFunc("/port-map/{identity}", requireRole(RoleAdmin, portMapUpdateHandler)).Methods("PUT")
+	router.HandleFunc("/port-map/{identity}", requireRole(RoleAdmin, portMapDeleteHandler)).Methods("DELETE")
+	router.HandleFunc("/users", requireRole(RoleAdmin, usersHandler)).Methods("GET")
+	router.HandleFunc("/users", requireRole(RoleAdmin, userCreateHandler)).Methods("POST")
+	router.HandleFunc("/users/{username}", requireRole(RoleAdmin, userUpdateHandler)).Methods("PUT")
+	router.HandleFunc("/users/{username}", requireRole(RoleAdmin, userDeleteHandler)).Methods("DELETE")
+	router.HandleFunc("/api-keys", requireRole(RoleAdmin, apiKeysHandler)).Methods("GET")
+	router.HandleFunc("/api-keys", requireRole(RoleAdmin, apiKeyCreateHandler)).Methods("POST")
+	router.HandleFunc("/api-keys/{id}", requireRole(RoleAdmin, apiKeyDeleteHandler)).Methods("DELETE")
+
+	// Rotas SMS
+	router.HandleFunc("/sms/inbox", requireRole(RoleViewer, smsInboxHandler)).Methods("GET")
+	router.HandleFunc("/sms/inbox/{modem_id}", requireRole(RoleViewer, smsInboxByModemHandler)).Methods("GET")
+	router.HandleFunc("/sms/send", requireRole(RoleOperator, smsSendHandler)).Methods("POST")
+	router.HandleFunc("/sms/history", requireRole(RoleViewer, smsHistoryHandler)).Methods("GET")
+	router.HandleFunc("/sms/delete", requireRole(RoleAdmin, smsDeleteHandler)).Methods("POST")
+
+	router.PathPrefix("/").Handler(http.FileServer(http.Dir(".")))
+
+	// Iniciar polling de SMS em background
+	go startSMSPolling()
+
+	// Listeners HTTP/SOCKS5 de cada modem
+	go startProxySync()
+
+	// Gravação dos contadores de tráfego dos proxies
+	go trafficStats.Run()
+
+	// Bytes das conexões abertas entram no tráfego periodicamente
+	go proxyServer.Run()
+
+	// Sondagem de saúde dos proxies
+	go healthChecker.Run()
+
+	// Limpeza das sessões fixas expiradas
+	go sessionManager.Run()
+
+	// Religação automática de modems que caíram
+	go supervisor.Run()
+
+	// Eventos de modems adicionados, removidos e mudanças de estado; modems
+	// conectados ou removidos em execução passam pelo hotplug
+	go watchModems()
+
+	// Histórico de sinal, tecnologia e registro dos modems
+	go signalHistory.Run()
+
+	// Entrega dos eventos para os webhooks
+	go webhooks.Run()
+
+	// Rotação automática de IP
+	go scheduler.Run()
+
+	// Recarga da configuração no SIGHUP
+	go watchReloadSignal()
+
+	// Gravação dos dados em memória no SIGTERM/SIGINT
+	go watchShutdownSignal()
+
+	// Porta única que distribui entre os modems
+	if err := gateway.Start(cfg.Proxy.Bind); err != nil {
+		log.Printf("❌ %v", err)
+	}
+
+	log.Println("========================================")
+	log.Println("🚀 API Proxy Manager v2.0 + SMS")
+	log.Println("========================================")
+	log.Printf("📡 Servidor: http://%s", cfg.Listen)
+	log.Printf("📱 SMS Polling: Ativo (%s)", time.Duration(cfg.SMS.CheckInterval))
+	log.Printf("🔌 Backend de modems: %s", modemBackend.Name())
+	if configStore.Path() != "" {
+		log.Printf("⚙️  Configuração: %s", configStore.Path())
+	}
+	log.Println("========================================")
+	log.Fatal(http.ListenAndServe(cfg.Listen, corsMiddleware(router)))
+}
+
+// watchShutdownSignal grava, antes de sair, o que só vai para o disco
+// periodicamente: tráfego dos proxies, LastSeen do histórico de IPs e
+// histórico de sinal (systemctl stop/restart).
+func watchShutdownSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	sig := <-signals
+	log.Printf("🛑 %s recebido: gravando dados antes de sair", sig)
+	proxyServer.FoldTraffic()
+	for _, flush := range []func() error{trafficStats.Flush, ipHistory.Flush, signalHistory.Flush} {
+		if err := flush(); err != nil {
+			log.Printf("⚠️  %v", err)
+		}
+	}
+	os.Exit(0)
+}
+
+// ============================================================================
+// HANDLERS - SISTEMA
+// ============================================================================
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "API Proxy Manager está online",
+		Data: map[string]string{
+			"version":    "2.0.1",
+			"status":     "healthy",
+			"max_modems": strconv.Itoa(currentConfig().Ports.MaxModems),
+			"sms":        "enabled",
+			"backend":    modemBackend.Name(),
+		},
+	})
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	status := *getSystemStatus()
+
+	// A saúde muda a cada sondagem: não fica no cache do status
+	status.Proxies = append([]Proxy(nil), status.Proxies...)
+	for i := range status.Proxies {
+		status.Proxies[i].Health = healthChecker.Health(status.Proxies[i].Port)
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Status obtido com sucesso",
+		Data:    status,
+	})
+}
+
+func restartHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("🔄 Recebida solicitação de restart do sistema")
+
+	go func() {
+		time.Sleep(2 * time.Second)
+
+		cmd := exec.Command("sudo", currentConfig().ProxyManagerPath, "restart")
+		metrics.Subprocess("proxy-manager")
+		output, err := cmd.CombinedOutput()
+
+		if err != nil {
+			log.Printf("❌ Erro ao reiniciar: %v - %s", err, string(output))
+		} else {
+			log.Println("✅ Sistema reiniciado com sucesso")
+		}
+
+		invalidateCache()
+	}()
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Comando de restart enviado. Sistema será reiniciado em alguns segundos.",
+	})
+}
+
+func renewHandler(w http.ResponseWriter, r *http.Request) {
+	var req RenewRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Dados inválidos: " + err.Error(),
+		})
+		return
+	}
+
+	ports := currentConfig().Ports
+	if req.Port < ports.BaseHTTP+1 || req.Port > ports.BaseHTTP+ports.MaxModems {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Porta inválida. Deve estar entre %d e %d", ports.BaseHTTP+1, ports.BaseHTTP+ports.MaxModems),
+		})
+		return
+	}
+
+	if req.RejectRecent < 0 || req.RejectRecent > ipHistoryLimit {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("reject_recent deve estar entre 0 e %d", ipHistoryLimit),
+		})
+		return
+	}
+
+	if req.MaxIPRetries < 0 {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "max_ip_retries não pode ser negativo (0 usa o padrão)",
+		})
+		return
+	}
+
+	log.Printf("🔄 Recebida solicitação de renovação de IP para porta %d", req.Port)
+
+	job := startRenewJob(req.Port, "api", RenewOptions{
+		RejectRecent: req.RejectRecent,
+		RejectInUse:  req.RejectInUse,
+		MaxIPRetries: req.MaxIPRetries,
+	})
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Renovação de IP iniciada. Acompanhe em /jobs/%s", job.ID),
+		Data: map[string]interface{}{
+			"port":   req.Port,
+			"job_id": job.ID,
+		},
+	})
+}
+
+// ============================================================================
+// HANDLERS - JOBS
+// ============================================================================
+
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	portFilter, _ := strconv.Atoi(r.URL.Query().Get("port"))
+	stateFilter := JobState(r.URL.Query().Get("state"))
+
+	jobs := make([]Job, 0)
+	for _, job := range jobManager.List() {
+		if portFilter != 0 && job.Port != portFilter {
+			continue
+		}
+		if stateFilter != "" && job.State != stateFilter {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Jobs obtidos com sucesso",
+		Data: map[string]interface{}{
+			"total": len(jobs),
+			"jobs":  jobs,
+		},
+	})
+}
+
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	job, ok := jobManager.Get(id)
+	if !ok {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Job %s não encontrado", id),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Job obtido com sucesso",
+		Data:    job,
+	})
+}
+
+func jobCancelHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if !jobManager.Cancel(id) {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Job %s não encontrado ou já finalizado", id),
+		})
+		return
+	}
+
+	log.Printf("⏹️  Job %s cancelado", id)
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Cancelamento solicitado",
+		Data: map[string]string{
+			"job_id": id,
+		},
+	})
+}
+
+// ============================================================================
+// HANDLERS - PROXIES
+// ============================================================================
+
+func proxyConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	port, err := strconv.Atoi(mux.Vars(r)["port"])
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Porta inválida",
+		})
+		return
+	}
+
+	stats, ok := proxyServer.Stats(port)
+	if !ok {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Nenhum proxy ativo na porta %d", port),
+		})
+		return
+	}
+
+	conns, _ := proxyServer.Connections(port)
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"proxy":       stats,
+			"connections": conns,
+		},
+	})
+}
+
+// proxyTrafficHandler aceita a porta HTTP ou a SOCKS5, ?period=hour|day
+// (padrão hour) e ?since= (padrão 24h por hora e 30 dias por dia).
+func proxyTrafficHandler(w http.ResponseWriter, r *http.Request) {
+	port, err := strconv.Atoi(mux.Vars(r)["port"])
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Porta inválida",
+		})
+		return
+	}
+
+	if link, ok := linkRegistry.ByPort(port); ok {
+		port = link.HTTPPort
+	}
+
+	period := r.URL.Query().Get("period")
+	fallback := 24 * time.Hour
+	switch period {
+	case "", TrafficPeriodHour:
+		period = TrafficPeriodHour
+	case TrafficPeriodDay:
+		fallback = 30 * 24 * time.Hour
+	default:
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos: period deve ser hour ou day",
+		})
+		return
+	}
+
+	since, err := parseHistorySince(r.URL.Query().Get("since"), fallback)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Tráfego do proxy obtido com sucesso",
+		Data:    trafficStats.Report(port, period, since),
+	})
+}
+
+// proxyIPHistoryHandler aceita ?job=<id> para ver os IPs de uma renovação
+// e ?limit=N.
+func proxyIPHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	port, err := strconv.Atoi(mux.Vars(r)["port"])
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Porta inválida",
+		})
+		return
+	}
+
+	if link, ok := linkRegistry.ByPort(port); ok {
+		port = link.HTTPPort
+	}
+
+	jobFilter := r.URL.Query().Get("job")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	entries := make([]IPHistoryEntry, 0)
+	for _, entry := range ipHistory.List(port) {
+		if jobFilter != "" && entry.JobID != jobFilter {
+			continue
+		}
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+		entries = append(entries, entry)
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Histórico de IPs obtido com sucesso",
+		Data: map[string]interface{}{
+			"port":    port,
+			"history": entries,
+			"count":   len(entries),
+		},
+	})
+}
+
+func gatewayHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Gateway obtido com sucesso",
+		Data:    gateway.Status(),
+	})
+}
+
+func gatewayUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Strategy string `json:"strategy"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Dados inválidos",
+		})
+		return
+	}
+
+	if err := gateway.SetStrategy(req.Strategy); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Estratégia do gateway atualizada",
+		Data:    gateway.Status(),
+	})
+}
+
+func sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessions := sessionManager.List()
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Sessões obtidas com sucesso",
+		Data: map[string]interface{}{
+			"sessions": sessions,
+			"count":    len(sessions),
+		},
+	})
+}
+
+func sessionDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	if !sessionManager.Delete(r.URL.Query().Get("user"), key) {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Sessão %s não encontrada", key),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Sessão removida",
+	})
+}
+
+// ============================================================================
+// HANDLERS - SUPERVISOR
+// ============================================================================
+
+func supervisorHandler(w http.ResponseWriter, r *http.Request) {
+	modems, events := supervisor.Status()
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Supervisor obtido com sucesso",
+		Data: map[string]interface{}{
+			"modems": modems,
+			"events": events,
+		},
+	})
+}
+
+// ============================================================================
+// HANDLERS - AGENDAMENTOS
+// ============================================================================
+
+func schedulesHandler(w http.ResponseWriter, r *http.Request) {
+	policies := scheduler.List()
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Agendamentos obtidos com sucesso",
+		Data: map[string]interface{}{
+			"schedules": policies,
+			"count":     len(policies),
+		},
+	})
+}
+
+func scheduleCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var input ScheduleInput
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Dados inválidos: " + err.Error(),
+		})
+		return
+	}
+
+	policy, err := scheduler.Create(input)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao criar agendamento: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Agendamento criado com sucesso",
+		Data:    policy,
+	})
+}
+
+func scheduleUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	var input ScheduleInput
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Dados inválidos: " + err.Error(),
+		})
+		return
+	}
+
+	policy, err := scheduler.Update(mux.Vars(r)["id"], input)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao atualizar agendamento: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Agendamento atualizado com sucesso",
+		Data:    policy,
+	})
+}
+
+func scheduleDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if err := scheduler.Delete(mux.Vars(r)["id"]); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao remover agendamento: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Agendamento removido com sucesso",
+	})
+}
+
+// ============================================================================
+// HANDLERS - MODEMS
+// ============================================================================
+
+// modemHandler devolve a ficha do modem: hardware, rede, sinal estendido,
+// célula servidora, SIM e bearer em uso.
+func modemHandler(w http.ResponseWriter, r *http.Request) {
+	modemID := mux.Vars(r)["id"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+	defer cancel()
+
+	report, err := ReadModemReport(ctx, modemBackend, modemID)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao ler modem: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Modem obtido com sucesso",
+		Data:    report,
+	})
+}
+
+// modemHistoryHandler aceita o índice atual do modem ou a identidade
+// (IMEI/ICCID), para consultar modems que já foram removidos.
+func modemHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	modemID := mux.Vars(r)["id"]
+
+	since, err := parseHistorySince(r.URL.Query().Get("since"), 24*time.Hour)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Parâmetros inválidos: " + err.Error(),
+		})
+		return
+	}
+
+	var step time.Duration
+	if value := r.URL.Query().Get("step"); value != "" {
+		if step, err = parseHistoryDuration(value); err != nil {
+			respondJSON(w, APIResponse{
+				Success: false,
+				Message: "Parâmetros inválidos: step " + err.Error(),
+			})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	keys := []string{modemID, signalHistoryKey("", modemID)}
+	if identity, _, err := ModemIdentity(ctx, modemBackend, modemID); err == nil {
+		keys = append([]string{identity}, keys...)
+	}
+
+	for _, key := range keys {
+		if result, ok := signalHistory.Query(key, since, step); ok {
+			respondJSON(w, APIResponse{
+				Success: true,
+				Message: "Histórico de sinal obtido com sucesso",
+				Data:    result,
+			})
+			return
+		}
+	}
+
+	respondJSON(w, APIResponse{
+		Success: false,
+		Message: "Sem histórico de sinal para o modem " + modemID,
+	})
+}
+
+// ============================================================================
+// HANDLERS - APN
+// ============================================================================
+
+func apnProfilesHandler(w http.ResponseWriter, r *http.Request) {
+	profiles := apnStore.Profiles()
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Perfis de APN obtidos com sucesso",
+		Data: map[string]interface{}{
+			"profiles": profiles,
+			"count":    len(profiles),
+		},
+	})
+}
+
+func apnProfileCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var input APNProfileInput
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Dados inválidos: " + err.Error(),
+		})
+		return
+	}
+
+	profile, err := apnStore.CreateProfile(input)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao criar perfil de APN: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Perfil de APN criado com sucesso",
+		Data:    profile,
+	})
+}
+
+func apnProfileUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	var input APNProfileInput
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Dados inválidos: " + err.Error(),
+		})
+		return
+	}
+
+	profile, err := apnStore.UpdateProfile(mux.Vars(r)["id"], input)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao atualizar perfil de APN: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Perfil de APN atualizado com sucesso",
+		Data:    profile,
+	})
+}
+
+func apnProfileDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if err := apnStore.DeleteProfile(mux.Vars(r)["id"]); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao remover perfil de APN: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Perfil de APN removido com sucesso",
+	})
+}
+
+// modemAPNHandler mostra o SIM do modem e o APN que o próximo connect vai
+// usar.
+func modemAPNHandler(w http.ResponseWriter, r *http.Request) {
+	modemID := mux.Vars(r)["id"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	sim, err := ReadSIM(ctx, modemBackend, modemID)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao ler SIM do modem: " + err.Error(),
+		})
+		return
+	}
+
+	operatorCode := ""
+	if sim != nil {
+		operatorCode = sim.OperatorCode
+	}
+	identity, _, _ := ModemIdentity(ctx, modemBackend, modemID)
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "APN do modem obtido com sucesso",
+		Data: map[string]interface{}{
+			"modem_id": modemID,
+			"identity": identity,
+			"sim":      sim,
+			"override": apnStore.Override(identity),
+			"resolved": apnStore.Resolve(identity, operatorCode),
+		},
+	})
+}
+
+func modemAPNUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	var override APNOverride
+
+	if err := json.NewDecoder(r.Body).Decode(&override); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Dados inválidos: " + err.Error(),
+		})
+		return
+	}
+	override.ModemID = mux.Vars(r)["id"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	identity, _, err := ModemIdentity(ctx, modemBackend, override.ModemID)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao fixar APN: " + err.Error(),
+		})
+		return
+	}
+	override.Identity = identity
+
+	saved, err := apnStore.SetOverride(override)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao fixar APN: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "APN do modem fixado. Vale na próxima conexão (renovação ou religação)",
+		Data:    saved,
+	})
+}
+
+// modemAPNDeleteHandler aceita o índice atual do modem ou a identidade, para
+// remover o APN fixado de um modem desconectado.
+func modemAPNDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	ref := mux.Vars(r)["id"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	identity, err := ResolveModemRef(ctx, modemBackend, ref)
+	if err != nil {
+		identity = ref
+	}
+
+	if err := apnStore.DeleteOverride(identity); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao remover APN fixado: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "APN fixado removido. O modem volta a usar o perfil da operadora",
+	})
+}
+
+// ============================================================================
+// HANDLERS - MAPA DE PORTAS
+// ============================================================================
+
+func portMapHandler(w http.ResponseWriter, r *http.Request) {
+	entries := portMapEntries()
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Mapa de portas obtido com sucesso",
+		Data: map[string]interface{}{
+			"assignments": entries,
+			"count":       len(entries),
+		},
+	})
+}
+
+// portMapUpdateHandler fixa as portas de um IMEI/ICCID. Se o modem está
+// conectado, o proxy dele passa para as novas portas no mesmo instante.
+func portMapUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	identity := mux.Vars(r)["identity"]
+
+	var input PortAssignmentInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Dados inválidos: " + err.Error(),
+		})
+		return
+	}
+
+	preview := PortAssignment{Identity: identity}
+	if current, ok := portMap.Get(identity); ok {
+		if jobManager.Renewing(current.HTTPPort) {
+			respondJSON(w, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Erro ao atualizar mapa de portas: porta %d em renovação", current.HTTPPort),
+			})
+			return
+		}
+		preview = current
+	}
+	input.apply(&preview)
+
+	// Modems sem IMEI/ICCID legível não estão no mapa, mas ocupam portas
+	for _, port := range []int{preview.HTTPPort, preview.SOCKSPort} {
+		if link, ok := linkRegistry.ByPort(port); ok && link.Identity != identity {
+			respondJSON(w, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Erro ao atualizar mapa de portas: porta %d em uso pelo modem %s", port, link.ModemID),
+			})
+			return
+		}
+	}
+
+	assignment, err := portMap.Set(identity, input)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao atualizar mapa de portas: " + err.Error(),
+		})
+		return
+	}
+
+	moved, err := linkRegistry.Remap(identity, assignment.HTTPPort, assignment.SOCKSPort)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao mover proxy do modem: " + err.Error(),
+		})
+		return
+	}
+
+	message := "Portas fixadas. Valem quando o modem for detectado"
+	if moved {
+		go syncProxies()
+		message = "Portas atualizadas. O proxy do modem já atende nas novas portas"
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: message,
+		Data:    assignment,
+	})
+}
+
+func portMapDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if err := portMap.Delete(mux.Vars(r)["identity"]); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao remover do mapa de portas: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Portas liberadas. O modem recebe o primeiro par livre na próxima detecção",
+	})
+}
+
+// ============================================================================
+// HANDLERS - CONFIGURAÇÃO
+// ============================================================================
+
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Configuração obtida com sucesso",
+		Data: map[string]interface{}{
+			"file":   configStore.Path(),
+			"config": currentConfig(),
+		},
+	})
+}
+
+// configReloadHandler faz o mesmo que o SIGHUP e informa o que foi aplicado
+// e o que só vale depois do restart.
+func configReloadHandler(w http.ResponseWriter, r *http.Request) {
+	reload, err := configStore.Reload()
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao recarregar configuração: " + err.Error(),
+		})
+		return
+	}
+
+	message := "Configuração recarregada"
+	if len(reload.RestartRequired) > 0 {
+		message = "Configuração recarregada; algumas mudanças só valem depois do restart"
+	}
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: message,
+		Data:    reload,
+	})
+}
+
+// ============================================================================
+// HANDLERS - WEBHOOKS
+// ============================================================================
+
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptions := webhooks.List()
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Webhooks obtidos com sucesso",
+		Data: map[string]interface{}{
+			"webhooks": subscriptions,
+			"count":    len(subscriptions),
+		},
+	})
+}
+
+func webhookCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var input WebhookInput
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Dados inválidos: " + err.Error(),
+		})
+		return
+	}
+
+	subscription, secret, err := webhooks.Create(input)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao criar webhook: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Webhook criado. Guarde o secret: ele não será mostrado novamente",
+		Data: map[string]interface{}{
+			"webhook": subscription,
+			"secret":  secret,
+		},
+	})
+}
 
-	// Rotas SMS
-	router.HandleFunc("/sms/inbox", smsInboxHandler).Methods("GET")
-	router.HandleFunc("/sms/inbox/{modem_id}", smsInboxByModemHandler).Methods("GET")
-	router.HandleFunc("/sms/send", smsSendHandler).Methods("POST")
-	router.HandleFunc("/sms/history", smsHistoryHandler).Methods("GET")
-	router.HandleFunc("/sms/delete", smsDeleteHandler).Methods("POST")
+func webhookUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	var input WebhookInput
 
-	router.Use(corsMiddleware)
-	router.PathPrefix("/").Handler(http.FileServer(http.Dir(".")))
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Dados inválidos: " + err.Error(),
+		})
+		return
+	}
 
-	// Iniciar polling de SMS em background
-	go startSMSPolling()
+	subscription, err := webhooks.Update(mux.Vars(r)["id"], input)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao atualizar webhook: " + err.Error(),
+		})
+		return
+	}
 
-	log.Println("========================================")
-	log.Println("🚀 API Proxy Manager v2.0 + SMS")
-	log.Println("========================================")
-	log.Println("📡 Servidor: http://0.0.0.0:5000")
-	log.Println("📱 SMS Polling: Ativo (10s)")
-	log.Println("========================================")
-	log.Fatal(http.ListenAndServe("0.0.0.0:5000", router))
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Webhook atualizado com sucesso",
+		Data:    subscription,
+	})
+}
+
+func webhookDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if err := webhooks.Delete(mux.Vars(r)["id"]); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao remover webhook: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Webhook removido com sucesso",
+	})
+}
+
+func webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := webhooks.Deliveries(mux.Vars(r)["id"], r.URL.Query().Get("state"))
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao obter entregas: " + err.Error(),
+		})
+		return
+	}
+
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 && limit < len(deliveries) {
+		deliveries = deliveries[:limit]
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Entregas obtidas com sucesso",
+		Data: map[string]interface{}{
+			"deliveries": deliveries,
+			"count":      len(deliveries),
+		},
+	})
+}
+
+// webhookReplayHandler reenvia uma entrega que falhou ou, sem {delivery},
+// todas as falhas ainda não reenviadas da assinatura.
+func webhookReplayHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	replays, err := webhooks.Replay(vars["id"], vars["delivery"])
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao reenviar entregas: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d entrega(s) reenfileirada(s)", len(replays)),
+		Data: map[string]interface{}{
+			"deliveries": replays,
+			"count":      len(replays),
+		},
+	})
 }
 
 // ============================================================================
-// HANDLERS - SISTEMA
+// HANDLERS - CHAVES DA API
 // ============================================================================
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
+func authMeHandler(w http.ResponseWriter, r *http.Request) {
+	key, _ := apiKeyStore.Lookup(requestToken(r))
+
 	respondJSON(w, APIResponse{
 		Success: true,
-		Message: "API Proxy Manager está online",
-		Data: map[string]string{
-			"version":    "2.0.1",
-			"status":     "healthy",
-			"max_modems": strconv.Itoa(MAX_MODEMS),
-			"sms":        "enabled",
+		Message: "Chave válida",
+		Data:    key,
+	})
+}
+
+func apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Chaves obtidas com sucesso",
+		Data:    apiKeyStore.List(),
+	})
+}
+
+func apiKeyCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+		Role Role   `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Dados inválidos",
+		})
+		return
+	}
+
+	key, token, err := apiKeyStore.Create(req.Name, req.Role)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao criar chave: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Chave criada. Guarde o token: ele não será mostrado novamente",
+		Data: map[string]interface{}{
+			"key":   key,
+			"token": token,
 		},
 	})
 }
 
-func statusHandler(w http.ResponseWriter, r *http.Request) {
-	status := getSystemStatus()
+func apiKeyDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if err := apiKeyStore.Delete(mux.Vars(r)["id"]); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao remover chave: " + err.Error(),
+		})
+		return
+	}
 
 	respondJSON(w, APIResponse{
 		Success: true,
-		Message: "Status obtido com sucesso",
-		Data:    status,
+		Message: "Chave removida com sucesso",
 	})
 }
 
-func restartHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("🔄 Recebida solicitação de restart do sistema")
+// ============================================================================
+// HANDLERS - USUÁRIOS DOS PROXIES
+// ============================================================================
 
-	go func() {
-		time.Sleep(2 * time.Second)
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	users := userStore.List()
 
-		cmd := exec.Command("sudo", PROXY_MANAGER_PATH, "restart")
-		output, err := cmd.CombinedOutput()
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Usuários obtidos com sucesso",
+		Data: map[string]interface{}{
+			"users":        users,
+			"count":        len(users),
+			"auth_enabled": len(users) > 0,
+		},
+	})
+}
 
+// resolveUserModems troca os índices de modem enviados em "modems" pela
+// identidade (IMEI/ICCID) do modem, que é o que o usuário guarda.
+func resolveUserModems(ctx context.Context, input *UserInput) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	for i, ref := range input.Modems {
+		identity, err := ResolveModemRef(ctx, modemBackend, ref)
 		if err != nil {
-			log.Printf("❌ Erro ao reiniciar: %v - %s", err, string(output))
-		} else {
-			log.Println("✅ Sistema reiniciado com sucesso")
+			return err
 		}
+		input.Modems[i] = identity
+	}
+	return nil
+}
 
-		invalidateCache()
-	}()
+func userCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var input UserInput
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Dados inválidos",
+		})
+		return
+	}
+
+	if err := resolveUserModems(r.Context(), &input); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao criar usuário: " + err.Error(),
+		})
+		return
+	}
+
+	user, err := userStore.Create(input)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao criar usuário: " + err.Error(),
+		})
+		return
+	}
 
 	respondJSON(w, APIResponse{
 		Success: true,
-		Message: "Comando de restart enviado. Sistema será reiniciado em alguns segundos.",
+		Message: "Usuário criado com sucesso",
+		Data:    user,
 	})
 }
 
-func renewHandler(w http.ResponseWriter, r *http.Request) {
-	var req RenewRequest
+func userUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	var input UserInput
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		respondJSON(w, APIResponse{
 			Success: false,
-			Message: "Dados inválidos: " + err.Error(),
+			Message: "Dados inválidos",
 		})
 		return
 	}
 
-	if req.Port < BASE_PROXY_PORT+1 || req.Port > BASE_PROXY_PORT+MAX_MODEMS {
+	if err := resolveUserModems(r.Context(), &input); err != nil {
 		respondJSON(w, APIResponse{
 			Success: false,
-			Message: fmt.Sprintf("Porta inválida. Deve estar entre %d e %d", BASE_PROXY_PORT+1, BASE_PROXY_PORT+MAX_MODEMS),
+			Message: "Erro ao atualizar usuário: " + err.Error(),
 		})
 		return
 	}
 
-	log.Printf("🔄 Recebida solicitação de renovação de IP para porta %d", req.Port)
-
-	go func() {
-		cmd := exec.Command("sudo", PROXY_MANAGER_PATH, "renew-port", strconv.Itoa(req.Port))
-		output, err := cmd.CombinedOutput()
+	user, err := userStore.Update(mux.Vars(r)["username"], input)
+	if err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao atualizar usuário: " + err.Error(),
+		})
+		return
+	}
 
-		if err != nil {
-			log.Printf("❌ Erro ao renovar porta %d: %v - %s", req.Port, err, string(output))
-		} else {
-			log.Printf("✅ IP da porta %d renovado com sucesso", req.Port)
-		}
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Usuário atualizado com sucesso",
+		Data:    user,
+	})
+}
 
-		invalidateCache()
-	}()
+func userDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if err := userStore.Delete(mux.Vars(r)["username"]); err != nil {
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Erro ao remover usuário: " + err.Error(),
+		})
+		return
+	}
 
 	respondJSON(w, APIResponse{
 		Success: true,
-		Message: "Renovação de IP iniciada. Aguarde ~45 segundos para conclusão.",
-		Data: map[string]int{
-			"port": req.Port,
-		},
+		Message: "Usuário removido com sucesso",
 	})
 }
 
@@ -257,16 +1623,28 @@ func renewHandler(w http.ResponseWriter, r *http.Request) {
 func startSMSPolling() {
 	log.Println("📱 SMS Polling iniciado...")
 
-	ticker := time.NewTicker(SMS_CHECK_INTERVAL)
-	defer ticker.Stop()
-
 	checkAllModemsForSMS()
 
-	for range ticker.C {
+	// O intervalo é relido a cada volta: a recarga da configuração vale na
+	// próxima verificação
+	for {
+		time.Sleep(time.Duration(currentConfig().SMS.CheckInterval))
 		checkAllModemsForSMS()
 	}
 }
 
+// SetMaxHistory muda o limite do histórico, descartando os SMS mais antigos
+// que passarem dele.
+func (sm *SMSManager) SetMaxHistory(max int) {
+	sm.historyMutex.Lock()
+	defer sm.historyMutex.Unlock()
+
+	sm.maxHistory = max
+	if len(sm.smsHistory) > max {
+		sm.smsHistory = sm.smsHistory[len(sm.smsHistory)-max:]
+	}
+}
+
 func checkAllModemsForSMS() {
 	modems := getActiveModems()
 
@@ -282,6 +1660,8 @@ func checkAllModemsForSMS() {
 
 			if !alreadyProcessed {
 				log.Printf("📩 Novo SMS | Modem: %s | De: %s | Texto: %s", modem.ID, sms.Number, sms.Text)
+				metrics.SMSReceived(modem.ID)
+				eventBus.Publish(EventSMSReceived, sms)
 
 				smsManager.cacheMutex.Lock()
 				smsManager.smsCache[cacheKey] = true
@@ -307,97 +1687,58 @@ func getActiveModems() []Modem {
 
 func getSMSFromModem(modemID string) []SMS {
 	smsList := make([]SMS, 0)
+	ctx := context.Background()
 
-	cmd := exec.Command("mmcli", "-m", modemID, "--messaging-list-sms")
-	output, err := cmd.CombinedOutput()
+	smsIDs, err := modemBackend.ListSMS(ctx, modemID)
 	if err != nil {
 		return smsList
 	}
 
-	re := regexp.MustCompile(`SMS/(\d+)`)
-	matches := re.FindAllStringSubmatch(string(output), -1)
-
-	for _, match := range matches {
-		if len(match) > 1 {
-			smsID := match[1]
-			sms := getSMSDetails(modemID, smsID)
-			if sms != nil {
-				smsList = append(smsList, *sms)
-			}
+	for _, smsID := range smsIDs {
+		sms, err := modemBackend.GetSMS(ctx, modemID, smsID)
+		if err == nil {
+			smsList = append(smsList, *sms)
 		}
 	}
 
 	return smsList
 }
 
-func getSMSDetails(modemID, smsID string) *SMS {
-	cmd := exec.Command("mmcli", "-m", modemID, "--sms", smsID)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil
-	}
-
-	smsData := string(output)
+func sendSMS(modemID, number, text string) (err error) {
+	defer func() { metrics.SMSSent(modemID, err) }()
 
-	number := extractValue(smsData, `number:\s*(.+)`)
-	text := extractValue(smsData, `text:\s*(.+)`)
-	timestamp := extractValue(smsData, `timestamp:\s*(.+)`)
-	state := extractValue(smsData, `state:\s*(.+)`)
-
-	return &SMS{
-		ID:        smsID,
-		ModemID:   modemID,
-		Number:    strings.TrimSpace(number),
-		Text:      strings.TrimSpace(text),
-		Timestamp: strings.TrimSpace(timestamp),
-		State:     strings.TrimSpace(state),
-		Received:  time.Now(),
-	}
-}
-
-func sendSMS(modemID, number, text string) error {
 	if !strings.HasPrefix(number, "+") {
 		number = "+" + number
 	}
 
-	createCmd := fmt.Sprintf("text='%s',number='%s'", text, number)
-	cmd := exec.Command("mmcli", "-m", modemID, "--messaging-create-sms="+createCmd)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("erro ao criar SMS: %v - %s", err, string(output))
-	}
+	ctx := context.Background()
 
-	re := regexp.MustCompile(`SMS/(\d+)`)
-	match := re.FindStringSubmatch(string(output))
-	if len(match) < 2 {
-		return fmt.Errorf("não foi possível extrair ID do SMS")
+	smsID, err := modemBackend.CreateSMS(ctx, modemID, number, text)
+	if err != nil {
+		return err
 	}
 
-	smsID := match[1]
-
-	sendCmd := exec.Command("mmcli", "-m", modemID, "--sms", smsID, "--send")
-	output, err = sendCmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("erro ao enviar SMS: %v - %s", err, string(output))
+	if err := modemBackend.SendSMS(ctx, modemID, smsID); err != nil {
+		return fmt.Errorf("erro ao enviar SMS: %v", err)
 	}
 
 	time.Sleep(2 * time.Second)
 
-	deleteCmd := exec.Command("mmcli", "-m", modemID, "--sms", smsID, "--delete")
-	deleteCmd.Run()
+	modemBackend.DeleteSMS(ctx, modemID, smsID)
 
 	log.Printf("✅ SMS enviado | Modem: %s | Para: %s | Texto: %s", modemID, number, text)
+	eventBus.Publish(EventSMSSent, map[string]interface{}{
+		"modem_id": modemID,
+		"number":   number,
+		"text":     text,
+	})
 
 	return nil
 }
 
 func deleteSMS(modemID, smsID string) error {
-	// Usar sudo para apagar SMS
-	deleteArg := fmt.Sprintf("--messaging-delete-sms=%s", smsID)
-	cmd := exec.Command("sudo", "mmcli", "-m", modemID, deleteArg)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("erro ao apagar SMS: %v - %s", err, string(output))
+	if err := modemBackend.DeleteSMS(context.Background(), modemID, smsID); err != nil {
+		return fmt.Errorf("erro ao apagar SMS: %v", err)
 	}
 
 	log.Printf("🗑️  SMS apagado | Modem: %s | SMS: %s", modemID, smsID)
@@ -415,12 +1756,12 @@ func processCommandSMS(modemID string, sms SMS) {
 			log.Printf("🔄 Comando SMS: RENEW porta %s", port)
 
 			go func() {
-				cmd := exec.Command("sudo", PROXY_MANAGER_PATH, "renew-port", port)
-				output, err := cmd.CombinedOutput()
+				portNum, _ := strconv.Atoi(port)
+				job, _ := jobManager.Wait(startRenewJob(portNum, "sms", RenewOptions{}).ID)
 
 				var resposta string
-				if err == nil && strings.Contains(string(output), "RENOVADO COM SUCESSO") {
-					resposta = fmt.Sprintf("✅ IP da porta %s renovado!", port)
+				if job.State == JobSucceeded {
+					resposta = fmt.Sprintf("✅ IP da porta %s renovado! Novo IP: %s", port, job.NewPublicIP)
 				} else {
 					resposta = fmt.Sprintf("❌ Falha ao renovar porta %s", port)
 				}
@@ -571,7 +1912,7 @@ func smsDeleteHandler(w http.ResponseWriter, r *http.Request) {
 
 func getSystemStatus() *Status {
 	statusCacheMutex.RLock()
-	if time.Since(statusCacheTime) < cacheTTL && statusCache != nil {
+	if time.Since(statusCacheTime) < time.Duration(currentConfig().StatusCacheTTL) && statusCache != nil {
 		defer statusCacheMutex.RUnlock()
 		return statusCache
 	}
@@ -580,6 +1921,9 @@ func getSystemStatus() *Status {
 	statusCacheMutex.Lock()
 	defer statusCacheMutex.Unlock()
 
+	start := time.Now()
+	defer func() { metrics.ObserveStatusRefresh(time.Since(start)) }()
+
 	status := &Status{
 		Modems:  make([]Modem, 0),
 		Proxies: make([]Proxy, 0),
@@ -607,22 +1951,15 @@ func getSystemStatus() *Status {
 func getModems() []Modem {
 	modems := make([]Modem, 0)
 
-	cmd := exec.Command("mmcli", "-L")
-	output, err := cmd.CombinedOutput()
+	modemIDs, err := modemBackend.ListModems(context.Background())
 	if err != nil {
 		return modems
 	}
 
-	re := regexp.MustCompile(`Modem/(\d+)`)
-	matches := re.FindAllStringSubmatch(string(output), -1)
-
-	for _, match := range matches {
-		if len(match) > 1 {
-			modemID := match[1]
-			modem := getModemDetails(modemID)
-			if modem != nil {
-				modems = append(modems, *modem)
-			}
+	for _, modemID := range modemIDs {
+		modem := getModemDetails(modemID)
+		if modem != nil {
+			modems = append(modems, *modem)
 		}
 	}
 
@@ -630,91 +1967,99 @@ func getModems() []Modem {
 }
 
 func getModemDetails(modemID string) *Modem {
-	cmd := exec.Command("mmcli", "-m", modemID)
-	output, err := cmd.CombinedOutput()
+	ctx := context.Background()
+
+	info, err := modemBackend.GetModem(ctx, modemID)
 	if err != nil {
 		return nil
 	}
 
-	modemData := string(output)
-
-	state := extractValue(modemData, `state:\s*(.+)`)
-	signal := extractValue(modemData, `signal quality:\s*(\d+)`)
-
-	bearerPath := extractValue(modemData, `Bearer.*(/org/freedesktop/ModemManager1/Bearer/\d+)`)
 	var iface, ip string
 
-	if bearerPath != "" {
-		bearerID := strings.Split(bearerPath, "/")
-		if len(bearerID) > 0 {
-			bid := bearerID[len(bearerID)-1]
-			cmd := exec.Command("mmcli", "-b", bid)
-			bearerOutput, err := cmd.CombinedOutput()
-			if err == nil {
-				bearerData := string(bearerOutput)
-				iface = extractValue(bearerData, `interface:\s*(.+)`)
-				ip = extractValue(bearerData, `address:\s*(.+)`)
-			}
+	if bearerID := info.CurrentBearer(); bearerID != "" {
+		bearer, err := modemBackend.GetBearer(ctx, bearerID)
+		if err == nil {
+			iface = bearer.Interface
+			ip = bearer.Address
 		}
 	}
 
-	if signal != "" {
-		signal = signal + "%"
+	signal := ""
+	if info.SignalQuality >= 0 {
+		signal = strconv.Itoa(info.SignalQuality) + "%"
 	}
 
 	return &Modem{
 		ID:         modemID,
-		Interface:  strings.TrimSpace(iface),
-		InternalIP: strings.TrimSpace(ip),
-		State:      strings.TrimSpace(state),
+		Interface:  iface,
+		InternalIP: ip,
+		State:      info.State,
 		Signal:     signal,
+		AccessTech: info.AccessTech,
 	}
 }
 
 func getProxies(modems []Modem) []Proxy {
 	proxies := make([]Proxy, 0)
 
+	// As portas vêm do registro de links, que segue o mapa de portas por
+	// IMEI/ICCID; modems fora do registro ainda não têm proxy.
+	linkRegistry.Refresh(context.Background())
+	links := make(map[string]ModemLink)
+	for _, modem := range modems {
+		if link, ok := linkRegistry.ByModem(modem.ID); ok {
+			links[modem.ID] = link
+		}
+	}
+
 	proxyIPCache := make(map[int]string)
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 
-	for i := 1; i <= len(modems); i++ {
-		port := BASE_PROXY_PORT + i
+	for _, link := range links {
 		wg.Add(1)
 
-		go func(p int) {
+		go func(p int, modemID string) {
 			defer wg.Done()
-			publicIP := getPublicIP(p)
+			publicIP := resolvePublicIP(context.Background(), modemID, p)
+			ipHistory.ObserveStatus(modemID, p, publicIP)
 			mu.Lock()
 			proxyIPCache[p] = publicIP
 			mu.Unlock()
-		}(port)
+		}(link.HTTPPort, link.ModemID)
 	}
 
 	wg.Wait()
 
-	for i, modem := range modems {
-		httpPort := BASE_PROXY_PORT + i + 1
-		socksPort := BASE_SOCKS_PORT + i + 1
+	for _, modem := range modems {
+		link, ok := links[modem.ID]
+		if !ok {
+			continue
+		}
+		httpPort := link.HTTPPort
+		socksPort := link.SOCKSPort
 
 		publicIP := proxyIPCache[httpPort]
+		connections := countProxyConnections(httpPort)
 
 		proxies = append(proxies, Proxy{
-			Port:      httpPort,
-			PublicIP:  publicIP,
-			Protocol:  "HTTP",
-			Modem:     fmt.Sprintf("Modem %s", modem.ID),
-			Running:   isProxyRunning(httpPort),
-			Interface: modem.Interface,
+			Port:        httpPort,
+			PublicIP:    publicIP,
+			Protocol:    "HTTP",
+			Modem:       fmt.Sprintf("Modem %s", modem.ID),
+			Running:     isProxyRunning(httpPort),
+			Connections: connections["HTTP"] + connections["CONNECT"],
+			Interface:   modem.Interface,
 		})
 
 		proxies = append(proxies, Proxy{
-			Port:      socksPort,
-			PublicIP:  publicIP,
-			Protocol:  "SOCKS5",
-			Modem:     fmt.Sprintf("Modem %s", modem.ID),
-			Running:   isProxyRunning(socksPort),
-			Interface: modem.Interface,
+			Port:        socksPort,
+			PublicIP:    publicIP,
+			Protocol:    "SOCKS5",
+			Modem:       fmt.Sprintf("Modem %s", modem.ID),
+			Running:     isProxyRunning(socksPort),
+			Connections: connections["SOCKS5"],
+			Interface:   modem.Interface,
 		})
 	}
 
@@ -722,30 +2067,17 @@ func getProxies(modems []Modem) []Proxy {
 }
 
 func isProxyRunning(port int) bool {
-	pidFile := fmt.Sprintf("/var/run/3proxy_%d.pid", port)
-
-	data, err := os.ReadFile(pidFile)
-	if err != nil {
-		return false
-	}
-
-	pid := strings.TrimSpace(string(data))
-	procPath := fmt.Sprintf("/proc/%s/cmdline", pid)
-
-	_, err = os.Stat(procPath)
-	return err == nil
+	return proxyServer.Running(port)
 }
 
-func getPublicIP(port int) string {
-	proxyURL := fmt.Sprintf("http://127.0.0.1:%d", port)
-
-	cmd := exec.Command("curl", "-s", "-x", proxyURL, "--max-time", "5", "https://api.ipify.org")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "N/A"
+// countProxyConnections conta as conexões ativas do modem por protocolo.
+func countProxyConnections(port int) map[string]int {
+	counts := make(map[string]int)
+	conns, _ := proxyServer.Connections(port)
+	for _, conn := range conns {
+		counts[conn.Protocol]++
 	}
-
-	return strings.TrimSpace(string(output))
+	return counts
 }
 
 func countRunningProxies(proxies []Proxy) int {
@@ -760,6 +2092,7 @@ func countRunningProxies(proxies []Proxy) int {
 
 func getUptime() string {
 	cmd := exec.Command("uptime", "-p")
+	metrics.Subprocess("uptime")
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return "N/A"
@@ -780,6 +2113,13 @@ func extractValue(data, pattern string) string {
 	return ""
 }
 
+func getEnv(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return fallback
+}
+
 func invalidateCache() {
 	statusCacheMutex.Lock()
 	statusCache = nil
@@ -791,11 +2131,25 @@ func respondJSON(w http.ResponseWriter, response APIResponse) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// respondJSONStatus é usado apenas onde o status HTTP importa ao cliente
+// (autenticação); os handlers respondem 200 com success=false.
+func respondJSONStatus(w http.ResponseWriter, status int, response APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// corsMiddleware libera apenas as origens de CORS_ORIGINS ("*" libera todas).
+// A lista é lida a cada requisição para valer logo após um reload.
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		origins := allowedOrigins()
+		if origin := r.Header.Get("Origin"); origin != "" && (origins["*"] || origins[origin]) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
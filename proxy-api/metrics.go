@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// MÉTRICAS (PROMETHEUS)
+// ============================================================================
+
+var (
+	renewDurationBuckets  = []float64{5, 10, 20, 30, 45, 60, 90, 120, 180, 300}
+	statusRefreshBuckets  = []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 30}
+	probeLatencyQuantiles = []string{"0.5", "0.9", "0.99"}
+)
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+type renewKey struct {
+	port   int
+	result string
+}
+
+type smsKey struct {
+	modemID string
+	result  string
+}
+
+// Metrics acumula os contadores desde o início do processo. Os valores de
+// modems e proxies não ficam aqui: são lidos do status na hora do scrape.
+type Metrics struct {
+	mu            sync.Mutex
+	renewals      map[renewKey]*histogram
+	smsReceived   map[string]uint64
+	smsSent       map[smsKey]uint64
+	statusRefresh *histogram
+	subprocesses  map[string]uint64
+}
+
+var metrics = &Metrics{
+	renewals:      make(map[renewKey]*histogram),
+	smsReceived:   make(map[string]uint64),
+	smsSent:       make(map[smsKey]uint64),
+	statusRefresh: newHistogram(statusRefreshBuckets),
+	subprocesses:  make(map[string]uint64),
+}
+
+// ObserveRenew registra uma renovação terminada. result é o estado final do
+// job (succeeded, failed, cancelled).
+func (m *Metrics) ObserveRenew(port int, result string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := renewKey{port: port, result: result}
+	h, ok := m.renewals[key]
+	if !ok {
+		h = newHistogram(renewDurationBuckets)
+		m.renewals[key] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+func (m *Metrics) SMSReceived(modemID string) {
+	m.mu.Lock()
+	m.smsReceived[modemID]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) SMSSent(modemID string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.mu.Lock()
+	m.smsSent[smsKey{modemID: modemID, result: result}]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) ObserveStatusRefresh(duration time.Duration) {
+	m.mu.Lock()
+	m.statusRefresh.observe(duration.Seconds())
+	m.mu.Unlock()
+}
+
+// Subprocess conta um processo externo disparado (mmcli, ip, ping...).
+func (m *Metrics) Subprocess(command string) {
+	m.mu.Lock()
+	m.subprocesses[command]++
+	m.mu.Unlock()
+}
+
+// ============================================================================
+// MÉTRICAS - FORMATO TEXTO
+// ============================================================================
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type metricsWriter struct {
+	b strings.Builder
+}
+
+func (w *metricsWriter) family(name, typ, help string) {
+	fmt.Fprintf(&w.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample escreve uma amostra; labels vem em pares nome, valor.
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.b.WriteString(name)
+	if len(labels) > 0 {
+		w.b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.b.WriteByte(',')
+			}
+			fmt.Fprintf(&w.b, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		w.b.WriteByte('}')
+	}
+	w.b.WriteByte(' ')
+	w.b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.b.WriteByte('\n')
+}
+
+func (w *metricsWriter) histogram(name string, h *histogram, labels ...string) {
+	for i, bound := range h.buckets {
+		le := strconv.FormatFloat(bound, 'g', -1, 64)
+		w.sample(name+"_bucket", float64(h.counts[i]), append(labels, "le", le)...)
+	}
+	w.sample(name+"_bucket", float64(h.count), append(labels, "le", "+Inf")...)
+	w.sample(name+"_sum", h.sum, labels...)
+	w.sample(name+"_count", float64(h.count), labels...)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Estados do ModemManager em ordem, para a métrica de estado ter sempre as
+// mesmas séries.
+func modemStateNames() []string {
+	values := make([]int, 0, len(mmModemStates))
+	for value := range mmModemStates {
+		values = append(values, int(value))
+	}
+	sort.Ints(values)
+
+	names := make([]string, 0, len(values))
+	for _, value := range values {
+		names = append(names, mmModemStates[int32(value)])
+	}
+	return names
+}
+
+func (m *Metrics) writeModems(w *metricsWriter, status *Status) {
+	w.family("proxyapi_modem_signal_quality_percent", "gauge", "Qualidade do sinal do modem (0-100).")
+	for _, modem := range status.Modems {
+		if signal, err := strconv.Atoi(strings.TrimSuffix(modem.Signal, "%")); err == nil {
+			w.sample("proxyapi_modem_signal_quality_percent", float64(signal), "modem", modem.ID)
+		}
+	}
+
+	w.family("proxyapi_modem_state", "gauge", "Estado do modem no ModemManager (1 no estado atual).")
+	states := modemStateNames()
+	for _, modem := range status.Modems {
+		for _, state := range states {
+			w.sample("proxyapi_modem_state", boolValue(modem.State == state), "modem", modem.ID, "state", state)
+		}
+	}
+
+	w.family("proxyapi_modem_access_technology", "gauge", "Tecnologia de acesso atual do modem (sempre 1).")
+	for _, modem := range status.Modems {
+		if modem.AccessTech != "" {
+			w.sample("proxyapi_modem_access_technology", 1, "modem", modem.ID, "tech", modem.AccessTech)
+		}
+	}
+}
+
+func (m *Metrics) writeProxies(w *metricsWriter, status *Status) {
+	w.family("proxyapi_proxy_up", "gauge", "1 se o proxy está escutando e a sondagem não o marcou como dead.")
+	for _, proxy := range status.Proxies {
+		health := healthChecker.Health(proxy.Port)
+		up := proxy.Running && (health == nil || health.State != HealthDead)
+		w.sample("proxyapi_proxy_up", boolValue(up), "port", strconv.Itoa(proxy.Port), "protocol", proxy.Protocol, "modem", strings.TrimPrefix(proxy.Modem, "Modem "))
+	}
+
+	w.family("proxyapi_proxy_connections", "gauge", "Conexões ativas no proxy.")
+	for _, proxy := range status.Proxies {
+		w.sample("proxyapi_proxy_connections", float64(proxy.Connections), "port", strconv.Itoa(proxy.Port), "protocol", proxy.Protocol, "modem", strings.TrimPrefix(proxy.Modem, "Modem "))
+	}
+
+	w.family("proxyapi_proxy_probe_latency_seconds", "gauge", "Percentis de latência das últimas sondagens do proxy.")
+	for _, proxy := range status.Proxies {
+		// Sem nenhuma sondagem bem-sucedida não há latência para medir
+		health := healthChecker.Health(proxy.Port)
+		if health == nil || health.Samples == 0 || health.SuccessRate == 0 {
+			continue
+		}
+		latencies := []int64{health.LatencyP50Ms, health.LatencyP90Ms, health.LatencyP99Ms}
+		for i, quantile := range probeLatencyQuantiles {
+			w.sample("proxyapi_proxy_probe_latency_seconds", float64(latencies[i])/1000,
+				"port", strconv.Itoa(proxy.Port), "protocol", proxy.Protocol, "modem", strings.TrimPrefix(proxy.Modem, "Modem "), "quantile", quantile)
+		}
+	}
+
+	w.family("proxyapi_proxy_probe_success_ratio", "gauge", "Taxa de sucesso das últimas sondagens do proxy.")
+	for _, proxy := range status.Proxies {
+		health := healthChecker.Health(proxy.Port)
+		if health == nil || health.Samples == 0 {
+			continue
+		}
+		w.sample("proxyapi_proxy_probe_success_ratio", health.SuccessRate, "port", strconv.Itoa(proxy.Port), "protocol", proxy.Protocol, "modem", strings.TrimPrefix(proxy.Modem, "Modem "))
+	}
+}
+
+func (m *Metrics) writeCounters(w *metricsWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	renewals := make([]renewKey, 0, len(m.renewals))
+	for key := range m.renewals {
+		renewals = append(renewals, key)
+	}
+	sort.Slice(renewals, func(i, j int) bool {
+		if renewals[i].port != renewals[j].port {
+			return renewals[i].port < renewals[j].port
+		}
+		return renewals[i].result < renewals[j].result
+	})
+
+	w.family("proxyapi_renewals_total", "counter", "Renovações de IP terminadas, por resultado.")
+	for _, key := range renewals {
+		w.sample("proxyapi_renewals_total", float64(m.renewals[key].count), "port", strconv.Itoa(key.port), "result", key.result)
+	}
+
+	w.family("proxyapi_renew_duration_seconds", "histogram", "Duração das renovações de IP, por resultado.")
+	for _, key := range renewals {
+		w.histogram("proxyapi_renew_duration_seconds", m.renewals[key], "port", strconv.Itoa(key.port), "result", key.result)
+	}
+
+	received := make([]string, 0, len(m.smsReceived))
+	for modemID := range m.smsReceived {
+		received = append(received, modemID)
+	}
+	sort.Strings(received)
+
+	w.family("proxyapi_sms_received_total", "counter", "SMS recebidos.")
+	for _, modemID := range received {
+		w.sample("proxyapi_sms_received_total", float64(m.smsReceived[modemID]), "modem", modemID)
+	}
+
+	sent := make([]smsKey, 0, len(m.smsSent))
+	for key := range m.smsSent {
+		sent = append(sent, key)
+	}
+	sort.Slice(sent, func(i, j int) bool {
+		if sent[i].modemID != sent[j].modemID {
+			return sent[i].modemID < sent[j].modemID
+		}
+		return sent[i].result < sent[j].result
+	})
+
+	w.family("proxyapi_sms_sent_total", "counter", "SMS enviados, por resultado.")
+	for _, key := range sent {
+		w.sample("proxyapi_sms_sent_total", float64(m.smsSent[key]), "modem", key.modemID, "result", key.result)
+	}
+
+	w.family("proxyapi_status_refresh_duration_seconds", "histogram", "Duração da atualização do cache de status.")
+	w.histogram("proxyapi_status_refresh_duration_seconds", m.statusRefresh)
+
+	commands := make([]string, 0, len(m.subprocesses))
+	for command := range m.subprocesses {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	w.family("proxyapi_subprocesses_total", "counter", "Processos externos disparados, por comando.")
+	for _, command := range commands {
+		w.sample("proxyapi_subprocesses_total", float64(m.subprocesses[command]), "command", command)
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	status := getSystemStatus()
+
+	out := &metricsWriter{}
+	metrics.writeModems(out, status)
+	metrics.writeProxies(out, status)
+	metrics.writeCounters(out)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(out.b.String()))
+}
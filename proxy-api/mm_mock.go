@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// ============================================================================
+// MOCK DO MODEMMANAGER NO D-BUS
+// ============================================================================
+
+// MockModemManager publica um ModemBackend (normalmente o fake) no D-Bus com
+// a mesma API do org.freedesktop.ModemManager1. Rodando num barramento de
+// sessão privado, permite exercitar o DBusBackend sem modems reais.
+type MockModemManager struct {
+	backend ModemBackend
+
+	mu          sync.Mutex
+	smsOwner    map[string]string
+	signalRates map[string]uint32
+}
+
+type mockObjectManager struct{ m *MockModemManager }
+type mockProperties struct{ m *MockModemManager }
+type mockModem struct{ m *MockModemManager }
+type mockSimple struct{ m *MockModemManager }
+type mockMessaging struct{ m *MockModemManager }
+type mockSms struct{ m *MockModemManager }
+type mockSignal struct{ m *MockModemManager }
+
+// mockSignalQuality é serializado como (ub), igual ao ModemManager.
+type mockSignalQuality struct {
+	Quality uint32
+	Recent  bool
+}
+
+func ExportMockModemManager(conn *dbus.Conn, backend ModemBackend) error {
+	m := &MockModemManager{
+		backend:     backend,
+		smsOwner:    make(map[string]string),
+		signalRates: make(map[string]uint32),
+	}
+
+	exports := []struct {
+		value   interface{}
+		iface   string
+		subtree bool
+	}{
+		{mockObjectManager{m}, dbusObjectManagerIface, false},
+		{mockProperties{m}, dbusPropertiesIface, true},
+		{mockModem{m}, mmModemIface, true},
+		{mockSimple{m}, mmSimpleIface, true},
+		{mockMessaging{m}, mmMessagingIface, true},
+		{mockSms{m}, mmSmsIface, true},
+		{mockSignal{m}, mmSignalIface, true},
+	}
+
+	for _, e := range exports {
+		var err error
+		if e.subtree {
+			err = conn.ExportSubtree(e.value, mmPath, e.iface)
+		} else {
+			err = conn.Export(e.value, mmPath, e.iface)
+		}
+		if err != nil {
+			return fmt.Errorf("erro ao exportar %s: %v", e.iface, err)
+		}
+	}
+
+	reply, err := conn.RequestName(mmService, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return fmt.Errorf("erro ao registrar %s: %v", mmService, err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("nome %s já está em uso no barramento", mmService)
+	}
+
+	return nil
+}
+
+// runMockModemManager é o subcomando "mock-modemmanager": publica o backend
+// fake no barramento indicado por MM_DBUS_ADDRESS (padrão: sessão).
+func runMockModemManager() {
+	backend, err := newFakeBackend(os.Getenv("FAKE_MODEM_SCRIPT"))
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	var conn *dbus.Conn
+	address := getEnv("MM_DBUS_ADDRESS", "session")
+	switch address {
+	case "session":
+		conn, err = dbus.ConnectSessionBus()
+	case "system":
+		conn, err = dbus.ConnectSystemBus()
+	default:
+		conn, err = dbus.Connect(address)
+	}
+	if err != nil {
+		log.Fatalf("❌ Erro ao conectar no D-Bus: %v", err)
+	}
+
+	if err := ExportMockModemManager(conn, backend); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	log.Printf("🧪 Mock do ModemManager publicado em %s (%s)", mmService, address)
+	select {}
+}
+
+func messagePath(msg dbus.Message) dbus.ObjectPath {
+	p, _ := msg.Headers[dbus.FieldPath].Value().(dbus.ObjectPath)
+	return p
+}
+
+func mockError(err error) *dbus.Error {
+	if err == nil {
+		return nil
+	}
+	return dbus.MakeFailedError(err)
+}
+
+func (m *MockModemManager) modemProperties(ctx context.Context, modemID string) (map[string]dbus.Variant, error) {
+	info, err := m.backend.GetModem(ctx, modemID)
+	if err != nil {
+		return nil, err
+	}
+
+	details, err := m.backend.GetModemDetails(ctx, modemID)
+	if err != nil {
+		return nil, err
+	}
+
+	state := int32(0)
+	for value, name := range mmModemStates {
+		if name == info.State {
+			state = value
+		}
+	}
+
+	power := uint32(0)
+	for value, name := range mmPowerStates {
+		if name == info.PowerState {
+			power = uint32(value)
+		}
+	}
+
+	quality := mockSignalQuality{}
+	if info.SignalQuality >= 0 {
+		quality = mockSignalQuality{Quality: uint32(info.SignalQuality), Recent: true}
+	}
+
+	tech := uint32(0)
+	for _, name := range strings.Split(info.AccessTech, ",") {
+		for bit, known := range mmAccessTechs {
+			if strings.TrimSpace(name) == known {
+				tech |= 1 << bit
+			}
+		}
+	}
+
+	bearers := make([]dbus.ObjectPath, 0, len(info.Bearers))
+	for _, id := range info.Bearers {
+		bearers = append(bearers, bearerPath(id))
+	}
+
+	sim := dbus.ObjectPath("/")
+	if info.SIM != "" {
+		sim = simPath(info.SIM)
+	}
+
+	return map[string]dbus.Variant{
+		"EquipmentIdentifier": dbus.MakeVariant(info.IMEI),
+		"Manufacturer":        dbus.MakeVariant(details.Manufacturer),
+		"Model":               dbus.MakeVariant(details.Model),
+		"Revision":            dbus.MakeVariant(details.Firmware),
+		"OwnNumbers":          dbus.MakeVariant(append([]string{}, details.OwnNumbers...)),
+		"State":               dbus.MakeVariant(state),
+		"PowerState":          dbus.MakeVariant(power),
+		"SignalQuality":       dbus.MakeVariant(quality),
+		"AccessTechnologies":  dbus.MakeVariant(tech),
+		"Bearers":             dbus.MakeVariant(bearers),
+		"Sim":                 dbus.MakeVariant(sim),
+	}, nil
+}
+
+func (m *MockModemManager) modem3gppProperties(ctx context.Context, modemID string) (map[string]dbus.Variant, error) {
+	details, err := m.backend.GetModemDetails(ctx, modemID)
+	if err != nil {
+		return nil, err
+	}
+
+	registration := uint32(4) // unknown
+	for value, name := range mmRegistrationStates {
+		if name == details.RegistrationState {
+			registration = uint32(value)
+		}
+	}
+
+	return map[string]dbus.Variant{
+		"OperatorName":      dbus.MakeVariant(details.OperatorName),
+		"OperatorCode":      dbus.MakeVariant(details.OperatorCode),
+		"RegistrationState": dbus.MakeVariant(registration),
+	}, nil
+}
+
+// signalProperties só publica medidas depois do Setup, como o ModemManager.
+func (m *MockModemManager) signalProperties(ctx context.Context, modemID string) (map[string]dbus.Variant, error) {
+	details, err := m.backend.GetModemDetails(ctx, modemID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	rate := m.signalRates[modemID]
+	m.mu.Unlock()
+
+	props := map[string]dbus.Variant{"Rate": dbus.MakeVariant(rate)}
+	for _, tech := range mmSignalTechs {
+		props[tech.property] = dbus.MakeVariant(map[string]dbus.Variant{})
+	}
+	if rate == 0 || details.Signal == nil {
+		return props, nil
+	}
+
+	values := make(map[string]dbus.Variant)
+	for key, value := range map[string]*float64{
+		"rssi": details.Signal.RSSI,
+		"rsrp": details.Signal.RSRP,
+		"rsrq": details.Signal.RSRQ,
+		"snr":  details.Signal.SINR,
+	} {
+		if value != nil {
+			values[key] = dbus.MakeVariant(*value)
+		}
+	}
+	for _, tech := range mmSignalTechs {
+		if tech.name == details.Signal.Tech {
+			props[tech.property] = dbus.MakeVariant(values)
+		}
+	}
+	return props, nil
+}
+
+func (m *MockModemManager) simProperties(ctx context.Context, simID string) (map[string]dbus.Variant, error) {
+	sim, err := m.backend.GetSIM(ctx, simID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]dbus.Variant{
+		"SimIdentifier":      dbus.MakeVariant(sim.ICCID),
+		"Imsi":               dbus.MakeVariant(sim.IMSI),
+		"OperatorIdentifier": dbus.MakeVariant(sim.OperatorCode),
+		"OperatorName":       dbus.MakeVariant(sim.OperatorName),
+	}, nil
+}
+
+func (m *MockModemManager) bearerProperties(ctx context.Context, bearerID string) (map[string]dbus.Variant, error) {
+	bearer, err := m.backend.GetBearer(ctx, bearerID)
+	if err != nil {
+		return nil, err
+	}
+
+	config := map[string]dbus.Variant{
+		"address": dbus.MakeVariant(bearer.Address),
+		"prefix":  dbus.MakeVariant(uint32(bearer.Prefix)),
+		"gateway": dbus.MakeVariant(bearer.Gateway),
+		"mtu":     dbus.MakeVariant(uint32(bearer.MTU)),
+	}
+	for i, dns := range bearer.DNS {
+		config["dns"+strconv.Itoa(i+1)] = dbus.MakeVariant(dns)
+	}
+
+	return map[string]dbus.Variant{
+		"Interface": dbus.MakeVariant(bearer.Interface),
+		"Connected": dbus.MakeVariant(bearer.Connected),
+		"Ip4Config": dbus.MakeVariant(config),
+	}, nil
+}
+
+func (m *MockModemManager) smsProperties(ctx context.Context, smsID string) (map[string]dbus.Variant, error) {
+	m.mu.Lock()
+	modemID, ok := m.smsOwner[smsID]
+	m.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("SMS %s desconhecido", smsID)
+	}
+
+	sms, err := m.backend.GetSMS(ctx, modemID, smsID)
+	if err != nil {
+		return nil, err
+	}
+
+	state := uint32(0)
+	for value, name := range mmSmsStates {
+		if name == sms.State {
+			state = uint32(value)
+		}
+	}
+
+	return map[string]dbus.Variant{
+		"Number":    dbus.MakeVariant(sms.Number),
+		"Text":      dbus.MakeVariant(sms.Text),
+		"Timestamp": dbus.MakeVariant(sms.Timestamp),
+		"State":     dbus.MakeVariant(state),
+	}, nil
+}
+
+func (m *MockModemManager) rememberSMS(modemID, smsID string) {
+	m.mu.Lock()
+	m.smsOwner[smsID] = modemID
+	m.mu.Unlock()
+}
+
+func (o mockObjectManager) GetManagedObjects() (map[dbus.ObjectPath]map[string]map[string]dbus.Variant, *dbus.Error) {
+	ctx := context.Background()
+	objects := make(map[dbus.ObjectPath]map[string]map[string]dbus.Variant)
+
+	modemIDs, err := o.m.backend.ListModems(ctx)
+	if err != nil {
+		return nil, mockError(err)
+	}
+
+	for _, modemID := range modemIDs {
+		props, err := o.m.modemProperties(ctx, modemID)
+		if err != nil {
+			continue
+		}
+		objects[modemPath(modemID)] = map[string]map[string]dbus.Variant{mmModemIface: props}
+	}
+
+	return objects, nil
+}
+
+func (p mockProperties) GetAll(msg dbus.Message, iface string) (map[string]dbus.Variant, *dbus.Error) {
+	ctx := context.Background()
+	objPath := messagePath(msg)
+	id := objectID(objPath)
+
+	var props map[string]dbus.Variant
+	var err error
+
+	switch iface {
+	case mmModemIface:
+		props, err = p.m.modemProperties(ctx, id)
+	case mmBearerIface:
+		props, err = p.m.bearerProperties(ctx, id)
+	case mmSmsIface:
+		props, err = p.m.smsProperties(ctx, id)
+	case mmSimIface:
+		props, err = p.m.simProperties(ctx, id)
+	case mm3gppIface:
+		props, err = p.m.modem3gppProperties(ctx, id)
+	case mmSignalIface:
+		props, err = p.m.signalProperties(ctx, id)
+	default:
+		props = map[string]dbus.Variant{}
+	}
+
+	return props, mockError(err)
+}
+
+func (p mockProperties) Get(msg dbus.Message, iface, name string) (dbus.Variant, *dbus.Error) {
+	props, dbusErr := p.GetAll(msg, iface)
+	if dbusErr != nil {
+		return dbus.Variant{}, dbusErr
+	}
+
+	value, ok := props[name]
+	if !ok {
+		return dbus.Variant{}, mockError(fmt.Errorf("propriedade %s.%s inexistente", iface, name))
+	}
+	return value, nil
+}
+
+func (p mockProperties) Set(msg dbus.Message, iface, name string, value dbus.Variant) *dbus.Error {
+	return mockError(fmt.Errorf("propriedade %s.%s é somente leitura", iface, name))
+}
+
+func (mm mockModem) SetPowerState(msg dbus.Message, state uint32) *dbus.Error {
+	modemID := objectID(messagePath(msg))
+	return mockError(mm.m.backend.SetPowerState(context.Background(), modemID, PowerState(enumName(mmPowerStates, state))))
+}
+
+// GetCellInfo publica só a célula servidora, com as chaves de área e canal
+// de cada tecnologia.
+func (mm mockModem) GetCellInfo(msg dbus.Message) ([]map[string]dbus.Variant, *dbus.Error) {
+	modemID := objectID(messagePath(msg))
+
+	details, err := mm.m.backend.GetModemDetails(context.Background(), modemID)
+	if err != nil {
+		return nil, mockError(err)
+	}
+
+	cells := make([]map[string]dbus.Variant, 0, 1)
+	c := details.Cell
+	if c == nil {
+		return cells, nil
+	}
+
+	cellType := uint32(0)
+	for value, name := range mmCellTypes {
+		if name == c.Type {
+			cellType = uint32(value)
+		}
+	}
+
+	areaKey, channelKey := "lac", "arfcn"
+	switch c.Type {
+	case "lte":
+		areaKey, channelKey = "tac", "earfcn"
+	case "5gnr":
+		areaKey, channelKey = "tac", "nrarfcn"
+	case "umts":
+		channelKey = "uarfcn"
+	}
+
+	cells = append(cells, map[string]dbus.Variant{
+		"cell-type":   dbus.MakeVariant(cellType),
+		"serving":     dbus.MakeVariant(true),
+		"operator-id": dbus.MakeVariant(c.OperatorCode),
+		"ci":          dbus.MakeVariant(c.CellID),
+		"physical-ci": dbus.MakeVariant(c.PhysicalID),
+		areaKey:       dbus.MakeVariant(c.AreaCode),
+		channelKey:    dbus.MakeVariant(uint32(c.ARFCN)),
+	})
+	return cells, nil
+}
+
+func (s mockSimple) Connect(msg dbus.Message, properties map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	modemID := objectID(messagePath(msg))
+
+	params := ConnectParams{
+		APN:      variantString(properties["apn"]),
+		User:     variantString(properties["user"]),
+		Password: variantString(properties["password"]),
+	}
+	for name, value := range mmIPFamilies {
+		if value == variantUint32(properties["ip-type"]) {
+			params.IPType = name
+		}
+	}
+
+	bearerID, err := s.m.backend.Connect(context.Background(), modemID, params)
+	if err != nil {
+		return "/", mockError(err)
+	}
+	return bearerPath(bearerID), nil
+}
+
+func (s mockSimple) Disconnect(msg dbus.Message, bearer dbus.ObjectPath) *dbus.Error {
+	modemID := objectID(messagePath(msg))
+	return mockError(s.m.backend.Disconnect(context.Background(), modemID))
+}
+
+func (ms mockMessaging) List(msg dbus.Message) ([]dbus.ObjectPath, *dbus.Error) {
+	modemID := objectID(messagePath(msg))
+
+	smsIDs, err := ms.m.backend.ListSMS(context.Background(), modemID)
+	if err != nil {
+		return nil, mockError(err)
+	}
+
+	paths := make([]dbus.ObjectPath, 0, len(smsIDs))
+	for _, smsID := range smsIDs {
+		ms.m.rememberSMS(modemID, smsID)
+		paths = append(paths, smsPath(smsID))
+	}
+	return paths, nil
+}
+
+func (ms mockMessaging) Create(msg dbus.Message, properties map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	modemID := objectID(messagePath(msg))
+
+	smsID, err := ms.m.backend.CreateSMS(context.Background(), modemID, variantString(properties["number"]), variantString(properties["text"]))
+	if err != nil {
+		return "/", mockError(err)
+	}
+
+	ms.m.rememberSMS(modemID, smsID)
+	return smsPath(smsID), nil
+}
+
+func (ms mockMessaging) Delete(msg dbus.Message, sms dbus.ObjectPath) *dbus.Error {
+	modemID := objectID(messagePath(msg))
+	return mockError(ms.m.backend.DeleteSMS(context.Background(), modemID, objectID(sms)))
+}
+
+func (s mockSignal) Setup(msg dbus.Message, rate uint32) *dbus.Error {
+	modemID := objectID(messagePath(msg))
+
+	s.m.mu.Lock()
+	s.m.signalRates[modemID] = rate
+	s.m.mu.Unlock()
+	return nil
+}
+
+func (s mockSms) Send(msg dbus.Message) *dbus.Error {
+	smsID := objectID(messagePath(msg))
+
+	s.m.mu.Lock()
+	modemID, ok := s.m.smsOwner[smsID]
+	s.m.mu.Unlock()
+	if !ok {
+		return mockError(fmt.Errorf("SMS %s desconhecido", smsID))
+	}
+
+	return mockError(s.m.backend.SendSMS(context.Background(), modemID, smsID))
+}
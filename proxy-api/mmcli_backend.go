@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// BACKEND DE MODEMS - MMCLI
+// ============================================================================
+
+// MMCLIBackend executa o mmcli e extrai os campos da saída em texto.
+// Operações que alteram o modem rodam via sudo, como o deleteSMS original.
+type MMCLIBackend struct{}
+
+func NewMMCLIBackend() *MMCLIBackend {
+	return &MMCLIBackend{}
+}
+
+func (b *MMCLIBackend) Name() string {
+	return "mmcli"
+}
+
+func (b *MMCLIBackend) run(ctx context.Context, sudo bool, args ...string) (string, error) {
+	var cmd *exec.Cmd
+	if sudo {
+		cmd = exec.CommandContext(ctx, "sudo", append([]string{"mmcli"}, args...)...)
+	} else {
+		cmd = exec.CommandContext(ctx, "mmcli", args...)
+	}
+
+	metrics.Subprocess("mmcli")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("mmcli %s: %v - %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+func (b *MMCLIBackend) ListModems(ctx context.Context) ([]string, error) {
+	output, err := b.run(ctx, false, "-L")
+	if err != nil {
+		return nil, err
+	}
+	return extractAll(output, `Modem/(\d+)`), nil
+}
+
+func (b *MMCLIBackend) GetModem(ctx context.Context, modemID string) (*ModemInfo, error) {
+	output, err := b.run(ctx, false, "-m", modemID)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ModemInfo{
+		ID:            modemID,
+		IMEI:          strings.Trim(strings.TrimSpace(extractValue(output, `equipment id:\s*(.+)`)), "'"),
+		State:         strings.TrimSpace(extractValue(output, `state:\s*(.+)`)),
+		PowerState:    strings.TrimSpace(extractValue(output, `power state:\s*(.+)`)),
+		SignalQuality: -1,
+		AccessTech:    strings.TrimSpace(extractValue(output, `access tech:\s*(.+)`)),
+		Bearers:       extractAll(output, `/org/freedesktop/ModemManager1/Bearer/(\d+)`),
+		SIM:           extractValue(output, `/org/freedesktop/ModemManager1/SIM/(\d+)`),
+	}
+
+	if signal := extractValue(output, `signal quality:\s*(\d+)`); signal != "" {
+		info.SignalQuality, _ = strconv.Atoi(signal)
+	}
+
+	return info, nil
+}
+
+// GetModemDetails lê hardware, rede 3GPP e números do "mmcli -m", além do
+// sinal estendido e da célula servidora, que versões antigas do ModemManager
+// (ou modems sem suporte) não informam.
+func (b *MMCLIBackend) GetModemDetails(ctx context.Context, modemID string) (*ModemDetails, error) {
+	output, err := b.run(ctx, false, "-m", modemID)
+	if err != nil {
+		return nil, err
+	}
+
+	value := func(pattern string) string {
+		v := strings.Trim(strings.TrimSpace(extractValue(output, pattern)), "'")
+		if v == "--" {
+			return ""
+		}
+		return v
+	}
+	details := &ModemDetails{
+		Manufacturer:      value(`manufacturer:\s*(.+)`),
+		Model:             value(`model:\s*(.+)`),
+		Firmware:          value(`firmware revision:\s*(.+)`),
+		OperatorName:      value(`operator name:\s*(.+)`),
+		OperatorCode:      value(`operator id:\s*(.+)`),
+		RegistrationState: value(`registration:\s*(.+)`),
+	}
+	if own := value(`own:\s*(.+)`); own != "" {
+		for _, number := range strings.Split(own, ",") {
+			details.OwnNumbers = append(details.OwnNumbers, strings.TrimSpace(number))
+		}
+	}
+
+	details.Signal = b.extendedSignal(ctx, modemID)
+	details.Cell = b.servingCell(ctx, modemID)
+	return details, nil
+}
+
+// extendedSignal lê o "mmcli --signal-get". Com taxa 0 o ModemManager não
+// mede nada: a leitura é configurada e o sinal só aparece na próxima
+// consulta.
+func (b *MMCLIBackend) extendedSignal(ctx context.Context, modemID string) *SignalInfo {
+	output, err := b.run(ctx, false, "-m", modemID, "--signal-get")
+	if err != nil {
+		return nil
+	}
+
+	sections := make(map[string]map[string]string)
+	for _, f := range mmcliFields(output) {
+		if sections[f.section] == nil {
+			sections[f.section] = make(map[string]string)
+		}
+		sections[f.section][f.key] = f.value
+	}
+
+	if rate := mmcliNumber(sections["Refresh"]["rate"]); rate == nil || *rate == 0 {
+		if _, err := b.run(ctx, true, "-m", modemID, "--signal-setup="+strconv.Itoa(signalRefreshRate)); err != nil {
+			log.Printf("⚠️  Erro ao configurar sinal estendido do modem %s: %v", modemID, err)
+		}
+		return nil
+	}
+
+	for _, tech := range []struct{ section, name string }{{"LTE", "lte"}, {"5G", "5gnr"}, {"UMTS", "umts"}, {"GSM", "gsm"}} {
+		fields, ok := sections[tech.section]
+		if !ok {
+			continue
+		}
+		signal := &SignalInfo{
+			Tech: tech.name,
+			RSSI: mmcliNumber(fields["rssi"]),
+			RSRP: mmcliNumber(fields["rsrp"]),
+			RSRQ: mmcliNumber(fields["rsrq"]),
+			SINR: mmcliNumber(fields["s/n"]),
+		}
+		if signal.RSSI != nil || signal.RSRP != nil {
+			return signal
+		}
+	}
+	return nil
+}
+
+// servingCell lê o "mmcli --get-cell-info" (ModemManager 1.20+) e devolve
+// a célula servidora.
+func (b *MMCLIBackend) servingCell(ctx context.Context, modemID string) *CellInfo {
+	output, err := b.run(ctx, false, "-m", modemID, "--get-cell-info")
+	if err != nil {
+		return nil
+	}
+
+	cells := make([]map[string]string, 0)
+	for _, f := range mmcliFields(output) {
+		if f.key == "cell type" {
+			cells = append(cells, make(map[string]string))
+		}
+		if len(cells) > 0 {
+			cells[len(cells)-1][f.key] = f.value
+		}
+	}
+
+	for _, c := range cells {
+		if c["serving"] != "yes" {
+			continue
+		}
+		cell := &CellInfo{
+			Type:         c["cell type"],
+			OperatorCode: c["operator id"],
+			CellID:       c["ci"],
+			AreaCode:     c["tac"],
+			PhysicalID:   c["physical ci"],
+		}
+		if cell.AreaCode == "" {
+			cell.AreaCode = c["lac"]
+		}
+		for _, key := range []string{"earfcn", "nrarfcn", "uarfcn", "arfcn"} {
+			if n, err := strconv.Atoi(c[key]); err == nil {
+				cell.ARFCN = n
+				break
+			}
+		}
+		cell.fillBand()
+		return cell
+	}
+	return nil
+}
+
+func (b *MMCLIBackend) GetBearer(ctx context.Context, bearerID string) (*BearerInfo, error) {
+	output, err := b.run(ctx, false, "-b", bearerID)
+	if err != nil {
+		return nil, err
+	}
+
+	bearer := &BearerInfo{
+		ID:        bearerID,
+		Interface: strings.TrimSpace(extractValue(output, `interface:\s*(.+)`)),
+		Connected: strings.TrimSpace(extractValue(output, `connected:\s*(.+)`)) == "yes",
+		Address:   strings.TrimSpace(extractValue(output, `address:\s*(.+)`)),
+		Gateway:   strings.TrimSpace(extractValue(output, `gateway:\s*(.+)`)),
+	}
+
+	bearer.Prefix, _ = strconv.Atoi(extractValue(output, `prefix:\s*(\d+)`))
+	bearer.MTU, _ = strconv.Atoi(extractValue(output, `mtu:\s*(\d+)`))
+
+	if dns := strings.TrimSpace(extractValue(output, `dns:\s*(.+)`)); dns != "" {
+		for _, server := range strings.Split(dns, ",") {
+			bearer.DNS = append(bearer.DNS, strings.TrimSpace(server))
+		}
+	}
+
+	return bearer, nil
+}
+
+// GetSIM lê "mmcli -i"; os valores vêm entre aspas simples em versões
+// antigas do mmcli.
+func (b *MMCLIBackend) GetSIM(ctx context.Context, simID string) (*SIMInfo, error) {
+	output, err := b.run(ctx, false, "-i", simID)
+	if err != nil {
+		return nil, err
+	}
+
+	value := func(pattern string) string {
+		return strings.Trim(strings.TrimSpace(extractValue(output, pattern)), "'")
+	}
+	return &SIMInfo{
+		ID:           simID,
+		ICCID:        value(`iccid:\s*(.+)`),
+		IMSI:         value(`imsi:\s*(.+)`),
+		OperatorCode: value(`operator id:\s*(.+)`),
+		OperatorName: value(`operator name:\s*(.+)`),
+	}, nil
+}
+
+func (b *MMCLIBackend) ListSMS(ctx context.Context, modemID string) ([]string, error) {
+	output, err := b.run(ctx, false, "-m", modemID, "--messaging-list-sms")
+	if err != nil {
+		return nil, err
+	}
+	return extractAll(output, `SMS/(\d+)`), nil
+}
+
+func (b *MMCLIBackend) GetSMS(ctx context.Context, modemID, smsID string) (*SMS, error) {
+	output, err := b.run(ctx, false, "-m", modemID, "--sms", smsID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SMS{
+		ID:        smsID,
+		ModemID:   modemID,
+		Number:    strings.TrimSpace(extractValue(output, `number:\s*(.+)`)),
+		Text:      strings.TrimSpace(extractValue(output, `text:\s*(.+)`)),
+		Timestamp: strings.TrimSpace(extractValue(output, `timestamp:\s*(.+)`)),
+		State:     strings.TrimSpace(extractValue(output, `state:\s*(.+)`)),
+		Received:  time.Now(),
+	}, nil
+}
+
+func (b *MMCLIBackend) CreateSMS(ctx context.Context, modemID, number, text string) (string, error) {
+	createCmd := fmt.Sprintf("text='%s',number='%s'", text, number)
+	output, err := b.run(ctx, false, "-m", modemID, "--messaging-create-sms="+createCmd)
+	if err != nil {
+		return "", fmt.Errorf("erro ao criar SMS: %v", err)
+	}
+
+	smsID := extractValue(output, `SMS/(\d+)`)
+	if smsID == "" {
+		return "", fmt.Errorf("não foi possível extrair ID do SMS")
+	}
+	return smsID, nil
+}
+
+func (b *MMCLIBackend) SendSMS(ctx context.Context, modemID, smsID string) error {
+	_, err := b.run(ctx, false, "-m", modemID, "--sms", smsID, "--send")
+	return err
+}
+
+func (b *MMCLIBackend) DeleteSMS(ctx context.Context, modemID, smsID string) error {
+	_, err := b.run(ctx, true, "-m", modemID, "--messaging-delete-sms="+smsID)
+	return err
+}
+
+func (b *MMCLIBackend) SetPowerState(ctx context.Context, modemID string, state PowerState) error {
+	_, err := b.run(ctx, true, "-m", modemID, "--set-power-state-"+string(state))
+	return err
+}
+
+func (b *MMCLIBackend) Connect(ctx context.Context, modemID string, params ConnectParams) (string, error) {
+	ipType := params.IPType
+	if ipType == "" {
+		ipType = "ipv4"
+	}
+
+	connectArg := fmt.Sprintf("apn=%s,user=%s,password=%s,ip-type=%s", params.APN, params.User, params.Password, ipType)
+	if _, err := b.run(ctx, true, "-m", modemID, "--simple-connect="+connectArg); err != nil {
+		return "", err
+	}
+
+	info, err := b.GetModem(ctx, modemID)
+	if err != nil {
+		return "", err
+	}
+
+	bearerID := info.CurrentBearer()
+	if bearerID == "" {
+		return "", fmt.Errorf("bearer não encontrado após conectar modem %s", modemID)
+	}
+	return bearerID, nil
+}
+
+func (b *MMCLIBackend) Disconnect(ctx context.Context, modemID string) error {
+	_, err := b.run(ctx, true, "-m", modemID, "--simple-disconnect")
+	return err
+}
+
+// mmcliField é uma linha "seção | chave: valor" da saída do mmcli; as linhas
+// de continuação herdam a seção anterior e "--" vira valor vazio.
+type mmcliField struct {
+	section, key, value string
+}
+
+func mmcliFields(output string) []mmcliField {
+	fields := make([]mmcliField, 0)
+	section := ""
+	for _, line := range strings.Split(output, "\n") {
+		left, right, ok := strings.Cut(line, "|")
+		if !ok {
+			continue
+		}
+		if name := strings.TrimSpace(left); name != "" {
+			section = name
+		}
+		key, value, ok := strings.Cut(right, ":")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), "'")
+		if value == "--" {
+			value = ""
+		}
+		fields = append(fields, mmcliField{section: section, key: strings.TrimSpace(key), value: value})
+	}
+	return fields
+}
+
+// mmcliNumber converte "-95.00 dBm" e afins; nil se não houver número.
+func mmcliNumber(value string) *float64 {
+	parts := strings.Fields(value)
+	if len(parts) == 0 {
+		return nil
+	}
+	n, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+func extractAll(data, pattern string) []string {
+	re := regexp.MustCompile(pattern)
+	values := make([]string, 0)
+	for _, match := range re.FindAllStringSubmatch(data, -1) {
+		if len(match) > 1 {
+			values = append(values, match[1])
+		}
+	}
+	return values
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// ============================================================================
+// BACKEND DE MODEMS - INTERFACE
+// ============================================================================
+
+// ModemBackend abstrai o acesso aos modems (listagem, bearers, mensagens e
+// operações de energia/conexão). A implementação padrão usa o mmcli; a
+// implementação fake permite rodar a API sem hardware.
+type ModemBackend interface {
+	Name() string
+
+	ListModems(ctx context.Context) ([]string, error)
+	GetModem(ctx context.Context, modemID string) (*ModemInfo, error)
+	GetModemDetails(ctx context.Context, modemID string) (*ModemDetails, error)
+	GetBearer(ctx context.Context, bearerID string) (*BearerInfo, error)
+	GetSIM(ctx context.Context, simID string) (*SIMInfo, error)
+
+	ListSMS(ctx context.Context, modemID string) ([]string, error)
+	GetSMS(ctx context.Context, modemID, smsID string) (*SMS, error)
+	CreateSMS(ctx context.Context, modemID, number, text string) (string, error)
+	SendSMS(ctx context.Context, modemID, smsID string) error
+	DeleteSMS(ctx context.Context, modemID, smsID string) error
+
+	SetPowerState(ctx context.Context, modemID string, state PowerState) error
+	Connect(ctx context.Context, modemID string, params ConnectParams) (string, error)
+	Disconnect(ctx context.Context, modemID string) error
+}
+
+type PowerState string
+
+const (
+	PowerStateOn  PowerState = "on"
+	PowerStateLow PowerState = "low"
+)
+
+// ModemInfo é a visão do backend sobre um modem. SignalQuality vale -1
+// quando o backend não conseguiu ler o sinal. IMEI é o identificador do
+// equipamento, que não muda quando o ModemManager renumera os modems.
+type ModemInfo struct {
+	ID            string   `json:"id"`
+	IMEI          string   `json:"imei,omitempty"`
+	State         string   `json:"state"`
+	PowerState    string   `json:"power_state,omitempty"`
+	SignalQuality int      `json:"signal_quality"`
+	AccessTech    string   `json:"access_tech,omitempty"`
+	Bearers       []string `json:"bearers"`
+	SIM           string   `json:"sim,omitempty"`
+}
+
+// SIMInfo é o SIM ativo do modem. OperatorCode é o MCC/MNC da operadora
+// dona do SIM (ex.: "72406"), não o da rede em que está registrado.
+type SIMInfo struct {
+	ID           string `json:"id"`
+	ICCID        string `json:"iccid,omitempty"`
+	IMSI         string `json:"imsi,omitempty"`
+	OperatorCode string `json:"operator_code,omitempty"`
+	OperatorName string `json:"operator_name,omitempty"`
+}
+
+type BearerInfo struct {
+	ID        string   `json:"id"`
+	Interface string   `json:"interface"`
+	Connected bool     `json:"connected"`
+	Address   string   `json:"address"`
+	Prefix    int      `json:"prefix"`
+	Gateway   string   `json:"gateway"`
+	DNS       []string `json:"dns,omitempty"`
+	MTU       int      `json:"mtu,omitempty"`
+}
+
+type ConnectParams struct {
+	APN      string `json:"apn"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	IPType   string `json:"ip_type"`
+}
+
+// CurrentBearer retorna o último bearer listado pelo modem, o mesmo critério
+// usado pelo proxy-manager.sh (tail -1).
+func (m *ModemInfo) CurrentBearer() string {
+	if len(m.Bearers) == 0 {
+		return ""
+	}
+	return m.Bearers[len(m.Bearers)-1]
+}
+
+// ============================================================================
+// BACKEND DE MODEMS - SELEÇÃO
+// ============================================================================
+
+// newModemBackend escolhe o backend pela seção modems da configuração
+// (mmcli|dbus|fake). O backend dbus usa dbus_address (system, session ou
+// endereço explícito).
+func newModemBackend(cfg ModemsConfig) (ModemBackend, error) {
+	switch cfg.Backend {
+	case "mmcli":
+		return NewMMCLIBackend(), nil
+	case "dbus":
+		return ConnectDBusBackend(cfg.DBusAddress)
+	case "fake":
+		return newFakeBackend(cfg.FakeScript)
+	default:
+		return nil, fmt.Errorf("backend de modem desconhecido: %s", cfg.Backend)
+	}
+}
+
+// newFakeBackend carrega o roteiro de scriptPath; vazio usa o roteiro
+// embutido.
+func newFakeBackend(scriptPath string) (*FakeBackend, error) {
+	if scriptPath == "" {
+		log.Println("🧪 Backend fake usando roteiro embutido")
+		return NewFakeBackend(defaultFakeScript()), nil
+	}
+
+	script, err := LoadFakeScript(scriptPath)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("🧪 Backend fake usando roteiro %s", scriptPath)
+	return NewFakeBackend(script), nil
+}
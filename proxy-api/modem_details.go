@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"strconv"
+)
+
+// ============================================================================
+// DETALHES DO MODEM
+// ============================================================================
+
+// signalRefreshRate é o intervalo (s) de leitura do sinal estendido pedido
+// ao ModemManager, que só mede RSSI/RSRP/RSRQ/SINR depois de configurado.
+const signalRefreshRate = 10
+
+// ModemDetails é o que o backend sabe do modem além do ModemInfo: hardware,
+// rede 3GPP em que está registrado, sinal estendido e célula servidora.
+// Campos que o modem (ou a versão do ModemManager) não informa ficam vazios.
+type ModemDetails struct {
+	Manufacturer      string      `json:"manufacturer,omitempty"`
+	Model             string      `json:"model,omitempty"`
+	Firmware          string      `json:"firmware,omitempty"`
+	OwnNumbers        []string    `json:"own_numbers,omitempty"`
+	OperatorName      string      `json:"operator_name,omitempty"`
+	OperatorCode      string      `json:"operator_code,omitempty"`
+	RegistrationState string      `json:"registration_state,omitempty"`
+	Signal            *SignalInfo `json:"extended_signal,omitempty"`
+	Cell              *CellInfo   `json:"cell,omitempty"`
+}
+
+// SignalInfo são as medidas do sinal estendido de uma tecnologia (lte, 5g,
+// umts ou gsm), em dBm (RSSI/RSRP) e dB (RSRQ/SINR). Medidas que a
+// tecnologia não tem ficam nil.
+type SignalInfo struct {
+	Tech string   `json:"tech"`
+	RSSI *float64 `json:"rssi,omitempty"`
+	RSRP *float64 `json:"rsrp,omitempty"`
+	RSRQ *float64 `json:"rsrq,omitempty"`
+	SINR *float64 `json:"sinr,omitempty"`
+}
+
+// CellInfo é a célula servidora. AreaCode é o TAC (LTE/5G) ou o LAC
+// (GSM/UMTS) e ARFCN o canal (EARFCN no LTE); a banda só é deduzida do
+// canal no LTE.
+type CellInfo struct {
+	Type         string `json:"type"`
+	OperatorCode string `json:"operator_code,omitempty"`
+	CellID       string `json:"cell_id,omitempty"`
+	AreaCode     string `json:"area_code,omitempty"`
+	PhysicalID   string `json:"physical_cell_id,omitempty"`
+	ARFCN        int    `json:"arfcn,omitempty"`
+	Band         string `json:"band,omitempty"`
+}
+
+// ModemReport é a resposta de GET /modems/{id}. O SIM e o bearer em uso
+// substituem os IDs que vêm no ModemInfo.
+type ModemReport struct {
+	*ModemInfo
+	*ModemDetails
+	SIM       *SIMInfo    `json:"sim"`
+	Bearer    *BearerInfo `json:"bearer"`
+	HTTPPort  int         `json:"http_port,omitempty"`
+	SOCKSPort int         `json:"socks_port,omitempty"`
+}
+
+// ReadModemReport junta tudo o que se sabe do modem. Só o GetModem e os
+// detalhes são obrigatórios; SIM e bearer ficam nil se não puderem ser lidos.
+func ReadModemReport(ctx context.Context, backend ModemBackend, modemID string) (*ModemReport, error) {
+	info, err := backend.GetModem(ctx, modemID)
+	if err != nil {
+		return nil, err
+	}
+
+	details, err := backend.GetModemDetails(ctx, modemID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ModemReport{ModemInfo: info, ModemDetails: details}
+
+	if info.SIM != "" {
+		if sim, err := backend.GetSIM(ctx, info.SIM); err == nil {
+			report.SIM = sim
+		}
+	}
+
+	if bearerID := info.CurrentBearer(); bearerID != "" {
+		if bearer, err := backend.GetBearer(ctx, bearerID); err == nil {
+			report.Bearer = bearer
+		}
+	}
+
+	if link, ok := linkRegistry.ByModem(modemID); ok {
+		report.HTTPPort = link.HTTPPort
+		report.SOCKSPort = link.SOCKSPort
+	}
+
+	return report, nil
+}
+
+// lteBands são as faixas de EARFCN de downlink (3GPP TS 36.101) das bandas
+// LTE mais comuns.
+var lteBands = []struct {
+	band     int
+	from, to int
+}{
+	{1, 0, 599}, {2, 600, 1199}, {3, 1200, 1949}, {4, 1950, 2399},
+	{5, 2400, 2649}, {7, 2750, 3449}, {8, 3450, 3799}, {12, 5010, 5179},
+	{13, 5180, 5279}, {14, 5280, 5379}, {17, 5730, 5849}, {20, 6150, 6449},
+	{25, 8040, 8689}, {26, 8690, 9039}, {28, 9210, 9659}, {38, 37750, 38249},
+	{40, 38650, 39649}, {41, 39650, 41589}, {42, 41590, 43589},
+	{66, 66436, 67335}, {71, 68586, 68935},
+}
+
+// lteBand retorna a banda do EARFCN ("B3"); vazio se fora da tabela.
+func lteBand(earfcn int) string {
+	for _, b := range lteBands {
+		if earfcn >= b.from && earfcn <= b.to {
+			return "B" + strconv.Itoa(b.band)
+		}
+	}
+	return ""
+}
+
+// fillBand completa a banda de uma célula LTE que veio só com o canal.
+func (c *CellInfo) fillBand() {
+	if c.Band == "" && c.Type == "lte" && c.ARFCN > 0 {
+		c.Band = lteBand(c.ARFCN)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// CONFIGURAÇÃO DE REDE (INTERFACE, ROTAS E NAT)
+// ============================================================================
+
+// NetworkConfigurator aplica no host a configuração de rede de um modem.
+type NetworkConfigurator interface {
+	ConfigureInterface(ctx context.Context, link ModemLink) error
+	ConfigureRouting(ctx context.Context, link ModemLink, previous ModemLink) error
+	RemoveRouting(ctx context.Context, link ModemLink) error
+	TestConnectivity(ctx context.Context, link ModemLink) error
+}
+
+// ProxyRestarter reaponta o proxy de um único modem para o novo IP ou o
+// para, quando o modem é removido.
+type ProxyRestarter interface {
+	Restart(ctx context.Context, link ModemLink) error
+	Stop(port int)
+}
+
+// newNetwork escolhe entre "ip" (comandos ip/iptables via sudo) e "noop"
+// (apenas registra no log). Com mode vazio, o padrão é noop com o backend
+// fake.
+func newNetwork(mode string, backend ModemBackend) NetworkConfigurator {
+	if mode == "" {
+		mode = "ip"
+		if backend.Name() == "fake" {
+			mode = "noop"
+		}
+	}
+
+	if mode == "noop" {
+		return noopNetwork{}
+	}
+	return ipCommandNetwork{}
+}
+
+type ipCommandNetwork struct{}
+
+func runPrivileged(ctx context.Context, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "sudo", append([]string{name}, args...)...)
+	metrics.Subprocess(name)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v - %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// runPrivilegedIgnore equivale ao "|| true" do script.
+func runPrivilegedIgnore(ctx context.Context, name string, args ...string) {
+	runPrivileged(ctx, name, args...)
+}
+
+func (ipCommandNetwork) ConfigureInterface(ctx context.Context, link ModemLink) error {
+	runPrivilegedIgnore(ctx, "ip", "addr", "flush", "dev", link.Interface)
+
+	address := fmt.Sprintf("%s/%d", link.IP, link.Prefix)
+	if err := runPrivileged(ctx, "ip", "addr", "add", address, "dev", link.Interface); err != nil {
+		return err
+	}
+	return runPrivileged(ctx, "ip", "link", "set", link.Interface, "up")
+}
+
+func (ipCommandNetwork) ConfigureRouting(ctx context.Context, link ModemLink, previous ModemLink) error {
+	table := strconv.Itoa(100 + link.Index)
+	metric := strconv.Itoa(10 + link.Index)
+	priority := strconv.Itoa(100 + link.Index)
+
+	// Rota padrão com métrica (fallback)
+	runPrivilegedIgnore(ctx, "ip", "route", "del", "default", "via", link.Gateway, "dev", link.Interface)
+	if err := runPrivileged(ctx, "ip", "route", "add", "default", "via", link.Gateway, "dev", link.Interface, "metric", metric); err != nil {
+		return err
+	}
+
+	// Tabela específica do modem
+	runPrivilegedIgnore(ctx, "ip", "route", "flush", "table", table)
+	if err := runPrivileged(ctx, "ip", "route", "add", "default", "via", link.Gateway, "dev", link.Interface, "table", table); err != nil {
+		return err
+	}
+
+	// Policy routing por IP de origem
+	if previous.IP != "" {
+		runPrivilegedIgnore(ctx, "ip", "rule", "del", "from", previous.IP, "table", table)
+	}
+	if err := runPrivileged(ctx, "ip", "rule", "add", "from", link.IP, "table", table, "priority", priority); err != nil {
+		return err
+	}
+
+	// NAT
+	if previous.Interface != "" {
+		runPrivilegedIgnore(ctx, "iptables", "-t", "nat", "-D", "POSTROUTING", "-o", previous.Interface, "-j", "MASQUERADE")
+	}
+	if err := runPrivileged(ctx, "iptables", "-t", "nat", "-A", "POSTROUTING", "-o", link.Interface, "-j", "MASQUERADE"); err != nil {
+		return err
+	}
+
+	runPrivilegedIgnore(ctx, "ip", "route", "flush", "cache")
+	return nil
+}
+
+// RemoveRouting desfaz a tabela, a regra e o NAT de um modem removido. A
+// interface normalmente já sumiu junto com as rotas dela, então os erros
+// são ignorados.
+func (ipCommandNetwork) RemoveRouting(ctx context.Context, link ModemLink) error {
+	table := strconv.Itoa(100 + link.Index)
+
+	if link.IP != "" {
+		runPrivilegedIgnore(ctx, "ip", "rule", "del", "from", link.IP, "table", table)
+	}
+	runPrivilegedIgnore(ctx, "ip", "route", "flush", "table", table)
+	if link.Interface != "" {
+		runPrivilegedIgnore(ctx, "ip", "route", "del", "default", "via", link.Gateway, "dev", link.Interface)
+		runPrivilegedIgnore(ctx, "iptables", "-t", "nat", "-D", "POSTROUTING", "-o", link.Interface, "-j", "MASQUERADE")
+	}
+	runPrivilegedIgnore(ctx, "ip", "route", "flush", "cache")
+	return nil
+}
+
+func (ipCommandNetwork) TestConnectivity(ctx context.Context, link ModemLink) error {
+	cmd := exec.CommandContext(ctx, "ping", "-I", link.Interface, "-c", "2", "-W", "5", "8.8.8.8")
+	metrics.Subprocess("ping")
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("sem conectividade pela interface %s: %v", link.Interface, err)
+	}
+	return nil
+}
+
+type noopNetwork struct{}
+
+func (noopNetwork) ConfigureInterface(ctx context.Context, link ModemLink) error {
+	log.Printf("🧪 [noop] ip addr add %s/%d dev %s", link.IP, link.Prefix, link.Interface)
+	return nil
+}
+
+func (noopNetwork) ConfigureRouting(ctx context.Context, link ModemLink, previous ModemLink) error {
+	log.Printf("🧪 [noop] rotas de %s via %s (tabela %d)", link.Interface, link.Gateway, 100+link.Index)
+	return nil
+}
+
+func (noopNetwork) RemoveRouting(ctx context.Context, link ModemLink) error {
+	log.Printf("🧪 [noop] rotas de %s removidas (tabela %d)", link.Interface, 100+link.Index)
+	return nil
+}
+
+func (noopNetwork) TestConnectivity(ctx context.Context, link ModemLink) error {
+	return nil
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// MAPA DE PORTAS POR IDENTIDADE DO MODEM
+// ============================================================================
+
+const (
+	PortIdentityIMEI  = "imei"
+	PortIdentityICCID = "iccid"
+)
+
+var portIdentityPattern = regexp.MustCompile(`^[0-9A-Za-z]{8,32}$`)
+
+// PortAssignment fixa as portas HTTP/SOCKS5 de um modem pela identidade do
+// hardware: o IMEI ou, se o modem não o informa, o ICCID do SIM. O índice
+// do ModemManager muda a cada reboot ou reconexão USB; a identidade não.
+type PortAssignment struct {
+	Identity  string    `json:"identity"`
+	Kind      string    `json:"kind"`
+	HTTPPort  int       `json:"http_port"`
+	SOCKSPort int       `json:"socks_port"`
+	Label     string    `json:"label,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PortAssignmentInput são os campos aceitos via API. No PUT, campos
+// omitidos mantêm o valor atual; só http_port leva a SOCKS5 junto, com o
+// mesmo deslocamento entre as portas base.
+type PortAssignmentInput struct {
+	Kind      *string `json:"kind"`
+	HTTPPort  *int    `json:"http_port"`
+	SOCKSPort *int    `json:"socks_port"`
+	Label     *string `json:"label"`
+}
+
+func (input PortAssignmentInput) apply(a *PortAssignment) {
+	ports := currentConfig().Ports
+	if input.Kind != nil {
+		a.Kind = strings.ToLower(strings.TrimSpace(*input.Kind))
+	}
+	if input.HTTPPort != nil {
+		a.HTTPPort = *input.HTTPPort
+		a.SOCKSPort = a.HTTPPort + (ports.BaseSOCKS - ports.BaseHTTP)
+	}
+	if input.SOCKSPort != nil {
+		a.SOCKSPort = *input.SOCKSPort
+	}
+	if input.Label != nil {
+		a.Label = strings.TrimSpace(*input.Label)
+	}
+}
+
+func (a *PortAssignment) validate() error {
+	ports := currentConfig().Ports
+	if !portIdentityPattern.MatchString(a.Identity) {
+		return fmt.Errorf("identidade inválida: %q", a.Identity)
+	}
+	if a.Kind != PortIdentityIMEI && a.Kind != PortIdentityICCID {
+		return fmt.Errorf("kind inválido: %s (use imei ou iccid)", a.Kind)
+	}
+	if a.HTTPPort <= ports.BaseHTTP || a.HTTPPort > ports.BaseHTTP+ports.MaxModems {
+		return fmt.Errorf("porta HTTP %d fora da faixa %d-%d", a.HTTPPort, ports.BaseHTTP+1, ports.BaseHTTP+ports.MaxModems)
+	}
+	if a.SOCKSPort <= ports.BaseSOCKS || a.SOCKSPort > ports.BaseSOCKS+ports.MaxModems {
+		return fmt.Errorf("porta SOCKS5 %d fora da faixa %d-%d", a.SOCKSPort, ports.BaseSOCKS+1, ports.BaseSOCKS+ports.MaxModems)
+	}
+	return nil
+}
+
+// identityKind deduz o tipo pelo tamanho: IMEI tem 15 dígitos, ICCID 19
+// ou 20.
+func identityKind(identity string) string {
+	if len(identity) == 15 {
+		return PortIdentityIMEI
+	}
+	return PortIdentityICCID
+}
+
+// PortMap guarda as atribuições em DATA_DIR/port-map.json. O
+// proxy-manager.sh lê e acrescenta atribuições no mesmo arquivo durante o
+// start, por isso ele é relido sempre que muda no disco.
+type PortMap struct {
+	mu       sync.Mutex
+	entries  map[string]*PortAssignment
+	path     string
+	loadedAt time.Time
+}
+
+var portMap = &PortMap{
+	entries: make(map[string]*PortAssignment),
+}
+
+func (pm *PortMap) SetDataDir(dir string) {
+	pm.mu.Lock()
+	pm.path = filepath.Join(dir, "port-map.json")
+	pm.mu.Unlock()
+}
+
+func (pm *PortMap) Load() error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.reload()
+}
+
+func (pm *PortMap) reload() error {
+	if pm.path == "" {
+		return nil
+	}
+
+	stat, err := os.Stat(pm.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao ler mapa de portas: %v", err)
+	}
+
+	var entries []*PortAssignment
+	if err := readJSONFile(pm.path, &entries); err != nil {
+		return fmt.Errorf("erro ao ler mapa de portas: %v", err)
+	}
+
+	pm.entries = make(map[string]*PortAssignment, len(entries))
+	for _, entry := range entries {
+		pm.entries[entry.Identity] = entry
+	}
+	pm.loadedAt = stat.ModTime()
+	return nil
+}
+
+// refresh relê o arquivo se o script o alterou desde a última leitura.
+func (pm *PortMap) refresh() {
+	if pm.path == "" {
+		return
+	}
+	stat, err := os.Stat(pm.path)
+	if err != nil || !stat.ModTime().After(pm.loadedAt) {
+		return
+	}
+	if err := pm.reload(); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+}
+
+func (pm *PortMap) save() error {
+	if pm.path == "" {
+		return nil
+	}
+
+	entries := make([]*PortAssignment, 0, len(pm.entries))
+	for _, entry := range pm.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].HTTPPort < entries[j].HTTPPort })
+
+	if err := writeJSONFile(pm.path, entries); err != nil {
+		return fmt.Errorf("erro ao salvar mapa de portas: %v", err)
+	}
+
+	if stat, err := os.Stat(pm.path); err == nil {
+		pm.loadedAt = stat.ModTime()
+	}
+	return nil
+}
+
+// conflict recusa portas já atribuídas a outra identidade, mesmo que o
+// modem dela não esteja conectado agora.
+func (pm *PortMap) conflict(a *PortAssignment) error {
+	for _, other := range pm.entries {
+		if other.Identity == a.Identity {
+			continue
+		}
+		if other.HTTPPort == a.HTTPPort || other.SOCKSPort == a.SOCKSPort {
+			return fmt.Errorf("porta %d/%d já atribuída a %s %s", other.HTTPPort, other.SOCKSPort, other.Kind, other.Identity)
+		}
+	}
+	return nil
+}
+
+// nextFree devolve o primeiro par de portas livre da faixa.
+func (pm *PortMap) nextFree() (int, int, bool) {
+	ports := currentConfig().Ports
+	for n := 1; n <= ports.MaxModems; n++ {
+		candidate := &PortAssignment{HTTPPort: ports.BaseHTTP + n, SOCKSPort: ports.BaseSOCKS + n}
+		if pm.conflict(candidate) == nil {
+			return candidate.HTTPPort, candidate.SOCKSPort, true
+		}
+	}
+	return 0, 0, false
+}
+
+func (pm *PortMap) All() []PortAssignment {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.refresh()
+
+	entries := make([]PortAssignment, 0, len(pm.entries))
+	for _, entry := range pm.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].HTTPPort < entries[j].HTTPPort })
+	return entries
+}
+
+func (pm *PortMap) Get(identity string) (PortAssignment, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.refresh()
+
+	entry, ok := pm.entries[identity]
+	if !ok {
+		return PortAssignment{}, false
+	}
+	return *entry, true
+}
+
+// Assign devolve as portas da identidade, atribuindo um par novo na
+// primeira vez que ela aparece. preferredHTTP (a porta que o modem já usa)
+// é mantida se estiver livre, para não mudar as portas de quem atualiza.
+func (pm *PortMap) Assign(identity, kind, modemID string, preferredHTTP int) (PortAssignment, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.refresh()
+
+	if entry, ok := pm.entries[identity]; ok {
+		return *entry, nil
+	}
+
+	ports := currentConfig().Ports
+	now := time.Now()
+	entry := &PortAssignment{
+		Identity:  identity,
+		Kind:      kind,
+		HTTPPort:  preferredHTTP,
+		SOCKSPort: preferredHTTP + (ports.BaseSOCKS - ports.BaseHTTP),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if preferredHTTP == 0 || entry.validate() != nil || pm.conflict(entry) != nil {
+		httpPort, socksPort, ok := pm.nextFree()
+		if !ok {
+			return PortAssignment{}, fmt.Errorf("sem portas livres para o modem %s (máximo: %d)", modemID, ports.MaxModems)
+		}
+		entry.HTTPPort, entry.SOCKSPort = httpPort, socksPort
+	}
+	if err := entry.validate(); err != nil {
+		return PortAssignment{}, err
+	}
+
+	pm.entries[identity] = entry
+	if err := pm.save(); err != nil {
+		delete(pm.entries, identity)
+		return PortAssignment{}, err
+	}
+
+	log.Printf("📌 Modem %s (%s %s) → HTTP:%d SOCKS:%d", modemID, kind, identity, entry.HTTPPort, entry.SOCKSPort)
+	return *entry, nil
+}
+
+// Set cria ou altera a atribuição de uma identidade, inclusive de modems
+// ainda não conectados.
+func (pm *PortMap) Set(identity string, input PortAssignmentInput) (PortAssignment, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.refresh()
+
+	now := time.Now()
+	current := pm.entries[identity]
+
+	var updated PortAssignment
+	if current != nil {
+		updated = *current
+	} else {
+		if input.HTTPPort == nil {
+			return PortAssignment{}, fmt.Errorf("informe http_port")
+		}
+		updated = PortAssignment{Identity: identity, Kind: identityKind(identity), CreatedAt: now}
+	}
+	input.apply(&updated)
+	updated.UpdatedAt = now
+
+	if err := updated.validate(); err != nil {
+		return PortAssignment{}, err
+	}
+	if err := pm.conflict(&updated); err != nil {
+		return PortAssignment{}, err
+	}
+
+	pm.entries[identity] = &updated
+	if err := pm.save(); err != nil {
+		if current != nil {
+			pm.entries[identity] = current
+		} else {
+			delete(pm.entries, identity)
+		}
+		return PortAssignment{}, err
+	}
+
+	log.Printf("📌 %s %s fixado em HTTP:%d SOCKS:%d", updated.Kind, identity, updated.HTTPPort, updated.SOCKSPort)
+	return updated, nil
+}
+
+// Delete libera as portas da identidade; o modem recebe o primeiro par
+// livre na próxima detecção.
+func (pm *PortMap) Delete(identity string) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.refresh()
+
+	entry, ok := pm.entries[identity]
+	if !ok {
+		return fmt.Errorf("identidade %s não está no mapa de portas", identity)
+	}
+
+	delete(pm.entries, identity)
+	if err := pm.save(); err != nil {
+		pm.entries[identity] = entry
+		return err
+	}
+
+	log.Printf("📌 Portas %d/%d de %s %s liberadas", entry.HTTPPort, entry.SOCKSPort, entry.Kind, identity)
+	return nil
+}
+
+// ModemIdentity lê a identidade estável do modem: o IMEI ou, na falta dele,
+// o ICCID do SIM.
+func ModemIdentity(ctx context.Context, backend ModemBackend, modemID string) (string, string, error) {
+	info, err := backend.GetModem(ctx, modemID)
+	if err != nil {
+		return "", "", err
+	}
+	if info.IMEI != "" {
+		return info.IMEI, PortIdentityIMEI, nil
+	}
+	if info.SIM != "" {
+		if sim, err := backend.GetSIM(ctx, info.SIM); err == nil && sim.ICCID != "" {
+			return sim.ICCID, PortIdentityICCID, nil
+		}
+	}
+	return "", "", fmt.Errorf("modem %s não informa IMEI nem ICCID", modemID)
+}
+
+// ResolveModemRef traduz a referência a um modem recebida pela API: o
+// índice atual vira a identidade; uma identidade vale como está, mesmo com
+// o modem desconectado.
+func ResolveModemRef(ctx context.Context, backend ModemBackend, ref string) (string, error) {
+	if portIdentityPattern.MatchString(ref) {
+		return ref, nil
+	}
+	identity, _, err := ModemIdentity(ctx, backend, ref)
+	return identity, err
+}
+
+// legacyModemRef informa se a referência é um índice do ModemManager, como
+// usuários e APNs fixados guardavam antes do mapa por identidade.
+func legacyModemRef(ref string) bool {
+	return ref != "" && !portIdentityPattern.MatchString(ref)
+}
+
+// migrateModemRefs traduz índices antigos para a identidade do modem que
+// está neles agora. Índices sem modem ficam de fora do resultado: quem chama
+// os mantém como estão e tenta de novo quando um modem aparece.
+func migrateModemRefs(ctx context.Context, backend ModemBackend, refs []string) map[string]string {
+	migrated := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		if _, ok := migrated[ref]; ok {
+			continue
+		}
+		if identity, _, err := ModemIdentity(ctx, backend, ref); err == nil {
+			migrated[ref] = identity
+		}
+	}
+	return migrated
+}
+
+// LocateModem encontra o índice atual do modem com a identidade, testando
+// primeiro o índice conhecido (hint).
+func LocateModem(ctx context.Context, backend ModemBackend, identity, hint string) (string, error) {
+	if hint != "" {
+		if current, _, err := ModemIdentity(ctx, backend, hint); err == nil && current == identity {
+			return hint, nil
+		}
+	}
+
+	modemIDs, err := backend.ListModems(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, modemID := range modemIDs {
+		if modemID == hint {
+			continue
+		}
+		if current, _, err := ModemIdentity(ctx, backend, modemID); err == nil && current == identity {
+			return modemID, nil
+		}
+	}
+	return "", fmt.Errorf("modem %s não encontrado", identity)
+}
+
+// PortMapEntry é a atribuição como a API mostra: com o índice do modem que
+// está usando as portas agora, se conectado.
+type PortMapEntry struct {
+	PortAssignment
+	ModemID string `json:"modem_id,omitempty"`
+}
+
+func portMapEntries() []PortMapEntry {
+	present := make(map[string]string)
+	for _, link := range linkRegistry.All() {
+		if link.Identity != "" {
+			present[link.Identity] = link.ModemID
+		}
+	}
+
+	assignments := portMap.All()
+	entries := make([]PortMapEntry, 0, len(assignments))
+	for _, assignment := range assignments {
+		entries = append(entries, PortMapEntry{PortAssignment: assignment, ModemID: present[assignment.Identity]})
+	}
+	return entries
+}
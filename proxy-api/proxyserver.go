@@ -0,0 +1,1029 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============================================================================
+// SERVIDOR DE PROXY EMBUTIDO (HTTP + SOCKS5)
+// ============================================================================
+
+// ProxyServer mantém um listener HTTP e um SOCKS5 por modem. As conexões de
+// saída partem do IP do bearer do modem, e o policy routing (ip rule from)
+// as envia pela interface certa, como o "-e<IP>" do 3proxy.
+type ProxyServer struct {
+	mu           sync.RWMutex
+	instances    map[int]*proxyInstance
+	bindHost     string
+	bindOutbound bool
+	nextConnID   uint64
+}
+
+type proxyInstance struct {
+	server *ProxyServer
+
+	mu   sync.RWMutex
+	link ModemLink
+
+	httpListener  net.Listener
+	socksListener net.Listener
+	httpServer    *http.Server
+	transport     *http.Transport
+
+	connsMu sync.Mutex
+	conns   map[uint64]*proxyConn
+
+	renewedAt   time.Time
+	totalConns  int64
+	errorCount  int64
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// proxyConn é o estado de uma conexão ativa, exposto pela API. Os bytes
+// também vão para o tráfego da porta e do usuário: pendingIn/pendingOut
+// acumulam o que ainda não foi somado e são descarregados a cada
+// proxyTrafficFoldInterval e quando a conexão fecha.
+type proxyConn struct {
+	ID        uint64    `json:"id"`
+	Protocol  string    `json:"protocol"`
+	Client    string    `json:"client"`
+	Target    string    `json:"target"`
+	StartedAt time.Time `json:"started_at"`
+	BytesIn   int64     `json:"bytes_in"`
+	BytesOut  int64     `json:"bytes_out"`
+
+	closer     io.Closer
+	port       int
+	user       string
+	pendingIn  int64
+	pendingOut int64
+}
+
+// ProxyStats resume um par de listeners de modem.
+type ProxyStats struct {
+	HTTPPort          int        `json:"http_port"`
+	SOCKSPort         int        `json:"socks_port"`
+	ModemID           string     `json:"modem_id"`
+	OutboundIP        string     `json:"outbound_ip"`
+	Running           bool       `json:"running"`
+	ActiveConnections int        `json:"active_connections"`
+	TotalConnections  int64      `json:"total_connections"`
+	Errors            int64      `json:"errors"`
+	LastError         string     `json:"last_error,omitempty"`
+	LastErrorAt       *time.Time `json:"last_error_at,omitempty"`
+}
+
+// proxyTrafficFoldInterval é de quanto em quanto tempo os bytes das conexões
+// abertas entram no tráfego; curto o bastante para túneis longos caírem na
+// hora em que passaram.
+const proxyTrafficFoldInterval = 5 * time.Second
+
+var proxyServer *ProxyServer
+
+func NewProxyServer(bindHost string, bindOutbound bool) *ProxyServer {
+	return &ProxyServer{
+		instances:    make(map[int]*proxyInstance),
+		bindHost:     bindHost,
+		bindOutbound: bindOutbound,
+	}
+}
+
+// Sync inicia listeners para links novos, atualiza o IP de saída dos
+// existentes e para os que saíram do registro.
+func (ps *ProxyServer) Sync(links []ModemLink) {
+	wanted := make(map[int]ModemLink)
+	for _, link := range links {
+		wanted[link.HTTPPort] = link
+	}
+
+	ps.mu.RLock()
+	current := make(map[int]*proxyInstance)
+	for port, inst := range ps.instances {
+		current[port] = inst
+	}
+	ps.mu.RUnlock()
+
+	for port, inst := range current {
+		link, ok := wanted[port]
+		if !ok {
+			ps.Stop(port)
+			continue
+		}
+		if inst.outboundIP() != link.IP || inst.currentLink().SOCKSPort != link.SOCKSPort {
+			ps.Restart(context.Background(), link)
+		}
+	}
+
+	for port, link := range wanted {
+		if _, ok := current[port]; !ok {
+			if err := ps.Start(link); err != nil {
+				log.Printf("❌ Proxy da porta %d: %v", port, err)
+			}
+		}
+	}
+}
+
+func (ps *ProxyServer) Start(link ModemLink) error {
+	inst := &proxyInstance{
+		server: ps,
+		link:   link,
+		conns:  make(map[uint64]*proxyConn),
+	}
+	inst.transport = &http.Transport{
+		DialContext:           inst.dialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 60 * time.Second,
+	}
+
+	httpListener, err := net.Listen("tcp", net.JoinHostPort(ps.bindHost, strconv.Itoa(link.HTTPPort)))
+	if err != nil {
+		return fmt.Errorf("erro ao escutar HTTP: %v", err)
+	}
+
+	socksListener, err := net.Listen("tcp", net.JoinHostPort(ps.bindHost, strconv.Itoa(link.SOCKSPort)))
+	if err != nil {
+		httpListener.Close()
+		return fmt.Errorf("erro ao escutar SOCKS5: %v", err)
+	}
+
+	inst.httpListener = httpListener
+	inst.socksListener = socksListener
+	inst.httpServer = &http.Server{
+		Handler:           proxyHandler(inst.pick),
+		ReadHeaderTimeout: 30 * time.Second,
+		ConnContext:       withClientConn,
+	}
+
+	ps.mu.Lock()
+	ps.instances[link.HTTPPort] = inst
+	ps.mu.Unlock()
+
+	go inst.httpServer.Serve(httpListener)
+	go inst.serveSOCKS()
+
+	log.Printf("🌐 Proxy modem %s | HTTP:%d SOCKS5:%d | saída %s", link.ModemID, link.HTTPPort, link.SOCKSPort, link.IP)
+	return nil
+}
+
+func (ps *ProxyServer) Stop(port int) {
+	ps.mu.Lock()
+	inst, ok := ps.instances[port]
+	delete(ps.instances, port)
+	ps.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	inst.httpServer.Close()
+	inst.socksListener.Close()
+	inst.closeConnections()
+	inst.transport.CloseIdleConnections()
+
+	log.Printf("🛑 Proxy da porta %d parado", port)
+}
+
+// Restart troca o IP de saída do modem e derruba as conexões abertas, que
+// dependiam do IP antigo. Implementa ProxyRestarter para o orquestrador.
+func (ps *ProxyServer) Restart(ctx context.Context, link ModemLink) error {
+	ps.mu.RLock()
+	inst, ok := ps.instances[link.HTTPPort]
+	ps.mu.RUnlock()
+
+	if !ok || inst.currentLink().SOCKSPort != link.SOCKSPort {
+		ps.Stop(link.HTTPPort)
+		return ps.Start(link)
+	}
+
+	inst.mu.Lock()
+	if inst.link.IP != link.IP {
+		inst.renewedAt = time.Now()
+	}
+	inst.link = link
+	inst.mu.Unlock()
+
+	inst.closeConnections()
+	inst.transport.CloseIdleConnections()
+
+	log.Printf("🔁 Proxy da porta %d agora sai por %s", link.HTTPPort, link.IP)
+	return nil
+}
+
+func (ps *ProxyServer) instance(port int) (*proxyInstance, bool) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	if inst, ok := ps.instances[port]; ok {
+		return inst, true
+	}
+	for _, inst := range ps.instances {
+		if inst.currentLink().SOCKSPort == port {
+			return inst, true
+		}
+	}
+	return nil, false
+}
+
+// Running aceita a porta HTTP ou a SOCKS5.
+func (ps *ProxyServer) Running(port int) bool {
+	_, ok := ps.instance(port)
+	return ok
+}
+
+// localAddress é o endereço para conectar na porta a partir do próprio host.
+func (ps *ProxyServer) localAddress(port int) string {
+	host := ps.bindHost
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+func (ps *ProxyServer) Stats(port int) (ProxyStats, bool) {
+	inst, ok := ps.instance(port)
+	if !ok {
+		return ProxyStats{}, false
+	}
+	return inst.stats(), true
+}
+
+// All devolve as instâncias ativas ordenadas pela porta HTTP.
+func (ps *ProxyServer) All() []*proxyInstance {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	instances := make([]*proxyInstance, 0, len(ps.instances))
+	for _, inst := range ps.instances {
+		instances = append(instances, inst)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].currentLink().HTTPPort < instances[j].currentLink().HTTPPort
+	})
+	return instances
+}
+
+// Run soma ao tráfego, a cada proxyTrafficFoldInterval, os bytes pendentes
+// das conexões abertas.
+func (ps *ProxyServer) Run() {
+	ticker := time.NewTicker(proxyTrafficFoldInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ps.FoldTraffic()
+	}
+}
+
+// FoldTraffic soma ao tráfego os bytes pendentes de todas as conexões.
+func (ps *ProxyServer) FoldTraffic() {
+	for _, inst := range ps.All() {
+		inst.connsMu.Lock()
+		conns := make([]*proxyConn, 0, len(inst.conns))
+		for _, c := range inst.conns {
+			conns = append(conns, c)
+		}
+		inst.connsMu.Unlock()
+
+		for _, c := range conns {
+			c.foldTraffic()
+		}
+	}
+}
+
+func (ps *ProxyServer) Connections(port int) ([]proxyConn, bool) {
+	inst, ok := ps.instance(port)
+	if !ok {
+		return nil, false
+	}
+
+	inst.connsMu.Lock()
+	defer inst.connsMu.Unlock()
+
+	conns := make([]proxyConn, 0, len(inst.conns))
+	for _, c := range inst.conns {
+		conns = append(conns, proxyConn{
+			ID:        c.ID,
+			Protocol:  c.Protocol,
+			Client:    c.Client,
+			Target:    c.Target,
+			StartedAt: c.StartedAt,
+			BytesIn:   atomic.LoadInt64(&c.BytesIn),
+			BytesOut:  atomic.LoadInt64(&c.BytesOut),
+		})
+	}
+	return conns, true
+}
+
+// ============================================================================
+// SERVIDOR DE PROXY - ESTADO DA INSTÂNCIA
+// ============================================================================
+
+func (inst *proxyInstance) currentLink() ModemLink {
+	inst.mu.RLock()
+	defer inst.mu.RUnlock()
+	return inst.link
+}
+
+func (inst *proxyInstance) outboundIP() string {
+	return inst.currentLink().IP
+}
+
+func (inst *proxyInstance) lastRenewed() time.Time {
+	inst.mu.RLock()
+	defer inst.mu.RUnlock()
+	return inst.renewedAt
+}
+
+func (inst *proxyInstance) activeConnections() int {
+	inst.connsMu.Lock()
+	defer inst.connsMu.Unlock()
+	return len(inst.conns)
+}
+
+func (inst *proxyInstance) stats() ProxyStats {
+	link := inst.currentLink()
+
+	inst.connsMu.Lock()
+	defer inst.connsMu.Unlock()
+
+	var lastErrorAt *time.Time
+	if !inst.lastErrorAt.IsZero() {
+		at := inst.lastErrorAt
+		lastErrorAt = &at
+	}
+
+	return ProxyStats{
+		HTTPPort:          link.HTTPPort,
+		SOCKSPort:         link.SOCKSPort,
+		ModemID:           link.ModemID,
+		OutboundIP:        link.IP,
+		Running:           true,
+		ActiveConnections: len(inst.conns),
+		TotalConnections:  inst.totalConns,
+		Errors:            inst.errorCount,
+		LastError:         inst.lastError,
+		LastErrorAt:       lastErrorAt,
+	}
+}
+
+func (inst *proxyInstance) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+	if ip := net.ParseIP(inst.outboundIP()); ip != nil && inst.server.bindOutbound {
+		if strings.HasPrefix(network, "udp") {
+			dialer.LocalAddr = &net.UDPAddr{IP: ip}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// track registra a conexão e conta uma requisição para o usuário.
+func (inst *proxyInstance) track(protocol, client, target, user string, closer io.Closer) *proxyConn {
+	c := &proxyConn{
+		ID:        atomic.AddUint64(&inst.server.nextConnID, 1),
+		Protocol:  protocol,
+		Client:    client,
+		Target:    target,
+		StartedAt: time.Now(),
+		closer:    closer,
+		port:      inst.currentLink().HTTPPort,
+		user:      user,
+	}
+
+	inst.connsMu.Lock()
+	inst.conns[c.ID] = c
+	inst.totalConns++
+	inst.connsMu.Unlock()
+
+	trafficStats.Add(c.port, user, TrafficCounters{Requests: 1})
+	return c
+}
+
+// countConnection conta uma conexão de cliente atendida pelo modem.
+func (inst *proxyInstance) countConnection(user string) {
+	trafficStats.Add(inst.currentLink().HTTPPort, user, TrafficCounters{Connections: 1})
+}
+
+func (c *proxyConn) addIn(n int64) {
+	atomic.AddInt64(&c.BytesIn, n)
+	atomic.AddInt64(&c.pendingIn, n)
+}
+
+func (c *proxyConn) addOut(n int64) {
+	atomic.AddInt64(&c.BytesOut, n)
+	atomic.AddInt64(&c.pendingOut, n)
+}
+
+// foldTraffic soma ao tráfego os bytes acumulados desde a última vez.
+func (c *proxyConn) foldTraffic() {
+	in := atomic.SwapInt64(&c.pendingIn, 0)
+	out := atomic.SwapInt64(&c.pendingOut, 0)
+	if in != 0 || out != 0 {
+		trafficStats.Add(c.port, c.user, TrafficCounters{BytesIn: in, BytesOut: out})
+	}
+}
+
+// countingWriter repassa cada escrita ao contador da conexão, sem travas:
+// o tráfego global só é atualizado em foldTraffic.
+type countingWriter struct {
+	w     io.Writer
+	count func(int64)
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	if n > 0 {
+		cw.count(int64(n))
+	}
+	return n, err
+}
+
+func (inst *proxyInstance) untrack(c *proxyConn) {
+	inst.connsMu.Lock()
+	delete(inst.conns, c.ID)
+	inst.connsMu.Unlock()
+
+	c.foldTraffic()
+}
+
+func (inst *proxyInstance) recordError(protocol, target string, err error) {
+	inst.connsMu.Lock()
+	inst.errorCount++
+	inst.lastError = fmt.Sprintf("%s %s: %v", protocol, target, err)
+	inst.lastErrorAt = time.Now()
+	inst.connsMu.Unlock()
+}
+
+func (inst *proxyInstance) closeConnections() {
+	inst.connsMu.Lock()
+	defer inst.connsMu.Unlock()
+
+	for _, c := range inst.conns {
+		if c.closer != nil {
+			c.closer.Close()
+		}
+	}
+}
+
+// ============================================================================
+// SERVIDOR DE PROXY - HTTP
+// ============================================================================
+
+var hopByHopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate",
+	"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// proxyCredentials são o usuário e a senha enviados ao proxy (header
+// Proxy-Authorization ou autenticação SOCKS5). User é preenchido pela
+// autenticação quando há usuários cadastrados.
+type proxyCredentials struct {
+	Username string
+	Password string
+	User     *ProxyUser
+}
+
+// authorize autentica as credenciais. Sem usuários cadastrados o acesso
+// continua livre, como o "auth none" do 3proxy.
+func (creds *proxyCredentials) authorize() error {
+	if !userStore.Enabled() || healthChecker.isProbe(*creds) {
+		return nil
+	}
+
+	username, _ := parseProxyUsername(creds.Username)
+	if username == "" {
+		return errProxyAuthRequired
+	}
+
+	user, err := userStore.Authenticate(username, creds.Password)
+	if err != nil {
+		return err
+	}
+	creds.User = user
+	return nil
+}
+
+func (creds proxyCredentials) allowed(inst *proxyInstance) bool {
+	return creds.User == nil || creds.User.CanUse(inst.currentLink())
+}
+
+func (creds proxyCredentials) owner() string {
+	if creds.User == nil {
+		return ""
+	}
+	return creds.User.Username
+}
+
+// clientConnKey guarda no contexto das requisições HTTP a conexão do
+// cliente, para o tráfego contar conexões e não requisições.
+type clientConnKey struct{}
+
+type clientConn struct {
+	mu      sync.Mutex
+	counted map[*proxyInstance]bool
+}
+
+func withClientConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, clientConnKey{}, &clientConn{counted: make(map[*proxyInstance]bool)})
+}
+
+// firstRequest informa se é a primeira requisição da conexão do cliente
+// pelo modem (pelo gateway, requisições seguidas podem sair por modems
+// diferentes).
+func firstRequest(ctx context.Context, inst *proxyInstance) bool {
+	cc, ok := ctx.Value(clientConnKey{}).(*clientConn)
+	if !ok {
+		return true
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.counted[inst] {
+		return false
+	}
+	cc.counted[inst] = true
+	return true
+}
+
+// pickFunc escolhe o modem de saída de uma conexão.
+type pickFunc func(creds proxyCredentials) (*proxyInstance, error)
+
+// pick usa o próprio modem, exceto quando a sessão do usuário já está fixada
+// em outro. Se este modem estiver indisponível, a sessão vai para o gateway.
+func (inst *proxyInstance) pick(creds proxyCredentials) (*proxyInstance, error) {
+	if !creds.allowed(inst) {
+		return nil, errProxyForbidden
+	}
+
+	_, session := parseProxyUsername(creds.Username)
+	if session == "" {
+		return inst, nil
+	}
+
+	return sessionManager.Resolve(creds.owner(), session, creds.allowed, func() (*proxyInstance, error) {
+		if unavailableReason(inst) == "" {
+			return inst, nil
+		}
+		return gateway.next(creds.allowed)
+	})
+}
+
+func proxyHandler(pick pickFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var creds proxyCredentials
+		if username, password, ok := parseProxyAuthorization(r.Header.Get("Proxy-Authorization")); ok {
+			creds = proxyCredentials{Username: username, Password: password}
+		}
+
+		if err := creds.authorize(); err != nil {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="proxy-api"`)
+			http.Error(w, err.Error(), http.StatusProxyAuthRequired)
+			return
+		}
+
+		inst, err := pick(creds)
+		if errors.Is(err, errProxyForbidden) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		if firstRequest(r.Context(), inst) {
+			inst.countConnection(creds.owner())
+		}
+		inst.serveHTTP(w, r, creds.owner())
+	})
+}
+
+func parseProxyAuthorization(header string) (username, password string, ok bool) {
+	const prefix = "Basic "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+
+	username, password, ok = strings.Cut(string(decoded), ":")
+	return username, password, ok
+}
+
+func (inst *proxyInstance) serveHTTP(w http.ResponseWriter, r *http.Request, user string) {
+	if r.Method == http.MethodConnect {
+		inst.serveConnect(w, r, user)
+		return
+	}
+
+	if !r.URL.IsAbs() {
+		http.Error(w, "requisição de proxy exige URL absoluta", http.StatusBadRequest)
+		return
+	}
+
+	c := inst.track("HTTP", r.RemoteAddr, r.URL.Host, user, nil)
+	defer inst.untrack(c)
+
+	outReq := r.Clone(r.Context())
+	outReq.RequestURI = ""
+	for _, header := range hopByHopHeaders {
+		outReq.Header.Del(header)
+	}
+	if r.ContentLength > 0 {
+		c.addOut(r.ContentLength)
+	}
+
+	resp, err := inst.transport.RoundTrip(outReq)
+	if err != nil {
+		inst.recordError("HTTP", r.URL.Host, err)
+		http.Error(w, "erro no upstream: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, header := range hopByHopHeaders {
+		resp.Header.Del(header)
+	}
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	io.Copy(countingWriter{w: w, count: c.addIn}, resp.Body)
+}
+
+func (inst *proxyInstance) serveConnect(w http.ResponseWriter, r *http.Request, user string) {
+	target := r.Host
+
+	upstream, err := inst.dialContext(r.Context(), "tcp", target)
+	if err != nil {
+		inst.recordError("CONNECT", target, err)
+		http.Error(w, "erro ao conectar no destino: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "hijack não suportado", http.StatusInternalServerError)
+		return
+	}
+
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+
+	client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+	c := inst.track("CONNECT", r.RemoteAddr, target, user, client)
+	defer inst.untrack(c)
+
+	var clientReader io.Reader = client
+	if buffered != nil && buffered.Reader.Buffered() > 0 {
+		clientReader = io.MultiReader(buffered.Reader, client)
+	}
+
+	relay(client, clientReader, upstream, c)
+}
+
+// relay copia nos dois sentidos até um lado fechar, contando os bytes.
+func relay(client net.Conn, clientReader io.Reader, upstream net.Conn, c *proxyConn) {
+	done := make(chan struct{}, 2)
+
+	go func() {
+		io.Copy(countingWriter{w: upstream, count: c.addOut}, clientReader)
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		io.Copy(countingWriter{w: client, count: c.addIn}, upstream)
+		closeWrite(client)
+		done <- struct{}{}
+	}()
+
+	<-done
+	<-done
+	client.Close()
+	upstream.Close()
+}
+
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// ============================================================================
+// SERVIDOR DE PROXY - SOCKS5 (RFC 1928)
+// ============================================================================
+
+const (
+	socksVersion         = 0x05
+	socksMethodNoAuth    = 0x00
+	socksMethodUserPass  = 0x02
+	socksAuthVersion     = 0x01
+	socksMethodNone      = 0xFF
+	socksCmdConnect      = 0x01
+	socksAtypIPv4        = 0x01
+	socksAtypDomain      = 0x03
+	socksAtypIPv6        = 0x04
+	socksReplySuccess    = 0x00
+	socksReplyFailure    = 0x01
+	socksReplyNotAllowed = 0x02
+	socksReplyNetUnreac  = 0x03
+	socksReplyHostUnrea  = 0x04
+	socksReplyRefused    = 0x05
+	socksReplyCmdUnsup   = 0x07
+	socksReplyAtypUnsup  = 0x08
+)
+
+func (inst *proxyInstance) serveSOCKS() {
+	for {
+		conn, err := inst.socksListener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go handleSOCKS(conn, inst.pick)
+	}
+}
+
+// handleSOCKS atende uma conexão SOCKS5. pick escolhe o modem de saída
+// depois da negociação, o que permite ao gateway reaproveitar o fluxo.
+func handleSOCKS(client net.Conn, pick pickFunc) {
+	defer client.Close()
+
+	client.SetDeadline(time.Now().Add(30 * time.Second))
+	reader := bufio.NewReader(client)
+
+	creds, err := socksNegotiate(reader, client)
+	if err != nil {
+		return
+	}
+
+	target, err := socksReadRequest(reader)
+	if err != nil {
+		var reply socksReplyError
+		if errors.As(err, &reply) {
+			socksReply(client, byte(reply), nil)
+		}
+		return
+	}
+
+	inst, err := pick(creds)
+	if errors.Is(err, errProxyForbidden) {
+		socksReply(client, socksReplyNotAllowed, nil)
+		return
+	}
+	if err != nil {
+		socksReply(client, socksReplyFailure, nil)
+		return
+	}
+
+	upstream, err := inst.dialContext(context.Background(), "tcp", target)
+	if err != nil {
+		inst.recordError("SOCKS5", target, err)
+		socksReply(client, socksDialReply(err), nil)
+		return
+	}
+
+	socksReply(client, socksReplySuccess, upstream.LocalAddr())
+	client.SetDeadline(time.Time{})
+
+	inst.countConnection(creds.owner())
+	c := inst.track("SOCKS5", client.RemoteAddr().String(), target, creds.owner(), client)
+	defer inst.untrack(c)
+
+	relay(client, reader, upstream, c)
+}
+
+type socksReplyError byte
+
+func (e socksReplyError) Error() string {
+	return fmt.Sprintf("socks5: resposta %d", byte(e))
+}
+
+// socksNegotiate trata a saudação inicial. Usuário e senha (RFC 1929) têm
+// preferência, pois carregam a chave de sessão; sem eles, aceita conexão
+// sem autenticação apenas se não houver usuários cadastrados.
+func socksNegotiate(reader *bufio.Reader, client net.Conn) (proxyCredentials, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return proxyCredentials{}, err
+	}
+	if header[0] != socksVersion {
+		return proxyCredentials{}, fmt.Errorf("versão SOCKS não suportada: %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return proxyCredentials{}, err
+	}
+
+	if bytes.IndexByte(methods, socksMethodUserPass) >= 0 {
+		if _, err := client.Write([]byte{socksVersion, socksMethodUserPass}); err != nil {
+			return proxyCredentials{}, err
+		}
+		creds, err := socksReadCredentials(reader)
+		if err == nil {
+			err = creds.authorize()
+		}
+		if err != nil {
+			client.Write([]byte{socksAuthVersion, 0x01})
+			return proxyCredentials{}, err
+		}
+		_, err = client.Write([]byte{socksAuthVersion, 0x00})
+		return creds, err
+	}
+
+	if bytes.IndexByte(methods, socksMethodNoAuth) >= 0 && !userStore.Enabled() {
+		_, err := client.Write([]byte{socksVersion, socksMethodNoAuth})
+		return proxyCredentials{}, err
+	}
+
+	client.Write([]byte{socksVersion, socksMethodNone})
+	return proxyCredentials{}, fmt.Errorf("nenhum método de autenticação aceito")
+}
+
+func socksReadCredentials(reader *bufio.Reader) (proxyCredentials, error) {
+	version, err := reader.ReadByte()
+	if err != nil {
+		return proxyCredentials{}, err
+	}
+	if version != socksAuthVersion {
+		return proxyCredentials{}, fmt.Errorf("versão de autenticação SOCKS não suportada: %d", version)
+	}
+
+	readField := func() (string, error) {
+		length, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		field := make([]byte, length)
+		if _, err := io.ReadFull(reader, field); err != nil {
+			return "", err
+		}
+		return string(field), nil
+	}
+
+	username, err := readField()
+	if err != nil {
+		return proxyCredentials{}, err
+	}
+	password, err := readField()
+	if err != nil {
+		return proxyCredentials{}, err
+	}
+	return proxyCredentials{Username: username, Password: password}, nil
+}
+
+func socksReadRequest(reader *bufio.Reader) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("versão SOCKS não suportada: %d", header[0])
+	}
+	if header[1] != socksCmdConnect {
+		return "", socksReplyError(socksReplyCmdUnsup)
+	}
+
+	var host string
+	switch header[3] {
+	case socksAtypIPv4:
+		addr := make([]byte, 4)
+		if _, err := io.ReadFull(reader, addr); err != nil {
+			return "", err
+		}
+		host = net.IP(addr).String()
+	case socksAtypIPv6:
+		addr := make([]byte, 16)
+		if _, err := io.ReadFull(reader, addr); err != nil {
+			return "", err
+		}
+		host = net.IP(addr).String()
+	case socksAtypDomain:
+		length, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		domain := make([]byte, length)
+		if _, err := io.ReadFull(reader, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", socksReplyError(socksReplyAtypUnsup)
+	}
+
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(reader, portBytes); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(portBytes)))), nil
+}
+
+func socksReply(client net.Conn, code byte, bound net.Addr) {
+	reply := []byte{socksVersion, code, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0}
+
+	if tcp, ok := bound.(*net.TCPAddr); ok {
+		if ip4 := tcp.IP.To4(); ip4 != nil {
+			copy(reply[4:8], ip4)
+		} else {
+			reply = append([]byte{socksVersion, code, 0x00, socksAtypIPv6}, tcp.IP.To16()...)
+			reply = append(reply, 0, 0)
+		}
+		binary.BigEndian.PutUint16(reply[len(reply)-2:], uint16(tcp.Port))
+	}
+
+	client.Write(reply)
+}
+
+func socksDialReply(err error) byte {
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "refused"):
+		return socksReplyRefused
+	case strings.Contains(message, "no such host"):
+		return socksReplyHostUnrea
+	case strings.Contains(message, "unreachable"):
+		return socksReplyNetUnreac
+	default:
+		return socksReplyFailure
+	}
+}
+
+// ============================================================================
+// SERVIDOR DE PROXY - SINCRONIZAÇÃO COM OS MODEMS
+// ============================================================================
+
+// startProxySync mantém os listeners alinhados ao registro de modems, que
+// muda quando o script reinicia o sistema ou um modem é renovado.
+func startProxySync() {
+	syncProxies()
+
+	ticker := time.NewTicker(PROXY_SYNC_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		syncProxies()
+	}
+}
+
+func syncProxies() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	linkRegistry.Refresh(ctx)
+
+	// Portas em renovação são reapontadas pelo próprio orquestrador. Links
+	// sem IP (modem recém-conectado ainda sem bearer) não ganham proxy:
+	// sairiam pela rota padrão da máquina.
+	links := make([]ModemLink, 0)
+	for _, link := range linkRegistry.All() {
+		if jobManager.Renewing(link.HTTPPort) {
+			if current, ok := proxyServer.Stats(link.HTTPPort); ok {
+				link.IP = current.OutboundIP
+			}
+		}
+		if link.IP == "" {
+			continue
+		}
+		links = append(links, link)
+	}
+	proxyServer.Sync(links)
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ============================================================================
+// RENOVAÇÃO DE IP - ORQUESTRADOR
+// ============================================================================
+
+// RenewTimings são as esperas do renew_ip_by_port do script.
+type RenewTimings struct {
+	PowerWait   time.Duration
+	ReleaseWait time.Duration
+	SettleWait  time.Duration
+}
+
+// RenewOptions controla as tentativas. Com RejectRecent > 0 o novo IP
+// público é recusado se estiver entre os últimos N vistos no modem; com
+// RejectInUse, se outro modem estiver saindo por ele. Cada recusa repete a
+// sequência inteira, até MaxIPRetries vezes. JobID vai para o histórico de
+// IPs.
+type RenewOptions struct {
+	MaxAttempts  int
+	RejectRecent int
+	RejectInUse  bool
+	MaxIPRetries int
+	JobID        string
+}
+
+// withDefaults preenche os limites não informados.
+func (opts RenewOptions) withDefaults() RenewOptions {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = RENEW_MAX_ATTEMPTS
+	}
+	if opts.MaxIPRetries <= 0 && (opts.RejectRecent > 0 || opts.RejectInUse) {
+		opts.MaxIPRetries = RENEW_MAX_IP_RETRIES
+	}
+	return opts
+}
+
+const (
+	RejectReasonRecent = "recent"
+	RejectReasonInUse  = "in-use"
+)
+
+// IPRejection registra um IP público recusado e o motivo.
+type IPRejection struct {
+	Attempt int    `json:"attempt"`
+	IP      string `json:"ip"`
+	Reason  string `json:"reason"`
+	Detail  string `json:"detail"`
+}
+
+func (r *IPRejection) Error() string {
+	return r.Detail
+}
+
+type RenewResult struct {
+	Port         int    `json:"port"`
+	ModemID      string `json:"modem_id"`
+	OldInterface string `json:"old_interface"`
+	NewInterface string `json:"new_interface"`
+	OldIP        string `json:"old_ip"`
+	NewIP        string `json:"new_ip"`
+	OldPublicIP  string `json:"old_public_ip"`
+	NewPublicIP  string `json:"new_public_ip"`
+	Attempts     int    `json:"attempts"`
+	Changed      bool   `json:"changed"`
+
+	Rejections []IPRejection `json:"rejections,omitempty"`
+}
+
+// StepError identifica em qual etapa e tentativa a renovação falhou.
+type StepError struct {
+	Step    string
+	Attempt int
+	Err     error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("etapa %s (tentativa %d): %v", e.Step, e.Attempt, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// JobStep é o registro de uma execução de etapa, exposto no job.
+type JobStep struct {
+	Name     string `json:"name"`
+	Attempt  int    `json:"attempt"`
+	Try      int    `json:"try"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RenewReporter recebe o progresso da renovação (normalmente um job).
+type RenewReporter interface {
+	Logf(format string, args ...interface{})
+	StepFinished(step JobStep)
+}
+
+type renewStep struct {
+	name       string
+	timeout    time.Duration
+	retries    int
+	retryDelay time.Duration
+	optional   bool // falha não interrompe a tentativa, como o "|| true" do script
+	run        func(ctx context.Context, run *renewRun) error
+}
+
+type renewRun struct {
+	attempt  int
+	previous ModemLink
+	link     ModemLink
+	publicIP string
+	recent   []string
+	reporter RenewReporter
+}
+
+type RenewOrchestrator struct {
+	backend ModemBackend
+	network NetworkConfigurator
+	proxies ProxyRestarter
+	links   *LinkRegistry
+	timings RenewTimings
+}
+
+var renewOrchestrator *RenewOrchestrator
+
+// defaultRenewTimings usa as esperas do script; com o backend fake, que
+// reage na hora, as esperas são encurtadas. network.renew_release_wait
+// (RENEW_RELEASE_WAIT) sobrescreve a espera de liberação do IP.
+func defaultRenewTimings(backend ModemBackend) RenewTimings {
+	timings := RenewTimings{
+		PowerWait:   5 * time.Second,
+		ReleaseWait: 20 * time.Second,
+		SettleWait:  10 * time.Second,
+	}
+	if backend.Name() == "fake" {
+		timings = RenewTimings{
+			PowerWait:   200 * time.Millisecond,
+			ReleaseWait: time.Second,
+			SettleWait:  200 * time.Millisecond,
+		}
+	}
+
+	if wait := currentConfig().Network.RenewReleaseWait; wait > 0 {
+		timings.ReleaseWait = time.Duration(wait)
+	}
+	return timings
+}
+
+func NewRenewOrchestrator(backend ModemBackend, network NetworkConfigurator, proxies ProxyRestarter, links *LinkRegistry) *RenewOrchestrator {
+	return &RenewOrchestrator{
+		backend: backend,
+		network: network,
+		proxies: proxies,
+		links:   links,
+		timings: defaultRenewTimings(backend),
+	}
+}
+
+func waitStep(name string, d time.Duration) renewStep {
+	return renewStep{
+		name:    name,
+		timeout: d + time.Second,
+		run: func(ctx context.Context, run *renewRun) error {
+			return sleepContext(ctx, d)
+		},
+	}
+}
+
+// steps segue a sequência do renew_ip_by_port: desconectar, baixo consumo,
+// aguardar liberação, religar, localizar o modem (o religamento pode
+// renumerá-lo), conectar, ler bearer, interface, rotas, conectividade,
+// proxy e IP público. fresh-ip recusa IPs repetidos conforme
+// opts.
+func (o *RenewOrchestrator) steps(opts RenewOptions) []renewStep {
+	steps := []renewStep{
+		{
+			name: "disconnect", timeout: 30 * time.Second, optional: true,
+			run: func(ctx context.Context, run *renewRun) error {
+				return o.backend.Disconnect(ctx, run.link.ModemID)
+			},
+		},
+		{
+			name: "power-low", timeout: 30 * time.Second, optional: true,
+			run: func(ctx context.Context, run *renewRun) error {
+				return o.backend.SetPowerState(ctx, run.link.ModemID, PowerStateLow)
+			},
+		},
+		waitStep("wait-power-low", o.timings.PowerWait),
+		waitStep("wait-release", o.timings.ReleaseWait),
+		{
+			name: "power-on", timeout: 30 * time.Second, optional: true,
+			run: func(ctx context.Context, run *renewRun) error {
+				return o.backend.SetPowerState(ctx, run.link.ModemID, PowerStateOn)
+			},
+		},
+		waitStep("wait-power-on", o.timings.PowerWait),
+		{
+			name: "locate-modem", timeout: 15 * time.Second, retries: 3, retryDelay: 2 * time.Second,
+			run: func(ctx context.Context, run *renewRun) error {
+				return o.locate(ctx, &run.link)
+			},
+		},
+		{
+			name: "connect", timeout: 60 * time.Second,
+			run: func(ctx context.Context, run *renewRun) error {
+				apn := ResolveModemAPN(ctx, o.backend, run.link.ModemID)
+				run.reporter.Logf("APN %s (%s)", apn.Params.APN, apn.describe())
+				_, err := o.backend.Connect(ctx, run.link.ModemID, apn.Params)
+				return err
+			},
+		},
+		waitStep("wait-settle", o.timings.SettleWait),
+		{
+			name: "read-bearer", timeout: 15 * time.Second, retries: 3, retryDelay: 2 * time.Second,
+			run: func(ctx context.Context, run *renewRun) error {
+				return o.readBearer(ctx, run)
+			},
+		},
+		{
+			name: "configure-interface", timeout: 15 * time.Second,
+			run: func(ctx context.Context, run *renewRun) error {
+				return o.network.ConfigureInterface(ctx, run.link)
+			},
+		},
+		{
+			name: "configure-routing", timeout: 20 * time.Second,
+			run: func(ctx context.Context, run *renewRun) error {
+				return o.network.ConfigureRouting(ctx, run.link, run.previous)
+			},
+		},
+		{
+			name: "connectivity", timeout: 20 * time.Second, retries: 1, retryDelay: 3 * time.Second,
+			run: func(ctx context.Context, run *renewRun) error {
+				return o.network.TestConnectivity(ctx, run.link)
+			},
+		},
+		{
+			name: "restart-proxy", timeout: 15 * time.Second,
+			run: func(ctx context.Context, run *renewRun) error {
+				return o.proxies.Restart(ctx, run.link)
+			},
+		},
+		{
+			name: "public-ip", timeout: 20 * time.Second, retries: 2, retryDelay: 3 * time.Second,
+			run: func(ctx context.Context, run *renewRun) error {
+				ip := resolvePublicIP(ctx, run.link.ModemID, run.link.HTTPPort)
+				if ip == "N/A" || ip == "" {
+					return fmt.Errorf("não foi possível obter IP público")
+				}
+				run.publicIP = ip
+				return nil
+			},
+		},
+	}
+
+	if opts.RejectRecent > 0 || opts.RejectInUse {
+		steps = append(steps, renewStep{
+			name: "fresh-ip", timeout: time.Second,
+			run: func(ctx context.Context, run *renewRun) error {
+				if rejection := checkFreshIP(run, opts); rejection != nil {
+					return rejection
+				}
+				return nil
+			},
+		})
+	}
+	return steps
+}
+
+// locate atualiza o índice do modem do link pela identidade: o
+// ModemManager renumera os modems após reset ou reconexão USB, e as portas
+// acompanham o modem físico.
+func (o *RenewOrchestrator) locate(ctx context.Context, link *ModemLink) error {
+	if link.Identity == "" {
+		return nil
+	}
+
+	modemID, err := LocateModem(ctx, o.backend, link.Identity, link.ModemID)
+	if err != nil {
+		return err
+	}
+	if modemID == link.ModemID {
+		return nil
+	}
+
+	log.Printf("📌 Modem %s (porta %d) mudou de índice: %s → %s", link.Identity, link.HTTPPort, link.ModemID, modemID)
+	link.ModemID = modemID
+	return o.links.Update(*link)
+}
+
+func (o *RenewOrchestrator) readBearer(ctx context.Context, run *renewRun) error {
+	info, err := o.backend.GetModem(ctx, run.link.ModemID)
+	if err != nil {
+		return err
+	}
+
+	bearerID := info.CurrentBearer()
+	if bearerID == "" {
+		return fmt.Errorf("bearer não encontrado")
+	}
+
+	bearer, err := o.backend.GetBearer(ctx, bearerID)
+	if err != nil {
+		return err
+	}
+	if bearer.Address == "" || bearer.Interface == "" {
+		return fmt.Errorf("configuração incompleta obtida (IP: %q, interface: %q)", bearer.Address, bearer.Interface)
+	}
+
+	run.link.Interface = bearer.Interface
+	run.link.IP = bearer.Address
+	if bearer.Gateway != "" {
+		run.link.Gateway = bearer.Gateway
+	}
+	if bearer.Prefix > 0 {
+		run.link.Prefix = bearer.Prefix
+	}
+	return nil
+}
+
+func (o *RenewOrchestrator) runStep(ctx context.Context, run *renewRun, step renewStep, reporter RenewReporter) error {
+	for try := 1; ; try++ {
+		stepCtx, cancel := context.WithTimeout(ctx, step.timeout)
+		start := time.Now()
+		err := step.run(stepCtx, run)
+		if err == nil && stepCtx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timeout de %s", step.timeout)
+		}
+		cancel()
+
+		record := JobStep{
+			Name:     step.name,
+			Attempt:  run.attempt,
+			Try:      try,
+			Duration: time.Since(start).Round(time.Millisecond).String(),
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+		reporter.StepFinished(record)
+
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if try > step.retries {
+			if step.optional {
+				reporter.Logf("⚠️  %s falhou (ignorado): %v", step.name, err)
+				return nil
+			}
+			return &StepError{Step: step.name, Attempt: run.attempt, Err: err}
+		}
+
+		reporter.Logf("%s falhou, nova tentativa em %s: %v", step.name, step.retryDelay, err)
+		if err := sleepContext(ctx, step.retryDelay); err != nil {
+			return err
+		}
+	}
+}
+
+// Renew executa a renovação completa da porta (HTTP ou SOCKS5), repetindo a
+// sequência inteira até opts.MaxAttempts vezes.
+func (o *RenewOrchestrator) Renew(ctx context.Context, port int, opts RenewOptions, reporter RenewReporter) (*RenewResult, error) {
+	opts = opts.withDefaults()
+	o.links.Refresh(ctx)
+
+	link, ok := o.links.ByPort(port)
+	if !ok {
+		return nil, fmt.Errorf("porta %d não encontrada no sistema", port)
+	}
+	if err := o.locate(ctx, &link); err != nil {
+		return nil, err
+	}
+
+	result := &RenewResult{
+		Port:         link.HTTPPort,
+		ModemID:      link.ModemID,
+		OldInterface: link.Interface,
+		OldIP:        link.IP,
+		OldPublicIP:  resolvePublicIP(ctx, link.ModemID, link.HTTPPort),
+	}
+
+	reporter.Logf("Modem %s | Interface %s | IP %s | IP público %s", link.ModemID, link.Interface, link.IP, result.OldPublicIP)
+	ipHistory.Observe(link, result.OldPublicIP, "", "")
+
+	var lastErr error
+	failures := 0
+	for attempt := 1; ; attempt++ {
+		result.Attempts = attempt
+		reporter.Logf("Tentativa %d (falhas %d de %d, IPs recusados %d de %d)", attempt, failures, opts.MaxAttempts, len(result.Rejections), opts.MaxIPRetries)
+
+		// Os IPs recentes são lidos antes da sequência para que o próprio IP
+		// novo, visto por um refresh de status no meio dela, não conte.
+		run := &renewRun{attempt: attempt, previous: link, link: link, reporter: reporter}
+		if opts.RejectRecent > 0 {
+			run.recent = ipHistory.Recent(link.HTTPPort, opts.RejectRecent)
+		}
+
+		lastErr = nil
+		for _, step := range o.steps(opts) {
+			if err := o.runStep(ctx, run, step, reporter); err != nil {
+				lastErr = err
+				break
+			}
+		}
+
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		var rejection *IPRejection
+		if errors.As(lastErr, &rejection) {
+			// A conexão nova está de pé: a próxima tentativa parte dela
+			if err := o.links.Update(run.link); err != nil {
+				log.Printf("⚠️  %v", err)
+			}
+			link = run.link
+			ipHistory.Observe(link, rejection.IP, IPCauseRenew, opts.JobID)
+
+			result.Rejections = append(result.Rejections, *rejection)
+			reporter.Logf("♻️  IP %s recusado (%s): %s", rejection.IP, rejection.Reason, rejection.Detail)
+			if len(result.Rejections) > opts.MaxIPRetries {
+				result.NewInterface = run.link.Interface
+				result.NewIP = run.link.IP
+				result.NewPublicIP = rejection.IP
+				return result, fmt.Errorf("IP público repetido após %d tentativas: %s", len(result.Rejections), rejection.Detail)
+			}
+			continue
+		}
+
+		if lastErr != nil {
+			reporter.Logf("❌ %v", lastErr)
+			failures++
+			if failures >= opts.MaxAttempts {
+				break
+			}
+			continue
+		}
+
+		if err := o.links.Update(run.link); err != nil {
+			log.Printf("⚠️  %v", err)
+		}
+		ipHistory.Observe(run.link, run.publicIP, IPCauseRenew, opts.JobID)
+
+		result.ModemID = run.link.ModemID
+		result.NewInterface = run.link.Interface
+		result.NewIP = run.link.IP
+		result.NewPublicIP = run.publicIP
+		result.Changed = result.NewPublicIP != result.OldPublicIP
+
+		reporter.Logf("Interface %s → %s | IP interno %s → %s | IP público %s → %s",
+			result.OldInterface, result.NewInterface, result.OldIP, result.NewIP, result.OldPublicIP, result.NewPublicIP)
+		return result, nil
+	}
+
+	return result, fmt.Errorf("falha ao renovar IP após %d tentativas: %w", opts.MaxAttempts, lastErr)
+}
+
+// Reconnect religa o bearer de um modem que caiu, sem o ciclo de baixo
+// consumo da renovação: conecta, lê o bearer, reaplica interface e rotas e
+// reinicia só o proxy do modem. Executa uma vez; quem repete é o supervisor.
+func (o *RenewOrchestrator) Reconnect(ctx context.Context, port int, reporter RenewReporter) (*RenewResult, error) {
+	o.links.Refresh(ctx)
+
+	link, ok := o.links.ByPort(port)
+	if !ok {
+		return nil, fmt.Errorf("porta %d não encontrada no sistema", port)
+	}
+	if err := o.locate(ctx, &link); err != nil {
+		return nil, err
+	}
+
+	result := &RenewResult{
+		Port:         link.HTTPPort,
+		ModemID:      link.ModemID,
+		OldInterface: link.Interface,
+		OldIP:        link.IP,
+		Attempts:     1,
+	}
+
+	steps := o.steps(RenewOptions{})
+	for i, step := range steps {
+		if step.name == "connect" {
+			steps = steps[i:]
+			break
+		}
+	}
+
+	run := &renewRun{attempt: 1, previous: link, link: link, reporter: reporter}
+	for _, step := range steps {
+		if err := o.runStep(ctx, run, step, reporter); err != nil {
+			return result, err
+		}
+	}
+
+	if err := o.links.Update(run.link); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	ipHistory.Observe(run.link, run.publicIP, IPCauseReconnect, "")
+
+	result.NewInterface = run.link.Interface
+	result.NewIP = run.link.IP
+	result.NewPublicIP = run.publicIP
+	result.Changed = true
+	return result, nil
+}
+
+// checkFreshIP devolve o motivo para recusar o IP, ou nil se ele é novo.
+func checkFreshIP(run *renewRun, opts RenewOptions) *IPRejection {
+	for _, seen := range run.recent {
+		if seen == run.publicIP {
+			return &IPRejection{
+				Attempt: run.attempt,
+				IP:      run.publicIP,
+				Reason:  RejectReasonRecent,
+				Detail:  fmt.Sprintf("IP %s está entre os %d últimos da porta %d", run.publicIP, opts.RejectRecent, run.link.HTTPPort),
+			}
+		}
+	}
+
+	if opts.RejectInUse {
+		if other, held := ipHistory.HeldBy(run.publicIP, run.link.HTTPPort); held {
+			return &IPRejection{
+				Attempt: run.attempt,
+				IP:      run.publicIP,
+				Reason:  RejectReasonInUse,
+				Detail:  fmt.Sprintf("IP %s em uso pelo modem %s (porta %d)", run.publicIP, other.ModemID, other.HTTPPort),
+			}
+		}
+	}
+	return nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
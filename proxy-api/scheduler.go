@@ -0,0 +1,605 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// ROTAÇÃO AGENDADA - POLÍTICAS
+// ============================================================================
+
+const (
+	ScheduleInterval = "interval"
+	ScheduleCron     = "cron"
+	ScheduleWindow   = "window"
+)
+
+// RotationPolicy renova o IP de um grupo de portas (vazio = todos os modems)
+// a cada Every, nos horários da expressão Cron, ou a cada Every dentro da
+// janela WindowStart–WindowEnd (horário local, "HH:MM"; pode virar a noite).
+type RotationPolicy struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	Ports       []int        `json:"ports"`
+	Mode        string       `json:"mode"`
+	Every       jsonDuration `json:"every,omitempty"`
+	Cron        string       `json:"cron,omitempty"`
+	WindowStart string       `json:"window_start,omitempty"`
+	WindowEnd   string       `json:"window_end,omitempty"`
+	Enabled     bool         `json:"enabled"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+	LastRunAt   *time.Time   `json:"last_run_at,omitempty"`
+	NextRunAt   *time.Time   `json:"next_run_at,omitempty"`
+	LastJobs    []string     `json:"last_jobs,omitempty"`
+}
+
+// ScheduleInput são os campos aceitos via API. No PUT, campos omitidos
+// mantêm o valor atual.
+type ScheduleInput struct {
+	Name        string        `json:"name"`
+	Ports       []int         `json:"ports"`
+	Mode        string        `json:"mode"`
+	Every       *jsonDuration `json:"every"`
+	Cron        *string       `json:"cron"`
+	WindowStart *string       `json:"window_start"`
+	WindowEnd   *string       `json:"window_end"`
+	Enabled     *bool         `json:"enabled"`
+}
+
+func (p *RotationPolicy) validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("nome é obrigatório")
+	}
+	ports := currentConfig().Ports
+	for _, port := range p.Ports {
+		if port <= ports.BaseHTTP || port > ports.BaseHTTP+ports.MaxModems {
+			return fmt.Errorf("porta inválida: %d (use as portas HTTP)", port)
+		}
+	}
+
+	switch p.Mode {
+	case ScheduleInterval:
+		if time.Duration(p.Every) < time.Minute {
+			return fmt.Errorf("every deve ser de pelo menos 1m")
+		}
+	case ScheduleCron:
+		if _, err := parseCron(p.Cron); err != nil {
+			return err
+		}
+	case ScheduleWindow:
+		if time.Duration(p.Every) < time.Minute {
+			return fmt.Errorf("every deve ser de pelo menos 1m")
+		}
+		if _, err := parseClock(p.WindowStart); err != nil {
+			return err
+		}
+		if _, err := parseClock(p.WindowEnd); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("modo inválido: %q (use interval, cron ou window)", p.Mode)
+	}
+	return nil
+}
+
+// next calcula a próxima execução depois de after.
+func (p *RotationPolicy) next(after time.Time) time.Time {
+	switch p.Mode {
+	case ScheduleInterval:
+		return after.Add(time.Duration(p.Every))
+	case ScheduleCron:
+		cron, err := parseCron(p.Cron)
+		if err != nil {
+			return time.Time{}
+		}
+		return cron.Next(after)
+	case ScheduleWindow:
+		return p.nextInWindow(after)
+	}
+	return time.Time{}
+}
+
+// nextInWindow devolve o próximo horário início + k*Every que cai dentro da
+// janela. A janela de ontem entra na busca por causa das janelas que viram a
+// noite (22:00–04:00).
+func (p *RotationPolicy) nextInWindow(after time.Time) time.Time {
+	start, _ := parseClock(p.WindowStart)
+	end, _ := parseClock(p.WindowEnd)
+	every := time.Duration(p.Every)
+
+	year, month, day := after.Date()
+	for offset := -1; offset <= 1; offset++ {
+		midnight := time.Date(year, month, day+offset, 0, 0, 0, 0, after.Location())
+		windowStart := midnight.Add(start)
+		windowEnd := midnight.Add(end)
+		if end <= start {
+			windowEnd = windowEnd.Add(24 * time.Hour)
+		}
+
+		if after.Before(windowStart) {
+			return windowStart
+		}
+		if after.Before(windowEnd) {
+			candidate := windowStart.Add((after.Sub(windowStart)/every + 1) * every)
+			if candidate.Before(windowEnd) {
+				return candidate
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// parseClock converte "HH:MM" no tempo desde a meia-noite.
+func parseClock(value string) (time.Duration, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("horário inválido: %q (use HH:MM)", value)
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+// ============================================================================
+// ROTAÇÃO AGENDADA - EXPRESSÕES CRON
+// ============================================================================
+
+// cronSchedule é uma expressão cron de 5 campos (minuto hora dia mês
+// dia-da-semana) com *, listas, intervalos e passos ("*/15", "1-5", "0,30").
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expressão cron inválida: %q (esperado 5 campos)", expr)
+	}
+
+	var cron cronSchedule
+	var err error
+	if cron.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if cron.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if cron.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if cron.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if cron.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+
+	// 7 também é domingo
+	if cron.dow&(1<<7) != 0 {
+		cron.dow |= 1
+	}
+	cron.domAny = fields[2] == "*"
+	cron.dowAny = fields[4] == "*"
+	return &cron, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("passo inválido no campo cron %q", field)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			low, err1 = strconv.Atoi(bounds[0])
+			high, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("intervalo inválido no campo cron %q", field)
+			}
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("valor inválido no campo cron %q", field)
+			}
+			low, high = n, n
+			if step > 1 {
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("campo cron %q fora do intervalo %d-%d", field, min, max)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// matchesDay segue o cron tradicional: com dia do mês e dia da semana
+// restritos, basta um dos dois bater.
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// Next devolve o primeiro minuto depois de after que bate com a expressão
+// (zero se não houver nenhum nos próximos 5 anos, p.ex. "0 0 31 2 *").
+func (c *cronSchedule) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := time.Date(after.Year(), after.Month(), after.Day(), after.Hour(), after.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := after.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// ============================================================================
+// ROTAÇÃO AGENDADA - SCHEDULER
+// ============================================================================
+
+// scheduledRenew é uma renovação na fila do scheduler.
+type scheduledRenew struct {
+	port     int
+	policyID string
+}
+
+// Scheduler guarda as políticas em DATA_DIR/schedules.json e dispara as
+// renovações por uma fila única: entre uma renovação e a próxima há sempre
+// stagger de intervalo, para os modems não ficarem offline ao mesmo tempo.
+// A fila não tem limite: queued garante uma entrada por porta, então ela
+// nunca passa do número de modems.
+type Scheduler struct {
+	mu       sync.Mutex
+	policies map[string]*RotationPolicy
+	path     string
+	stagger  time.Duration
+	queue    []scheduledRenew
+	queued   map[int]bool
+	wake     chan struct{}
+}
+
+var scheduler = &Scheduler{
+	policies: make(map[string]*RotationPolicy),
+	stagger:  SCHEDULER_STAGGER,
+	queued:   make(map[int]bool),
+	wake:     make(chan struct{}, 1),
+}
+
+func (s *Scheduler) SetDataDir(dir string) {
+	s.mu.Lock()
+	s.path = filepath.Join(dir, "schedules.json")
+	s.mu.Unlock()
+}
+
+func (s *Scheduler) SetStagger(stagger time.Duration) {
+	s.mu.Lock()
+	s.stagger = stagger
+	s.mu.Unlock()
+}
+
+// Load lê as políticas salvas. Execuções perdidas enquanto a API estava
+// parada não são repetidas: o próximo horário é calculado a partir de agora.
+func (s *Scheduler) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var policies []*RotationPolicy
+	if err := readJSONFile(s.path, &policies); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("erro ao ler agendamentos: %v", err)
+	}
+
+	now := time.Now()
+	for _, policy := range policies {
+		if policy.NextRunAt == nil || policy.NextRunAt.Before(now) {
+			policy.schedule(now)
+		}
+		s.policies[policy.ID] = policy
+	}
+	return nil
+}
+
+func (s *Scheduler) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	policies := make([]*RotationPolicy, 0, len(s.policies))
+	for _, policy := range s.policies {
+		policies = append(policies, policy)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].CreatedAt.Before(policies[j].CreatedAt) })
+
+	if err := writeJSONFile(s.path, policies); err != nil {
+		return fmt.Errorf("erro ao salvar agendamentos: %v", err)
+	}
+	return nil
+}
+
+// schedule atualiza NextRunAt (nil para políticas desativadas).
+func (p *RotationPolicy) schedule(after time.Time) {
+	p.NextRunAt = nil
+	if !p.Enabled {
+		return
+	}
+	if next := p.next(after); !next.IsZero() {
+		p.NextRunAt = &next
+	}
+}
+
+func (p *RotationPolicy) copy() RotationPolicy {
+	copied := *p
+	copied.Ports = append([]int{}, p.Ports...)
+	copied.LastJobs = append([]string(nil), p.LastJobs...)
+	return copied
+}
+
+func (s *Scheduler) List() []RotationPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	policies := make([]RotationPolicy, 0, len(s.policies))
+	for _, policy := range s.policies {
+		policies = append(policies, policy.copy())
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].CreatedAt.Before(policies[j].CreatedAt) })
+	return policies
+}
+
+// apply copia os campos enviados para a política.
+func (input ScheduleInput) apply(policy *RotationPolicy) {
+	if input.Name != "" {
+		policy.Name = input.Name
+	}
+	if input.Ports != nil {
+		policy.Ports = input.Ports
+	}
+	if input.Mode != "" {
+		policy.Mode = input.Mode
+	}
+	if input.Every != nil {
+		policy.Every = *input.Every
+	}
+	if input.Cron != nil {
+		policy.Cron = *input.Cron
+	}
+	if input.WindowStart != nil {
+		policy.WindowStart = *input.WindowStart
+	}
+	if input.WindowEnd != nil {
+		policy.WindowEnd = *input.WindowEnd
+	}
+	if input.Enabled != nil {
+		policy.Enabled = *input.Enabled
+	}
+}
+
+func (s *Scheduler) Create(input ScheduleInput) (RotationPolicy, error) {
+	now := time.Now()
+	policy := &RotationPolicy{
+		ID:        newJobID(),
+		Ports:     make([]int, 0),
+		Enabled:   true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	input.apply(policy)
+	if err := policy.validate(); err != nil {
+		return RotationPolicy{}, err
+	}
+	policy.schedule(now)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.policies[policy.ID] = policy
+	if err := s.save(); err != nil {
+		delete(s.policies, policy.ID)
+		return RotationPolicy{}, err
+	}
+
+	log.Printf("⏰ Agendamento %s (%s) criado", policy.ID, policy.Name)
+	return policy.copy(), nil
+}
+
+func (s *Scheduler) Update(id string, input ScheduleInput) (RotationPolicy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.policies[id]
+	if !ok {
+		return RotationPolicy{}, fmt.Errorf("agendamento %s não encontrado", id)
+	}
+
+	updated := current.copy()
+	input.apply(&updated)
+	if err := updated.validate(); err != nil {
+		return RotationPolicy{}, err
+	}
+	updated.UpdatedAt = time.Now()
+	updated.schedule(updated.UpdatedAt)
+
+	s.policies[id] = &updated
+	if err := s.save(); err != nil {
+		s.policies[id] = current
+		return RotationPolicy{}, err
+	}
+
+	log.Printf("⏰ Agendamento %s (%s) atualizado", id, updated.Name)
+	return updated.copy(), nil
+}
+
+func (s *Scheduler) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	policy, ok := s.policies[id]
+	if !ok {
+		return fmt.Errorf("agendamento %s não encontrado", id)
+	}
+
+	delete(s.policies, id)
+	if err := s.save(); err != nil {
+		s.policies[id] = policy
+		return err
+	}
+
+	log.Printf("⏰ Agendamento %s (%s) removido", id, policy.Name)
+	return nil
+}
+
+// Run verifica as políticas a cada SCHEDULER_TICK e executa a fila.
+func (s *Scheduler) Run() {
+	go s.dispatch()
+
+	ticker := time.NewTicker(SCHEDULER_TICK)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.tick(now)
+	}
+}
+
+// tick enfileira as portas das políticas vencidas. Uma porta que já está na
+// fila não entra de novo, mesmo que esteja em mais de uma política.
+func (s *Scheduler) tick(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for _, policy := range s.policies {
+		if !policy.Enabled || policy.NextRunAt == nil || now.Before(*policy.NextRunAt) {
+			continue
+		}
+
+		ports := append([]int{}, policy.Ports...)
+		if len(ports) == 0 {
+			for _, link := range linkRegistry.All() {
+				ports = append(ports, link.HTTPPort)
+			}
+		}
+		sort.Ints(ports)
+
+		log.Printf("⏰ Agendamento %s (%s): %d porta(s) na fila", policy.ID, policy.Name, len(ports))
+		for _, port := range ports {
+			if s.queued[port] {
+				continue
+			}
+			s.queue = append(s.queue, scheduledRenew{port: port, policyID: policy.ID})
+			s.queued[port] = true
+		}
+
+		runAt := now
+		policy.LastRunAt = &runAt
+		policy.LastJobs = nil
+		policy.schedule(now)
+		changed = true
+	}
+
+	if changed {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+		if err := s.save(); err != nil {
+			log.Printf("⚠️  %v", err)
+		}
+	}
+}
+
+// dispatch inicia uma renovação por vez, esperando stagger entre elas.
+// Portas que já estão renovando (p.ex. por /renew) são puladas.
+func (s *Scheduler) dispatch() {
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			<-s.wake
+			continue
+		}
+		item := s.queue[0]
+		s.queue = s.queue[1:]
+		delete(s.queued, item.port)
+		stagger := s.stagger
+		s.mu.Unlock()
+
+		if jobManager.Renewing(item.port) {
+			log.Printf("⏰ Porta %d já está renovando, agendamento %s pulado", item.port, item.policyID)
+			continue
+		}
+
+		job := startRenewJob(item.port, "scheduler:"+item.policyID, RenewOptions{})
+		log.Printf("⏰ Renovação agendada da porta %d iniciada (job %s)", item.port, job.ID)
+
+		s.mu.Lock()
+		if policy, ok := s.policies[item.policyID]; ok {
+			policy.LastJobs = append(policy.LastJobs, job.ID)
+		}
+		s.mu.Unlock()
+
+		time.Sleep(stagger)
+	}
+}
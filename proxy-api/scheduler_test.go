@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day, hour, minute int) time.Time {
+	return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-x * * * *",
+		"@yearly",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) deveria falhar", expr)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	tests := []struct {
+		expr  string
+		after time.Time
+		want  time.Time
+	}{
+		{"*/15 * * * *", date(2024, 3, 1, 10, 7).Add(30 * time.Second), date(2024, 3, 1, 10, 15)},
+		{"5 4 * * *", date(2024, 3, 1, 4, 5), date(2024, 3, 2, 4, 5)},
+		{"0 */6 * * *", date(2024, 3, 1, 23, 0), date(2024, 3, 2, 0, 0)},
+		{"0,30 8-9 * * *", date(2024, 3, 1, 9, 30), date(2024, 3, 2, 8, 0)},
+		{"10/20 * * * *", date(2024, 3, 1, 10, 31), date(2024, 3, 1, 10, 50)},
+		// 2024-03-01 é sexta-feira
+		{"0 9 * * 1-5", date(2024, 3, 1, 10, 0), date(2024, 3, 4, 9, 0)},
+		{"0 0 * * 7", date(2024, 3, 1, 0, 0), date(2024, 3, 3, 0, 0)},
+		{"@weekly", date(2024, 3, 1, 0, 0), date(2024, 3, 3, 0, 0)},
+		// Dia do mês e dia da semana restritos: basta um dos dois
+		{"0 12 15 * 0", date(2024, 3, 1, 12, 0), date(2024, 3, 3, 12, 0)},
+		{"@daily", date(2024, 12, 31, 23, 59), date(2025, 1, 1, 0, 0)},
+		{"@monthly", date(2024, 1, 31, 0, 0), date(2024, 2, 1, 0, 0)},
+		{"30 2 29 2 *", date(2024, 3, 1, 0, 0), date(2028, 2, 29, 2, 30)},
+		{"0 0 31 2 *", date(2024, 3, 1, 0, 0), time.Time{}},
+	}
+
+	for _, tt := range tests {
+		cron, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := cron.Next(tt.after); !got.Equal(tt.want) {
+			t.Errorf("%q depois de %s = %s, esperado %s", tt.expr, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestRotationPolicyNextInWindow(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end string
+		every      time.Duration
+		after      time.Time
+		want       time.Time
+	}{
+		// Janela que vira a noite: 22:00–04:00
+		{"antes da janela", "22:00", "04:00", time.Hour, date(2024, 3, 1, 21, 30), date(2024, 3, 1, 22, 0)},
+		{"no início da janela", "22:00", "04:00", time.Hour, date(2024, 3, 1, 22, 0), date(2024, 3, 1, 23, 0)},
+		{"passando da meia-noite", "22:00", "04:00", time.Hour, date(2024, 3, 1, 23, 30), date(2024, 3, 2, 0, 0)},
+		{"madrugada na janela de ontem", "22:00", "04:00", time.Hour, date(2024, 3, 2, 2, 15), date(2024, 3, 2, 3, 0)},
+		{"último horário da janela", "22:00", "04:00", time.Hour, date(2024, 3, 2, 3, 0), date(2024, 3, 2, 22, 0)},
+		{"passo que não divide a janela", "22:00", "04:00", 90 * time.Minute, date(2024, 3, 2, 2, 30), date(2024, 3, 2, 22, 0)},
+		{"passo de 90m depois da meia-noite", "22:00", "04:00", 90 * time.Minute, date(2024, 3, 2, 0, 10), date(2024, 3, 2, 1, 0)},
+		{"virada do mês", "23:00", "01:00", 30 * time.Minute, date(2024, 3, 31, 23, 45), date(2024, 4, 1, 0, 0)},
+		{"virada do ano", "23:00", "01:00", 30 * time.Minute, date(2024, 12, 31, 22, 0), date(2024, 12, 31, 23, 0)},
+		// Janela no mesmo dia
+		{"janela diurna", "09:00", "17:00", 2 * time.Hour, date(2024, 3, 1, 10, 30), date(2024, 3, 1, 11, 0)},
+		{"fim da janela diurna", "09:00", "17:00", 2 * time.Hour, date(2024, 3, 1, 16, 0), date(2024, 3, 2, 9, 0)},
+		// Início igual ao fim: o dia inteiro
+		{"dia inteiro", "00:00", "00:00", 6 * time.Hour, date(2024, 3, 1, 5, 0), date(2024, 3, 1, 6, 0)},
+		{"dia inteiro no fim do dia", "00:00", "00:00", 6 * time.Hour, date(2024, 3, 1, 18, 0), date(2024, 3, 2, 0, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &RotationPolicy{Mode: ScheduleWindow, WindowStart: tt.start, WindowEnd: tt.end, Every: jsonDuration(tt.every)}
+			if got := p.nextInWindow(tt.after); !got.Equal(tt.want) {
+				t.Fatalf("%s–%s a cada %v depois de %s = %s, esperado %s", tt.start, tt.end, tt.every, tt.after, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// SESSÕES FIXAS (STICKY)
+// ============================================================================
+
+const (
+	sessionMarker     = "session-"
+	sessionSweepEvery = time.Minute
+)
+
+// Session fixa uma chave enviada no usuário do proxy a um modem até expirar.
+type Session struct {
+	Key           string    `json:"key"`
+	User          string    `json:"user,omitempty"`
+	ModemID       string    `json:"modem_id"`
+	HTTPPort      int       `json:"http_port"`
+	OutboundIP    string    `json:"outbound_ip"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	LastUsedAt    time.Time `json:"last_used_at"`
+	Connections   int64     `json:"connections"`
+	Reassignments int       `json:"reassignments"`
+}
+
+type SessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	ttl      time.Duration
+}
+
+var sessionManager = &SessionManager{
+	sessions: make(map[string]*Session),
+	ttl:      SESSION_TTL,
+}
+
+// parseProxyUsername separa o usuário da chave de sessão. Aceita
+// "session-<chave>" e "<usuario>-session-<chave>".
+func parseProxyUsername(username string) (user, session string) {
+	if strings.HasPrefix(username, sessionMarker) {
+		return "", strings.TrimPrefix(username, sessionMarker)
+	}
+	if i := strings.Index(username, "-"+sessionMarker); i >= 0 {
+		return username[:i], username[i+len(sessionMarker)+1:]
+	}
+	return username, ""
+}
+
+// sessionIndex separa sessões de mesma chave de usuários diferentes.
+func sessionIndex(user, key string) string {
+	return user + "/" + key
+}
+
+func (sm *SessionManager) SetTTL(ttl time.Duration) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.ttl = ttl
+}
+
+// Resolve devolve o modem fixado na sessão. Se a sessão não existe, expirou,
+// ou o modem está indisponível, renovou o IP ou deixou de ser permitido ao
+// usuário, choose escolhe um novo modem.
+func (sm *SessionManager) Resolve(user, key string, allowed func(*proxyInstance) bool, choose func() (*proxyInstance, error)) (*proxyInstance, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	index := sessionIndex(user, key)
+	now := time.Now()
+	session, ok := sm.sessions[index]
+	if ok && now.After(session.ExpiresAt) {
+		delete(sm.sessions, index)
+		ok = false
+	}
+
+	if ok {
+		if inst, found := proxyServer.instance(session.HTTPPort); found {
+			if unavailableReason(inst) == "" && allowed(inst) && inst.outboundIP() == session.OutboundIP {
+				session.LastUsedAt = now
+				session.Connections++
+				return inst, nil
+			}
+		}
+	}
+
+	inst, err := choose()
+	if err != nil {
+		return nil, err
+	}
+	link := inst.currentLink()
+
+	if ok {
+		log.Printf("📌 Sessão %s reatribuída: porta %d → %d (%s)", key, session.HTTPPort, link.HTTPPort, link.IP)
+		session.ModemID = link.ModemID
+		session.HTTPPort = link.HTTPPort
+		session.OutboundIP = link.IP
+		session.LastUsedAt = now
+		session.Connections++
+		session.Reassignments++
+		return inst, nil
+	}
+
+	sm.sessions[index] = &Session{
+		Key:         key,
+		User:        user,
+		ModemID:     link.ModemID,
+		HTTPPort:    link.HTTPPort,
+		OutboundIP:  link.IP,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(sm.ttl),
+		LastUsedAt:  now,
+		Connections: 1,
+	}
+	return inst, nil
+}
+
+// Run descarta as sessões expiradas a cada sessionSweepEvery. Clientes que
+// geram uma chave por tarefa nunca repetem a chave, então sem a varredura o
+// mapa só cresceria.
+func (sm *SessionManager) Run() {
+	ticker := time.NewTicker(sessionSweepEvery)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		sm.mu.Lock()
+		sm.sweep(time.Now())
+		sm.mu.Unlock()
+	}
+}
+
+func (sm *SessionManager) sweep(now time.Time) {
+	for index, session := range sm.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(sm.sessions, index)
+		}
+	}
+}
+
+// List devolve as sessões ativas, descartando as expiradas.
+func (sm *SessionManager) List() []Session {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.sweep(time.Now())
+	sessions := make([]Session, 0, len(sm.sessions))
+	for _, session := range sm.sessions {
+		sessions = append(sessions, *session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions
+}
+
+func (sm *SessionManager) Delete(user, key string) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	index := sessionIndex(user, key)
+	if _, ok := sm.sessions[index]; !ok {
+		return false
+	}
+	delete(sm.sessions, index)
+	return true
+}
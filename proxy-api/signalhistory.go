@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// HISTÓRICO DE SINAL
+// ============================================================================
+
+const (
+	signalHistoryFlushWait = 5 * time.Minute
+	signalHistoryMaxPoints = 500 // pontos por consulta sem step
+)
+
+// signalHistoryTiers são as resoluções guardadas: leituras brutas nas
+// últimas 2h, médias de 5min nas últimas 24h e médias de 1h até a retenção
+// (modems.history_retention). Ao envelhecer, cada ponto é somado ao balde da
+// resolução seguinte, então a série de cada modem tem tamanho limitado.
+var signalHistoryTiers = []struct {
+	step time.Duration
+	span time.Duration
+}{
+	{0, 2 * time.Hour},
+	{5 * time.Minute, 24 * time.Hour},
+	{time.Hour, 0},
+}
+
+// SignalPoint agrega as leituras de um período que começa em At. SignalAvg,
+// SignalMin e SignalMax valem -1 se nenhuma leitura trouxe o sinal;
+// AccessTech e Registration contam as leituras em cada tecnologia e estado
+// de registro, e as trocas entre leituras seguidas mostram modems instáveis.
+type SignalPoint struct {
+	At                  time.Time      `json:"at"`
+	Samples             int            `json:"samples"`
+	SignalSamples       int            `json:"signal_samples"`
+	SignalAvg           float64        `json:"signal_avg"`
+	SignalMin           int            `json:"signal_min"`
+	SignalMax           int            `json:"signal_max"`
+	AccessTech          map[string]int `json:"access_tech"`
+	Registration        map[string]int `json:"registration"`
+	TechChanges         int            `json:"tech_changes"`
+	RegistrationChanges int            `json:"registration_changes"`
+}
+
+func newSignalPoint(at time.Time) *SignalPoint {
+	return &SignalPoint{
+		At:           at,
+		SignalAvg:    -1,
+		SignalMin:    -1,
+		SignalMax:    -1,
+		AccessTech:   make(map[string]int),
+		Registration: make(map[string]int),
+	}
+}
+
+// merge soma as leituras de other ao ponto.
+func (p *SignalPoint) merge(other *SignalPoint) {
+	if other.SignalSamples > 0 {
+		if p.SignalSamples == 0 {
+			p.SignalMin, p.SignalMax = other.SignalMin, other.SignalMax
+		} else {
+			p.SignalMin = min(p.SignalMin, other.SignalMin)
+			p.SignalMax = max(p.SignalMax, other.SignalMax)
+		}
+		total := p.SignalSamples + other.SignalSamples
+		p.SignalAvg = (p.SignalAvg*float64(p.SignalSamples) + other.SignalAvg*float64(other.SignalSamples)) / float64(total)
+		p.SignalSamples = total
+	}
+
+	p.Samples += other.Samples
+	p.TechChanges += other.TechChanges
+	p.RegistrationChanges += other.RegistrationChanges
+	for tech, n := range other.AccessTech {
+		p.AccessTech[tech] += n
+	}
+	for state, n := range other.Registration {
+		p.Registration[state] += n
+	}
+}
+
+// signalSeries é o histórico de um modem, pela identidade (IMEI/ICCID) para
+// sobreviver à renumeração. Tiers segue signalHistoryTiers, cada um do
+// ponto mais antigo para o mais recente.
+type signalSeries struct {
+	Identity         string           `json:"identity"`
+	ModemID          string           `json:"modem_id"`
+	LastAccessTech   string           `json:"last_access_tech"`
+	LastRegistration string           `json:"last_registration"`
+	Tiers            [][]*SignalPoint `json:"tiers"`
+}
+
+// add registra a leitura e empurra para a resolução seguinte os pontos que
+// saíram da janela do seu nível.
+func (s *signalSeries) add(point *SignalPoint, retention time.Duration) {
+	for len(s.Tiers) < len(signalHistoryTiers) {
+		s.Tiers = append(s.Tiers, make([]*SignalPoint, 0))
+	}
+	s.Tiers[0] = append(s.Tiers[0], point)
+
+	for i := 0; i < len(signalHistoryTiers)-1; i++ {
+		cutoff := point.At.Add(-signalHistoryTiers[i].span)
+		step := signalHistoryTiers[i+1].step
+
+		for len(s.Tiers[i]) > 0 && s.Tiers[i][0].At.Before(cutoff) {
+			old := s.Tiers[i][0]
+			s.Tiers[i] = s.Tiers[i][1:]
+
+			bucket := old.At.Truncate(step)
+			next := s.Tiers[i+1]
+			if len(next) == 0 || !next[len(next)-1].At.Equal(bucket) {
+				next = append(next, newSignalPoint(bucket))
+				s.Tiers[i+1] = next
+			}
+			next[len(next)-1].merge(old)
+		}
+	}
+
+	last := len(s.Tiers) - 1
+	cutoff := point.At.Add(-retention)
+	for len(s.Tiers[last]) > 0 && s.Tiers[last][0].At.Before(cutoff) {
+		s.Tiers[last] = s.Tiers[last][1:]
+	}
+}
+
+// SignalHistory amostra periodicamente sinal, tecnologia e registro de cada
+// modem e guarda as séries em DATA_DIR/signal-history.json.
+type SignalHistory struct {
+	mu      sync.Mutex
+	series  map[string]*signalSeries
+	path    string
+	savedAt time.Time
+}
+
+var signalHistory = &SignalHistory{
+	series: make(map[string]*signalSeries),
+}
+
+func (h *SignalHistory) SetDataDir(dir string) {
+	h.mu.Lock()
+	h.path = filepath.Join(dir, "signal-history.json")
+	h.mu.Unlock()
+}
+
+func (h *SignalHistory) Load() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var series []*signalSeries
+	if err := readJSONFile(h.path, &series); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("erro ao ler histórico de sinal: %v", err)
+	}
+
+	for _, s := range series {
+		h.series[s.Identity] = s
+	}
+	h.savedAt = time.Now()
+	return nil
+}
+
+func (h *SignalHistory) save() error {
+	if h.path == "" {
+		return nil
+	}
+
+	series := make([]*signalSeries, 0, len(h.series))
+	for _, s := range h.series {
+		series = append(series, s)
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Identity < series[j].Identity })
+
+	if err := writeJSONFile(h.path, series); err != nil {
+		return fmt.Errorf("erro ao salvar histórico de sinal: %v", err)
+	}
+	h.savedAt = time.Now()
+	return nil
+}
+
+// Flush grava as leituras ainda não salvas (no desligamento).
+func (h *SignalHistory) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.save()
+}
+
+// Run amostra os modems a cada modems.history_interval.
+func (h *SignalHistory) Run() {
+	for {
+		h.sampleAll()
+		time.Sleep(time.Duration(currentConfig().Modems.HistoryInterval))
+	}
+}
+
+func (h *SignalHistory) sampleAll() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	modemIDs, err := modemBackend.ListModems(ctx)
+	if err != nil {
+		return
+	}
+
+	for _, modemID := range modemIDs {
+		info, err := modemBackend.GetModem(ctx, modemID)
+		if err != nil {
+			continue
+		}
+		details, err := modemBackend.GetModemDetails(ctx, modemID)
+		if err != nil {
+			continue
+		}
+
+		identity := info.IMEI
+		if identity == "" {
+			identity, _, _ = ModemIdentity(ctx, modemBackend, modemID)
+		}
+		h.Record(signalHistoryKey(identity, modemID), modemID, info, details.RegistrationState)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.savedAt) >= signalHistoryFlushWait {
+		if err := h.save(); err != nil {
+			log.Printf("⚠️  %v", err)
+		}
+	}
+}
+
+// signalHistoryKey é a identidade do modem ou, sem ela, o índice atual.
+func signalHistoryKey(identity, modemID string) string {
+	if identity != "" {
+		return identity
+	}
+	return "modem-" + modemID
+}
+
+// Record guarda uma leitura na série do modem.
+func (h *SignalHistory) Record(key, modemID string, info *ModemInfo, registration string) {
+	if registration == "" {
+		registration = "unknown"
+	}
+	tech := info.AccessTech
+	if tech == "" {
+		tech = "unknown"
+	}
+
+	point := newSignalPoint(time.Now())
+	point.Samples = 1
+	point.AccessTech[tech] = 1
+	point.Registration[registration] = 1
+	if info.SignalQuality >= 0 {
+		point.SignalSamples = 1
+		point.SignalAvg = float64(info.SignalQuality)
+		point.SignalMin = info.SignalQuality
+		point.SignalMax = info.SignalQuality
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &signalSeries{Identity: key}
+		h.series[key] = s
+	} else {
+		if s.LastAccessTech != "" && s.LastAccessTech != tech {
+			point.TechChanges = 1
+		}
+		if s.LastRegistration != "" && s.LastRegistration != registration {
+			point.RegistrationChanges = 1
+		}
+	}
+	s.ModemID = modemID
+	s.LastAccessTech = tech
+	s.LastRegistration = registration
+	s.add(point, time.Duration(currentConfig().Modems.HistoryRetention))
+}
+
+// SignalHistoryResult é a resposta de GET /modems/{id}/history: os pontos
+// desde Since, agregados em baldes de Step (zero mantém a resolução
+// guardada), e o resumo do período inteiro.
+type SignalHistoryResult struct {
+	Identity string         `json:"identity"`
+	ModemID  string         `json:"modem_id"`
+	Since    time.Time      `json:"since"`
+	Step     string         `json:"step"`
+	Points   []*SignalPoint `json:"points"`
+	Count    int            `json:"count"`
+	Summary  *SignalPoint   `json:"summary"`
+}
+
+// Query devolve o histórico da identidade. Sem step, o passo é escolhido
+// para caber em signalHistoryMaxPoints.
+func (h *SignalHistory) Query(key string, since time.Time, step time.Duration) (*SignalHistoryResult, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		return nil, false
+	}
+
+	points := make([]*SignalPoint, 0)
+	for i := len(s.Tiers) - 1; i >= 0; i-- {
+		for _, p := range s.Tiers[i] {
+			if !p.At.Before(since) {
+				points = append(points, p)
+			}
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].At.Before(points[j].At) })
+
+	if step == 0 && len(points) > signalHistoryMaxPoints {
+		step = (time.Since(since) / signalHistoryMaxPoints).Truncate(time.Minute) + time.Minute
+	}
+
+	result := &SignalHistoryResult{
+		Identity: s.Identity,
+		ModemID:  s.ModemID,
+		Since:    since,
+		Step:     step.String(),
+		Points:   make([]*SignalPoint, 0),
+		Summary:  newSignalPoint(since),
+	}
+
+	for _, p := range points {
+		result.Summary.merge(p)
+
+		at := p.At
+		if step > 0 {
+			at = at.Truncate(step)
+		}
+		if n := len(result.Points); n == 0 || !result.Points[n-1].At.Equal(at) {
+			result.Points = append(result.Points, newSignalPoint(at))
+		}
+		result.Points[len(result.Points)-1].merge(p)
+	}
+	for _, p := range append(result.Points, result.Summary) {
+		p.SignalAvg = math.Round(p.SignalAvg*10) / 10
+	}
+	result.Count = len(result.Points)
+	return result, true
+}
+
+// parseHistoryDuration aceita durações do Go e dias ("7d").
+func parseHistoryDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("duração inválida: %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("duração inválida: %q", value)
+	}
+	return d, nil
+}
+
+// parseHistorySince aceita um instante RFC3339 ou uma duração para trás
+// ("24h", "7d"); vazio é fallback para trás.
+func parseHistorySince(value string, fallback time.Duration) (time.Time, error) {
+	if value == "" {
+		return time.Now().Add(-fallback), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := parseHistoryDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("since inválido: %q (use RFC3339 ou duração como 24h ou 7d)", value)
+	}
+	return time.Now().Add(-d), nil
+}
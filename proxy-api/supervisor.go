@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// SUPERVISOR DE CONEXÃO
+// ============================================================================
+
+const (
+	EventModemDown       = "modem_down"
+	EventReconnectFailed = "reconnect_failed"
+	EventReconnected     = "reconnected"
+
+	supervisorMaxEvents = 200
+)
+
+// Estados do ModemManager em que o bearer caiu e o modem precisa ser
+// religado. Estados transitórios (connecting, enabling...) e falhas de
+// hardware (failed, locked) ficam de fora.
+var reconnectStates = map[string]bool{
+	"disabled":     true,
+	"enabled":      true,
+	"searching":    true,
+	"registered":   true,
+	"disconnected": true,
+}
+
+// SupervisorEvent registra uma queda ou uma tentativa de recuperação.
+type SupervisorEvent struct {
+	At        time.Time `json:"at"`
+	Type      string    `json:"type"`
+	ModemID   string    `json:"modem_id"`
+	Port      int       `json:"port"`
+	State     string    `json:"state,omitempty"`
+	Attempt   int       `json:"attempt,omitempty"`
+	Downtime  string    `json:"downtime,omitempty"`
+	NewIP     string    `json:"new_ip,omitempty"`
+	PublicIP  string    `json:"public_ip,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	NextRetry string    `json:"next_retry,omitempty"`
+}
+
+// ModemSupervision é o acompanhamento de um modem pelo supervisor.
+type ModemSupervision struct {
+	ModemID         string     `json:"modem_id"`
+	Port            int        `json:"port"`
+	State           string     `json:"state"`
+	Down            bool       `json:"down"`
+	DownSince       *time.Time `json:"down_since,omitempty"`
+	Recovering      bool       `json:"recovering"`
+	Failures        int        `json:"failures"`
+	NextAttemptAt   *time.Time `json:"next_attempt_at,omitempty"`
+	Recoveries      int        `json:"recoveries"`
+	LastRecoveredAt *time.Time `json:"last_recovered_at,omitempty"`
+}
+
+// Supervisor verifica o estado de cada modem a cada SUPERVISOR_INTERVAL e
+// religa os que caíram, com espera exponencial entre as tentativas
+// (RECONNECT_BACKOFF_MIN dobrando até RECONNECT_BACKOFF_MAX).
+type Supervisor struct {
+	mu     sync.Mutex
+	modems map[string]*ModemSupervision
+	events []SupervisorEvent
+}
+
+var supervisor = &Supervisor{
+	modems: make(map[string]*ModemSupervision),
+	events: make([]SupervisorEvent, 0),
+}
+
+func (s *Supervisor) Run() {
+	ticker := time.NewTicker(SUPERVISOR_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.check()
+	}
+}
+
+func (s *Supervisor) record(event SupervisorEvent) {
+	event.At = time.Now()
+	s.events = append(s.events, event)
+	if len(s.events) > supervisorMaxEvents {
+		s.events = s.events[len(s.events)-supervisorMaxEvents:]
+	}
+	eventBus.Publish("supervisor."+event.Type, event)
+}
+
+// check lê o estado de cada modem. Portas renovando são ignoradas: a
+// renovação derruba o bearer de propósito.
+func (s *Supervisor) check() {
+	now := time.Now()
+
+	for _, link := range linkRegistry.All() {
+		if jobManager.Renewing(link.HTTPPort) {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		info, err := modemBackend.GetModem(ctx, link.ModemID)
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		m, ok := s.modems[link.ModemID]
+		if !ok {
+			m = &ModemSupervision{ModemID: link.ModemID}
+			s.modems[link.ModemID] = m
+		}
+		m.Port = link.HTTPPort
+		m.State = info.State
+
+		down := reconnectStates[info.State]
+		if !down {
+			m.Down = false
+			m.DownSince = nil
+			m.Failures = 0
+			m.NextAttemptAt = nil
+			s.mu.Unlock()
+			continue
+		}
+
+		if !m.Down {
+			m.Down = true
+			m.DownSince = &now
+			s.record(SupervisorEvent{Type: EventModemDown, ModemID: link.ModemID, Port: link.HTTPPort, State: info.State})
+			log.Printf("🔌 Modem %s (porta %d) caiu: estado %s", link.ModemID, link.HTTPPort, info.State)
+		}
+
+		due := m.NextAttemptAt == nil || !now.Before(*m.NextAttemptAt)
+		if m.Recovering || !due {
+			s.mu.Unlock()
+			continue
+		}
+		m.Recovering = true
+		s.mu.Unlock()
+
+		go s.recover(link)
+	}
+}
+
+// recover religa o modem ocupando a vaga da porta, para não concorrer com
+// uma renovação.
+func (s *Supervisor) recover(link ModemLink) {
+	defer func() {
+		s.mu.Lock()
+		if m, ok := s.modems[link.ModemID]; ok {
+			m.Recovering = false
+		}
+		s.mu.Unlock()
+	}()
+
+	slot := jobManager.portSlot(link.HTTPPort)
+	select {
+	case slot <- struct{}{}:
+		defer func() { <-slot }()
+	default:
+		return
+	}
+
+	// O hotplug pode ter esquecido o modem enquanto esperávamos a vaga
+	s.mu.Lock()
+	m, ok := s.modems[link.ModemID]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	attempt := m.Failures + 1
+	s.mu.Unlock()
+
+	log.Printf("🔌 Religando modem %s (porta %d), tentativa %d", link.ModemID, link.HTTPPort, attempt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+	result, err := renewOrchestrator.Reconnect(ctx, link.HTTPPort, supervisorReporter{modemID: link.ModemID})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok = s.modems[link.ModemID]
+	if !ok {
+		return
+	}
+	now := time.Now()
+
+	if err != nil {
+		m.Failures++
+		backoff := expBackoff(m.Failures, RECONNECT_BACKOFF_MIN, RECONNECT_BACKOFF_MAX)
+		next := now.Add(backoff)
+		m.NextAttemptAt = &next
+
+		s.record(SupervisorEvent{
+			Type:      EventReconnectFailed,
+			ModemID:   link.ModemID,
+			Port:      link.HTTPPort,
+			Attempt:   attempt,
+			Error:     err.Error(),
+			NextRetry: backoff.String(),
+		})
+		log.Printf("❌ Falha ao religar modem %s: %v (nova tentativa em %s)", link.ModemID, err, backoff)
+		invalidateCache()
+		return
+	}
+
+	downtime := ""
+	if m.DownSince != nil {
+		downtime = now.Sub(*m.DownSince).Round(time.Second).String()
+	}
+	m.Down = false
+	m.DownSince = nil
+	m.Failures = 0
+	m.NextAttemptAt = nil
+	m.Recoveries++
+	m.LastRecoveredAt = &now
+	m.State = "connected"
+
+	s.record(SupervisorEvent{
+		Type:     EventReconnected,
+		ModemID:  link.ModemID,
+		Port:     link.HTTPPort,
+		Attempt:  attempt,
+		Downtime: downtime,
+		NewIP:    result.NewIP,
+		PublicIP: result.NewPublicIP,
+	})
+	log.Printf("✅ Modem %s religado após %s: IP %s, IP público %s", link.ModemID, downtime, result.NewIP, result.NewPublicIP)
+	invalidateCache()
+}
+
+// Forget descarta o acompanhamento de um modem removido.
+func (s *Supervisor) Forget(modemID string) {
+	s.mu.Lock()
+	delete(s.modems, modemID)
+	s.mu.Unlock()
+}
+
+// expBackoff dobra a espera a cada falha seguida, de min até max.
+func expBackoff(failures int, min, max time.Duration) time.Duration {
+	backoff := min
+	for i := 1; i < failures && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// Status devolve o acompanhamento dos modems e os eventos, do mais recente
+// para o mais antigo.
+func (s *Supervisor) Status() ([]ModemSupervision, []SupervisorEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	modems := make([]ModemSupervision, 0, len(s.modems))
+	for _, m := range s.modems {
+		modems = append(modems, *m)
+	}
+	sort.Slice(modems, func(i, j int) bool { return modems[i].Port < modems[j].Port })
+
+	events := make([]SupervisorEvent, 0, len(s.events))
+	for i := len(s.events) - 1; i >= 0; i-- {
+		events = append(events, s.events[i])
+	}
+	return modems, events
+}
+
+// supervisorReporter leva o progresso da religação para o log.
+type supervisorReporter struct {
+	modemID string
+}
+
+func (r supervisorReporter) Logf(format string, args ...interface{}) {
+	log.Printf("🔌 Modem %s: %s", r.modemID, fmt.Sprintf(format, args...))
+}
+
+func (r supervisorReporter) StepFinished(step JobStep) {
+	if step.Error != "" {
+		log.Printf("🔌 Modem %s: etapa %s falhou em %s: %s", r.modemID, step.Name, step.Duration, step.Error)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// TRÁFEGO DOS PROXIES
+// ============================================================================
+
+const (
+	trafficFlushInterval = time.Minute
+	trafficHourlyKeep    = 7 * 24 // baldes de 1h por porta
+	trafficDailyKeep     = 400    // baldes de 1 dia por porta
+)
+
+const (
+	TrafficPeriodHour = "hour"
+	TrafficPeriodDay  = "day"
+)
+
+// TrafficCounters somam o tráfego que saiu pelo modem. BytesIn é o que veio
+// do destino para o cliente (download) e BytesOut o que o cliente enviou.
+// Requests conta requisições HTTP, CONNECT e comandos SOCKS5; Connections,
+// as conexões de cliente (uma conexão HTTP keep-alive conta uma vez).
+type TrafficCounters struct {
+	BytesIn     int64 `json:"bytes_in"`
+	BytesOut    int64 `json:"bytes_out"`
+	Connections int64 `json:"connections"`
+	Requests    int64 `json:"requests"`
+}
+
+func (c *TrafficCounters) add(other TrafficCounters) {
+	c.BytesIn += other.BytesIn
+	c.BytesOut += other.BytesOut
+	c.Connections += other.Connections
+	c.Requests += other.Requests
+}
+
+// TrafficBucket é o tráfego de uma hora ou um dia (hora local), no total e
+// por usuário do proxy. Tráfego sem usuário (proxy sem autenticação) entra
+// só no total.
+type TrafficBucket struct {
+	At time.Time `json:"at"`
+	TrafficCounters
+	Users map[string]*TrafficCounters `json:"users,omitempty"`
+}
+
+func (b *TrafficBucket) add(user string, delta TrafficCounters) {
+	b.TrafficCounters.add(delta)
+	if user == "" {
+		return
+	}
+	if b.Users == nil {
+		b.Users = make(map[string]*TrafficCounters)
+	}
+	counters, ok := b.Users[user]
+	if !ok {
+		counters = &TrafficCounters{}
+		b.Users[user] = counters
+	}
+	counters.add(delta)
+}
+
+// portTraffic são os baldes de uma porta HTTP, do mais antigo para o mais
+// recente.
+type portTraffic struct {
+	Port   int              `json:"port"`
+	Hourly []*TrafficBucket `json:"hourly"`
+	Daily  []*TrafficBucket `json:"daily"`
+}
+
+// TrafficStats contabiliza o tráfego de cada proxy a partir dos próprios
+// listeners e guarda os baldes em DATA_DIR/traffic.json, gravado a cada
+// minuto.
+type TrafficStats struct {
+	mu    sync.Mutex
+	ports map[int]*portTraffic
+	path  string
+	dirty bool
+}
+
+var trafficStats = &TrafficStats{
+	ports: make(map[int]*portTraffic),
+}
+
+func (t *TrafficStats) SetDataDir(dir string) {
+	t.mu.Lock()
+	t.path = filepath.Join(dir, "traffic.json")
+	t.mu.Unlock()
+}
+
+func (t *TrafficStats) Load() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var ports []*portTraffic
+	if err := readJSONFile(t.path, &ports); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("erro ao ler tráfego dos proxies: %v", err)
+	}
+
+	for _, p := range ports {
+		t.ports[p.Port] = p
+	}
+	return nil
+}
+
+func (t *TrafficStats) save() error {
+	if t.path == "" {
+		return nil
+	}
+
+	ports := make([]*portTraffic, 0, len(t.ports))
+	for _, p := range t.ports {
+		ports = append(ports, p)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Port < ports[j].Port })
+
+	if err := writeJSONFile(t.path, ports); err != nil {
+		return fmt.Errorf("erro ao salvar tráfego dos proxies: %v", err)
+	}
+	t.dirty = false
+	return nil
+}
+
+// Run grava os contadores alterados a cada trafficFlushInterval.
+func (t *TrafficStats) Run() {
+	ticker := time.NewTicker(trafficFlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.mu.Lock()
+		if t.dirty {
+			if err := t.save(); err != nil {
+				log.Printf("⚠️  %v", err)
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// Flush grava os contadores pendentes (no desligamento).
+func (t *TrafficStats) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return nil
+	}
+	return t.save()
+}
+
+// trafficBucketStart é o início da hora ou do dia (hora local) de at.
+func trafficBucketStart(at time.Time, period string) time.Time {
+	if period == TrafficPeriodDay {
+		return time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+	}
+	return time.Date(at.Year(), at.Month(), at.Day(), at.Hour(), 0, 0, 0, at.Location())
+}
+
+// addBucket soma delta ao balde corrente da lista, abrindo um novo se o
+// período virou, e descarta os mais antigos que keep.
+func addBucket(buckets []*TrafficBucket, start time.Time, keep int, user string, delta TrafficCounters) []*TrafficBucket {
+	if n := len(buckets); n == 0 || !buckets[n-1].At.Equal(start) {
+		buckets = append(buckets, &TrafficBucket{At: start})
+		if len(buckets) > keep {
+			buckets = buckets[len(buckets)-keep:]
+		}
+	}
+	buckets[len(buckets)-1].add(user, delta)
+	return buckets
+}
+
+// Add soma delta à porta HTTP, no total e no usuário (vazio sem
+// autenticação).
+func (t *TrafficStats) Add(port int, user string, delta TrafficCounters) {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.ports[port]
+	if !ok {
+		p = &portTraffic{Port: port}
+		t.ports[port] = p
+	}
+	p.Hourly = addBucket(p.Hourly, trafficBucketStart(now, TrafficPeriodHour), trafficHourlyKeep, user, delta)
+	p.Daily = addBucket(p.Daily, trafficBucketStart(now, TrafficPeriodDay), trafficDailyKeep, user, delta)
+	t.dirty = true
+}
+
+// TrafficReport é a resposta de GET /proxies/{port}/traffic: os baldes do
+// período desde Since e a soma deles, no total e por usuário.
+type TrafficReport struct {
+	Port    int                         `json:"port"`
+	Period  string                      `json:"period"`
+	Since   time.Time                   `json:"since"`
+	Buckets []TrafficBucket             `json:"buckets"`
+	Total   TrafficCounters             `json:"total"`
+	Users   map[string]*TrafficCounters `json:"users"`
+}
+
+func (t *TrafficStats) Report(port int, period string, since time.Time) TrafficReport {
+	report := TrafficReport{
+		Port:    port,
+		Period:  period,
+		Since:   since,
+		Buckets: make([]TrafficBucket, 0),
+		Users:   make(map[string]*TrafficCounters),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.ports[port]
+	if !ok {
+		return report
+	}
+
+	buckets := p.Hourly
+	if period == TrafficPeriodDay {
+		buckets = p.Daily
+	}
+
+	first := trafficBucketStart(since, period)
+	for _, b := range buckets {
+		if b.At.Before(first) {
+			continue
+		}
+
+		copied := TrafficBucket{At: b.At, TrafficCounters: b.TrafficCounters}
+		if len(b.Users) > 0 {
+			copied.Users = make(map[string]*TrafficCounters, len(b.Users))
+		}
+		for user, counters := range b.Users {
+			c := *counters
+			copied.Users[user] = &c
+
+			total, ok := report.Users[user]
+			if !ok {
+				total = &TrafficCounters{}
+				report.Users[user] = total
+			}
+			total.add(*counters)
+		}
+		report.Buckets = append(report.Buckets, copied)
+		report.Total.add(b.TrafficCounters)
+	}
+	return report
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// USUÁRIOS DOS PROXIES
+// ============================================================================
+
+const (
+	passwordIterations = 100000
+	passwordKeyLength  = 32
+	passwordSaltLength = 16
+
+	// Cada usuário aceita authFailureLimit senhas erradas por
+	// authFailureWindow; além disso a senha nem é conferida, para que
+	// tentativas sem credenciais válidas não prendam a CPU no PBKDF2.
+	authFailureLimit  = 10
+	authFailureWindow = time.Minute
+)
+
+var (
+	errProxyAuthRequired  = errors.New("autenticação de proxy obrigatória")
+	errProxyAuthFailed    = errors.New("usuário ou senha inválidos")
+	errProxyUserExpired   = errors.New("usuário expirado")
+	errProxyForbidden     = errors.New("usuário sem permissão para este modem")
+	errProxyAuthThrottled = errors.New("muitas senhas inválidas, tente novamente mais tarde")
+
+	validUsername = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+)
+
+// ProxyUser é uma conta de acesso aos proxies. Sem Ports e sem Modems, o
+// usuário pode usar todos os modems. Modems guarda a identidade (IMEI/ICCID)
+// de cada modem, que não muda com a renumeração do ModemManager.
+type ProxyUser struct {
+	Username     string     `json:"username"`
+	PasswordHash string     `json:"password_hash,omitempty"`
+	Salt         string     `json:"salt,omitempty"`
+	Ports        []int      `json:"ports"`
+	Modems       []string   `json:"modems"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// UserInput são os campos aceitos na criação e atualização via API.
+type UserInput struct {
+	Username  string     `json:"username"`
+	Password  string     `json:"password"`
+	Ports     []int      `json:"ports"`
+	Modems    []string   `json:"modems"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type UserStore struct {
+	mu       sync.RWMutex
+	users    map[string]*ProxyUser
+	path     string
+	verified map[string]string // usuário → hash da última senha aceita
+	failures map[string]*authFailures
+}
+
+// authFailures conta as senhas erradas de um usuário desde Since.
+type authFailures struct {
+	Count int
+	Since time.Time
+}
+
+var userStore = &UserStore{
+	users:    make(map[string]*ProxyUser),
+	verified: make(map[string]string),
+	failures: make(map[string]*authFailures),
+}
+
+func (us *UserStore) SetDataDir(dir string) {
+	us.mu.Lock()
+	us.path = filepath.Join(dir, "users.json")
+	us.mu.Unlock()
+}
+
+func (us *UserStore) Load() error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	var users []*ProxyUser
+	if err := readJSONFile(us.path, &users); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("erro ao ler usuários: %v", err)
+	}
+
+	for _, user := range users {
+		us.users[user.Username] = user
+	}
+	return nil
+}
+
+// MigrateModemRefs troca pela identidade os índices de modem gravados em
+// Modems por versões anteriores. Índices sem modem ficam como estão (não
+// casam com nenhuma identidade) e são tentados de novo a cada modem
+// conectado pelo hotplug.
+func (us *UserStore) MigrateModemRefs(ctx context.Context, backend ModemBackend) {
+	us.mu.RLock()
+	var refs []string
+	for _, user := range us.users {
+		for _, ref := range user.Modems {
+			if legacyModemRef(ref) {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	us.mu.RUnlock()
+	if len(refs) == 0 {
+		return
+	}
+
+	migrated := migrateModemRefs(ctx, backend, refs)
+	if len(migrated) == 0 {
+		return
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	for _, user := range us.users {
+		for i, ref := range user.Modems {
+			if identity, ok := migrated[ref]; ok {
+				user.Modems[i] = identity
+				log.Printf("👤 Usuário %s: modem %s → %s", user.Username, ref, identity)
+			}
+		}
+	}
+	if err := us.save(); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+}
+
+func (us *UserStore) save() error {
+	if us.path == "" {
+		return nil
+	}
+
+	users := make([]*ProxyUser, 0, len(us.users))
+	for _, user := range us.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+
+	if err := writeJSONFile(us.path, users); err != nil {
+		return fmt.Errorf("erro ao salvar usuários: %v", err)
+	}
+	return nil
+}
+
+// Enabled indica se a autenticação está ativa: basta existir um usuário.
+func (us *UserStore) Enabled() bool {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+	return len(us.users) > 0
+}
+
+func hashPassword(password string, salt []byte) (string, error) {
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, passwordKeyLength)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+func quickHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func (user *ProxyUser) setPassword(password string) error {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	hash, err := hashPassword(password, salt)
+	if err != nil {
+		return err
+	}
+
+	user.Salt = hex.EncodeToString(salt)
+	user.PasswordHash = hash
+	return nil
+}
+
+// public devolve uma cópia sem hash e salt, para a API.
+func (user *ProxyUser) public() ProxyUser {
+	copied := *user
+	copied.PasswordHash = ""
+	copied.Salt = ""
+	copied.Ports = append([]int{}, user.Ports...)
+	copied.Modems = append([]string{}, user.Modems...)
+	return copied
+}
+
+func (user *ProxyUser) expired() bool {
+	return user.ExpiresAt != nil && time.Now().After(*user.ExpiresAt)
+}
+
+// CanUse informa se o usuário pode sair pelo modem do link.
+func (user *ProxyUser) CanUse(link ModemLink) bool {
+	if len(user.Ports) == 0 && len(user.Modems) == 0 {
+		return true
+	}
+	for _, port := range user.Ports {
+		if port == link.HTTPPort || port == link.SOCKSPort {
+			return true
+		}
+	}
+	for _, identity := range user.Modems {
+		if link.Identity != "" && identity == link.Identity {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate valida usuário e senha. O PBKDF2 só roda quando a senha muda;
+// conexões seguintes com a mesma senha usam o hash rápido guardado, mesmo
+// com o usuário bloqueado por senhas erradas.
+func (us *UserStore) Authenticate(username, password string) (*ProxyUser, error) {
+	us.mu.RLock()
+	user, ok := us.users[username]
+	cached := us.verified[username]
+	us.mu.RUnlock()
+
+	if !ok {
+		return nil, errProxyAuthFailed
+	}
+	if user.expired() {
+		return nil, errProxyUserExpired
+	}
+
+	quick := quickHash(password)
+	if cached != "" && subtle.ConstantTimeCompare([]byte(cached), []byte(quick)) == 1 {
+		return user, nil
+	}
+
+	if us.throttled(username) {
+		return nil, errProxyAuthThrottled
+	}
+
+	salt, err := hex.DecodeString(user.Salt)
+	if err != nil {
+		return nil, errProxyAuthFailed
+	}
+	hash, err := hashPassword(password, salt)
+	if err != nil || subtle.ConstantTimeCompare([]byte(hash), []byte(user.PasswordHash)) != 1 {
+		us.recordFailure(username)
+		return nil, errProxyAuthFailed
+	}
+
+	us.mu.Lock()
+	if us.users[username] == user {
+		us.verified[username] = quick
+	}
+	delete(us.failures, username)
+	us.mu.Unlock()
+
+	return user, nil
+}
+
+// throttled informa se o usuário esgotou as senhas erradas da janela atual.
+func (us *UserStore) throttled(username string) bool {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	f, ok := us.failures[username]
+	return ok && f.Count >= authFailureLimit && time.Since(f.Since) < authFailureWindow
+}
+
+func (us *UserStore) recordFailure(username string) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	f, ok := us.failures[username]
+	if !ok || time.Since(f.Since) >= authFailureWindow {
+		f = &authFailures{Since: time.Now()}
+		us.failures[username] = f
+	}
+	f.Count++
+}
+
+func validateUserInput(input UserInput, creating bool) error {
+	if creating {
+		if !validUsername.MatchString(input.Username) {
+			return fmt.Errorf("nome de usuário inválido (use letras, números, '.', '_' ou '-')")
+		}
+		if strings.Contains(input.Username, sessionMarker) {
+			return fmt.Errorf("nome de usuário não pode conter %q", sessionMarker)
+		}
+		if input.Password == "" {
+			return fmt.Errorf("senha é obrigatória")
+		}
+	}
+	ports := currentConfig().Ports
+	for _, port := range input.Ports {
+		http := port > ports.BaseHTTP && port <= ports.BaseHTTP+ports.MaxModems
+		socks := port > ports.BaseSOCKS && port <= ports.BaseSOCKS+ports.MaxModems
+		if !http && !socks {
+			return fmt.Errorf("porta inválida: %d (use %d-%d ou %d-%d)", port,
+				ports.BaseHTTP+1, ports.BaseHTTP+ports.MaxModems, ports.BaseSOCKS+1, ports.BaseSOCKS+ports.MaxModems)
+		}
+	}
+	return nil
+}
+
+func (us *UserStore) List() []ProxyUser {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	users := make([]ProxyUser, 0, len(us.users))
+	for _, user := range us.users {
+		users = append(users, user.public())
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+func (us *UserStore) Create(input UserInput) (ProxyUser, error) {
+	if err := validateUserInput(input, true); err != nil {
+		return ProxyUser{}, err
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if _, exists := us.users[input.Username]; exists {
+		return ProxyUser{}, fmt.Errorf("usuário %s já existe", input.Username)
+	}
+
+	now := time.Now()
+	user := &ProxyUser{
+		Username:  input.Username,
+		Ports:     input.Ports,
+		Modems:    input.Modems,
+		ExpiresAt: input.ExpiresAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := user.setPassword(input.Password); err != nil {
+		return ProxyUser{}, fmt.Errorf("erro ao gerar hash da senha: %v", err)
+	}
+
+	us.users[user.Username] = user
+	if err := us.save(); err != nil {
+		delete(us.users, user.Username)
+		return ProxyUser{}, err
+	}
+
+	log.Printf("👤 Usuário de proxy %s criado", user.Username)
+	return user.public(), nil
+}
+
+// Update altera apenas os campos enviados. "ports": [] e "modems": [] liberam
+// todos os modems.
+func (us *UserStore) Update(username string, input UserInput) (ProxyUser, error) {
+	if err := validateUserInput(input, false); err != nil {
+		return ProxyUser{}, err
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	current, ok := us.users[username]
+	if !ok {
+		return ProxyUser{}, fmt.Errorf("usuário %s não encontrado", username)
+	}
+
+	updated := *current
+	if input.Ports != nil {
+		updated.Ports = input.Ports
+	}
+	if input.Modems != nil {
+		updated.Modems = input.Modems
+	}
+	if input.ExpiresAt != nil {
+		updated.ExpiresAt = input.ExpiresAt
+	}
+	updated.UpdatedAt = time.Now()
+	if input.Password != "" {
+		if err := updated.setPassword(input.Password); err != nil {
+			return ProxyUser{}, fmt.Errorf("erro ao gerar hash da senha: %v", err)
+		}
+	}
+
+	us.users[username] = &updated
+	delete(us.verified, username)
+	delete(us.failures, username)
+	if err := us.save(); err != nil {
+		us.users[username] = current
+		return ProxyUser{}, err
+	}
+
+	log.Printf("👤 Usuário de proxy %s atualizado", username)
+	return updated.public(), nil
+}
+
+func (us *UserStore) Delete(username string) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	user, ok := us.users[username]
+	if !ok {
+		return fmt.Errorf("usuário %s não encontrado", username)
+	}
+	// Sem usuários os proxies voltariam a ficar abertos (ver Enabled).
+	if len(us.users) == 1 {
+		return fmt.Errorf("usuário %s é o último: removê-lo deixaria os proxies sem autenticação", username)
+	}
+
+	delete(us.users, username)
+	delete(us.verified, username)
+	delete(us.failures, username)
+	if err := us.save(); err != nil {
+		us.users[username] = user
+		return err
+	}
+
+	log.Printf("👤 Usuário de proxy %s removido", username)
+	return nil
+}
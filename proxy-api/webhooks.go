@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// WEBHOOKS
+// ============================================================================
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+
+	webhookSecretPrefix = "whsec_"
+	webhookLogLimit     = 200 // entregas terminadas guardadas por assinatura
+	webhookConcurrency  = 4
+)
+
+// WebhookSubscription recebe por POST os eventos de /events cujo tipo bate
+// com Events (tipo exato ou categoria; vazio = todos).
+type WebhookSubscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookInput é o corpo do POST e do PUT; no PUT só os campos enviados
+// mudam.
+type WebhookInput struct {
+	URL     *string  `json:"url"`
+	Events  []string `json:"events"`
+	Secret  *string  `json:"secret"`
+	Enabled *bool    `json:"enabled"`
+}
+
+// WebhookDelivery é um evento a entregar para uma assinatura. Fica pending
+// até a URL responder 2xx ou as tentativas acabarem; ReplayOf aponta a
+// entrega original quando ela foi reenviada manualmente.
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	EventID        uint64          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	State          string          `json:"state"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at,omitempty"`
+	LastStatus     int             `json:"last_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	ReplayOf       string          `json:"replay_of,omitempty"`
+	ReplayedBy     string          `json:"replayed_by,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+
+	sending bool
+}
+
+// WebhookManager guarda as assinaturas em DATA_DIR/webhooks.json e a fila
+// de entregas (pendentes e histórico) em DATA_DIR/webhook-deliveries.json,
+// para a fila sobreviver a um restart.
+type WebhookManager struct {
+	mu             sync.Mutex
+	subscriptions  map[string]*WebhookSubscription
+	deliveries     []*WebhookDelivery
+	subsPath       string
+	deliveriesPath string
+	client         *http.Client
+}
+
+var webhooks = &WebhookManager{
+	subscriptions: make(map[string]*WebhookSubscription),
+	deliveries:    make([]*WebhookDelivery, 0),
+	client:        &http.Client{Timeout: WEBHOOK_TIMEOUT},
+}
+
+func (wm *WebhookManager) SetDataDir(dir string) {
+	wm.mu.Lock()
+	wm.subsPath = filepath.Join(dir, "webhooks.json")
+	wm.deliveriesPath = filepath.Join(dir, "webhook-deliveries.json")
+	wm.mu.Unlock()
+}
+
+func (wm *WebhookManager) Load() error {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	var subscriptions []*WebhookSubscription
+	if err := readJSONFile(wm.subsPath, &subscriptions); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("erro ao ler webhooks: %v", err)
+	}
+	for _, sub := range subscriptions {
+		wm.subscriptions[sub.ID] = sub
+	}
+
+	if err := readJSONFile(wm.deliveriesPath, &wm.deliveries); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("erro ao ler entregas de webhooks: %v", err)
+	}
+	return nil
+}
+
+func (wm *WebhookManager) saveSubscriptions() error {
+	if wm.subsPath == "" {
+		return nil
+	}
+
+	subscriptions := make([]*WebhookSubscription, 0, len(wm.subscriptions))
+	for _, sub := range wm.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt) })
+
+	if err := writeJSONFile(wm.subsPath, subscriptions); err != nil {
+		return fmt.Errorf("erro ao salvar webhooks: %v", err)
+	}
+	return nil
+}
+
+// saveDeliveries descarta as entregas terminadas além das webhookLogLimit
+// mais recentes de cada assinatura e grava o resto.
+func (wm *WebhookManager) saveDeliveries() {
+	finished := make(map[string]int)
+	kept := make([]*WebhookDelivery, 0, len(wm.deliveries))
+	for i := len(wm.deliveries) - 1; i >= 0; i-- {
+		delivery := wm.deliveries[i]
+		if delivery.State != DeliveryPending {
+			finished[delivery.SubscriptionID]++
+			if finished[delivery.SubscriptionID] > webhookLogLimit {
+				continue
+			}
+		}
+		kept = append(kept, delivery)
+	}
+	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+		kept[i], kept[j] = kept[j], kept[i]
+	}
+	wm.deliveries = kept
+
+	if wm.deliveriesPath == "" {
+		return
+	}
+	if err := writeJSONFile(wm.deliveriesPath, wm.deliveries); err != nil {
+		log.Printf("⚠️  Erro ao salvar entregas de webhooks: %v", err)
+	}
+}
+
+func (sub *WebhookSubscription) public() WebhookSubscription {
+	copied := *sub
+	copied.Secret = ""
+	copied.Events = append([]string{}, sub.Events...)
+	return copied
+}
+
+func (input WebhookInput) apply(sub *WebhookSubscription) {
+	if input.URL != nil {
+		sub.URL = strings.TrimSpace(*input.URL)
+	}
+	if input.Events != nil {
+		sub.Events = input.Events
+	}
+	if input.Secret != nil {
+		sub.Secret = *input.Secret
+	}
+	if input.Enabled != nil {
+		sub.Enabled = *input.Enabled
+	}
+}
+
+func (sub *WebhookSubscription) validate() error {
+	parsed, err := url.Parse(sub.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("url inválida: %q (use http:// ou https://)", sub.URL)
+	}
+
+	for _, filter := range sub.Events {
+		known := false
+		for _, eventType := range eventTypes {
+			if matchEventType([]string{filter}, eventType) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("tipo de evento desconhecido: %q", filter)
+		}
+	}
+	return nil
+}
+
+func newWebhookSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return webhookSecretPrefix + hex.EncodeToString(b)
+}
+
+// Create devolve a assinatura e o segredo, que não é mostrado novamente.
+func (wm *WebhookManager) Create(input WebhookInput) (WebhookSubscription, string, error) {
+	now := time.Now()
+	sub := &WebhookSubscription{
+		ID:        newJobID(),
+		Events:    make([]string, 0),
+		Enabled:   true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	input.apply(sub)
+	if err := sub.validate(); err != nil {
+		return WebhookSubscription{}, "", err
+	}
+	if sub.Secret == "" {
+		sub.Secret = newWebhookSecret()
+	}
+
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	wm.subscriptions[sub.ID] = sub
+	if err := wm.saveSubscriptions(); err != nil {
+		delete(wm.subscriptions, sub.ID)
+		return WebhookSubscription{}, "", err
+	}
+
+	log.Printf("🪝 Webhook %s criado para %s", sub.ID, sub.URL)
+	return sub.public(), sub.Secret, nil
+}
+
+func (wm *WebhookManager) Update(id string, input WebhookInput) (WebhookSubscription, error) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	current, ok := wm.subscriptions[id]
+	if !ok {
+		return WebhookSubscription{}, fmt.Errorf("webhook %s não encontrado", id)
+	}
+
+	updated := *current
+	input.apply(&updated)
+	if err := updated.validate(); err != nil {
+		return WebhookSubscription{}, err
+	}
+	if updated.Secret == "" {
+		return WebhookSubscription{}, fmt.Errorf("secret não pode ser vazio")
+	}
+	updated.UpdatedAt = time.Now()
+
+	wm.subscriptions[id] = &updated
+	if err := wm.saveSubscriptions(); err != nil {
+		wm.subscriptions[id] = current
+		return WebhookSubscription{}, err
+	}
+
+	log.Printf("🪝 Webhook %s atualizado", id)
+	return updated.public(), nil
+}
+
+// Delete remove a assinatura e as entregas dela.
+func (wm *WebhookManager) Delete(id string) error {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	sub, ok := wm.subscriptions[id]
+	if !ok {
+		return fmt.Errorf("webhook %s não encontrado", id)
+	}
+
+	delete(wm.subscriptions, id)
+	if err := wm.saveSubscriptions(); err != nil {
+		wm.subscriptions[id] = sub
+		return err
+	}
+
+	kept := make([]*WebhookDelivery, 0, len(wm.deliveries))
+	for _, delivery := range wm.deliveries {
+		if delivery.SubscriptionID != id {
+			kept = append(kept, delivery)
+		}
+	}
+	wm.deliveries = kept
+	wm.saveDeliveries()
+
+	log.Printf("🪝 Webhook %s removido", id)
+	return nil
+}
+
+func (wm *WebhookManager) List() []WebhookSubscription {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	subscriptions := make([]WebhookSubscription, 0, len(wm.subscriptions))
+	for _, sub := range wm.subscriptions {
+		subscriptions = append(subscriptions, sub.public())
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt) })
+	return subscriptions
+}
+
+// Deliveries devolve o log de entregas da assinatura, da mais recente para
+// a mais antiga, opcionalmente filtrado por estado.
+func (wm *WebhookManager) Deliveries(id, state string) ([]WebhookDelivery, error) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	if _, ok := wm.subscriptions[id]; !ok {
+		return nil, fmt.Errorf("webhook %s não encontrado", id)
+	}
+
+	deliveries := make([]WebhookDelivery, 0)
+	for i := len(wm.deliveries) - 1; i >= 0; i-- {
+		delivery := wm.deliveries[i]
+		if delivery.SubscriptionID == id && (state == "" || delivery.State == state) {
+			deliveries = append(deliveries, *delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+// Replay reenfileira entregas que falharam. Com deliveryID vazio, todas as
+// falhas da assinatura que ainda não foram reenviadas.
+func (wm *WebhookManager) Replay(id, deliveryID string) ([]WebhookDelivery, error) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	if _, ok := wm.subscriptions[id]; !ok {
+		return nil, fmt.Errorf("webhook %s não encontrado", id)
+	}
+
+	originals := make([]*WebhookDelivery, 0)
+	for _, delivery := range wm.deliveries {
+		if delivery.SubscriptionID != id {
+			continue
+		}
+		if deliveryID != "" && delivery.ID == deliveryID {
+			if delivery.State != DeliveryFailed {
+				return nil, fmt.Errorf("entrega %s não falhou (estado %s)", deliveryID, delivery.State)
+			}
+			originals = append(originals, delivery)
+			break
+		}
+		if deliveryID == "" && delivery.State == DeliveryFailed && delivery.ReplayedBy == "" {
+			originals = append(originals, delivery)
+		}
+	}
+	if deliveryID != "" && len(originals) == 0 {
+		return nil, fmt.Errorf("entrega %s não encontrada", deliveryID)
+	}
+
+	now := time.Now()
+	replays := make([]WebhookDelivery, 0, len(originals))
+	for _, original := range originals {
+		replay := &WebhookDelivery{
+			ID:             newJobID(),
+			SubscriptionID: id,
+			EventID:        original.EventID,
+			EventType:      original.EventType,
+			Payload:        original.Payload,
+			State:          DeliveryPending,
+			NextAttemptAt:  &now,
+			ReplayOf:       original.ID,
+			CreatedAt:      now,
+		}
+		original.ReplayedBy = replay.ID
+		wm.deliveries = append(wm.deliveries, replay)
+		replays = append(replays, *replay)
+	}
+	wm.saveDeliveries()
+
+	if len(replays) > 0 {
+		log.Printf("🪝 Webhook %s: %d entrega(s) reenfileirada(s)", id, len(replays))
+	}
+	return replays, nil
+}
+
+// ============================================================================
+// WEBHOOKS - ENTREGA
+// ============================================================================
+
+// Run acompanha o stream de eventos e, a cada WEBHOOK_TICK, envia as
+// entregas vencidas.
+func (wm *WebhookManager) Run() {
+	go wm.consume()
+
+	ticker := time.NewTicker(WEBHOOK_TICK)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		wm.deliverDue()
+	}
+}
+
+// consume enfileira uma entrega por assinatura interessada em cada evento.
+// Se o barramento desconectar a inscrição (fila cheia), ela é refeita a
+// partir do último evento visto.
+func (wm *WebhookManager) consume() {
+	var lastID uint64
+	for {
+		sub := eventBus.Subscribe(nil, lastID)
+		for event := range sub.ch {
+			lastID = event.ID
+			wm.enqueue(event)
+		}
+		log.Printf("⚠️  Webhooks ficaram para trás no stream de eventos, retomando do evento %d", lastID)
+	}
+}
+
+func (wm *WebhookManager) enqueue(event Event) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	var payload []byte
+	now := time.Now()
+	queued := false
+
+	for _, sub := range wm.subscriptions {
+		if !sub.Enabled || !matchEventType(sub.Events, event.Type) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(event); err != nil {
+				log.Printf("⚠️  Evento %d não serializável: %v", event.ID, err)
+				return
+			}
+		}
+
+		wm.deliveries = append(wm.deliveries, &WebhookDelivery{
+			ID:             newJobID(),
+			SubscriptionID: sub.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Payload:        payload,
+			State:          DeliveryPending,
+			NextAttemptAt:  &now,
+			CreatedAt:      now,
+		})
+		queued = true
+	}
+
+	if queued {
+		wm.saveDeliveries()
+	}
+}
+
+type webhookAttempt struct {
+	delivery *WebhookDelivery
+	url      string
+	secret   string
+}
+
+func (wm *WebhookManager) deliverDue() {
+	wm.mu.Lock()
+	now := time.Now()
+	attempts := make([]webhookAttempt, 0)
+	for _, delivery := range wm.deliveries {
+		if delivery.State != DeliveryPending || delivery.sending {
+			continue
+		}
+		if delivery.NextAttemptAt != nil && now.Before(*delivery.NextAttemptAt) {
+			continue
+		}
+		sub, ok := wm.subscriptions[delivery.SubscriptionID]
+		if !ok || !sub.Enabled {
+			continue
+		}
+		delivery.sending = true
+		attempts = append(attempts, webhookAttempt{delivery: delivery, url: sub.URL, secret: sub.Secret})
+	}
+	wm.mu.Unlock()
+
+	slots := make(chan struct{}, webhookConcurrency)
+	var wg sync.WaitGroup
+	for _, attempt := range attempts {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(attempt webhookAttempt) {
+			defer func() { <-slots; wg.Done() }()
+			status, err := wm.send(attempt)
+			wm.finish(attempt.delivery, status, err)
+		}(attempt)
+	}
+	wg.Wait()
+}
+
+// signWebhook assina "<timestamp>.<corpo>" com HMAC-SHA256.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (wm *WebhookManager) send(attempt webhookAttempt) (int, error) {
+	delivery := attempt.delivery
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest("POST", attempt.url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "proxy-api-webhooks")
+	req.Header.Set("X-Webhook-Id", delivery.ID)
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", signWebhook(attempt.secret, timestamp, delivery.Payload))
+
+	resp, err := wm.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (wm *WebhookManager) finish(delivery *WebhookDelivery, status int, err error) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	now := time.Now()
+	delivery.sending = false
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.LastStatus = status
+	delivery.LastError = ""
+
+	switch {
+	case err == nil:
+		delivery.State = DeliverySucceeded
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+	case delivery.Attempts >= WEBHOOK_MAX_ATTEMPTS:
+		delivery.State = DeliveryFailed
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = nil
+		log.Printf("❌ Webhook %s: entrega %s (%s) falhou após %d tentativas: %v",
+			delivery.SubscriptionID, delivery.ID, delivery.EventType, delivery.Attempts, err)
+	default:
+		delivery.LastError = err.Error()
+		next := now.Add(expBackoff(delivery.Attempts, WEBHOOK_BACKOFF_MIN, WEBHOOK_BACKOFF_MAX))
+		delivery.NextAttemptAt = &next
+	}
+
+	wm.saveDeliveries()
+}
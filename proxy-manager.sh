@@ -2,7 +2,7 @@
 
 # ============================================================================
 # Sistema de Gerenciamento de Proxies Multi-Modem
-# Versão: 2.0 - Instância 3proxy por modem (Suporte até 100 modems)
+# Versão: 2.1 - Proxies servidos pela proxy-api (Suporte até 100 modems)
 # ============================================================================
 
 set -euo pipefail
@@ -11,15 +11,18 @@ set -euo pipefail
 # CONFIGURAÇÕES
 # ============================================================================
 
-APN="zap.vivo.com.br"
-USER="vivo"
-PASS="vivo"
+# APN usado quando nenhum perfil da proxy-api casa com o SIM do modem
+DEFAULT_APN="zap.vivo.com.br"
+DEFAULT_APN_USER="vivo"
+DEFAULT_APN_PASS="vivo"
+API_DATA_DIR="${DATA_DIR:-/var/lib/proxy-api}"
+APN_PROFILES_FILE="$API_DATA_DIR/apn-profiles.json"
+APN_OVERRIDES_FILE="$API_DATA_DIR/apn-overrides.json"
+PORT_MAP_FILE="$API_DATA_DIR/port-map.json"
 BASE_PROXY_PORT=6000   # Portas HTTP: 6001-6100
 BASE_SOCKS_PORT=7000   # Portas SOCKS5: 7001-7100
 MAX_MODEMS=100
 STATUS_FILE="/var/run/proxy-status.json"
-LOG_DIR="/var/log/3proxy"
-CONFIG_DIR="/etc/3proxy"
 PID_DIR="/var/run"
 
 # Arrays globais para modems detectados
@@ -29,6 +32,8 @@ declare -a DETECTED_IPS=()
 declare -a DETECTED_GATEWAYS=()
 declare -a DETECTED_PREFIXES=()
 declare -a DETECTED_PORTS=()
+declare -a DETECTED_SOCKS_PORTS=()
+declare -a DETECTED_IDENTITIES=()
 MODEM_INDEX=""  # ← ADICIONAR ESTA LINHA
 # ============================================================================
 # FUNÇÕES AUXILIARES
@@ -58,7 +63,158 @@ check_root() {
 }
 
 create_directories() {
-    mkdir -p "$LOG_DIR" "$CONFIG_DIR" "$PID_DIR"
+    mkdir -p "$PID_DIR"
+}
+
+# ============================================================================
+# PERFIS DE APN
+# ============================================================================
+
+# MCC/MNC da operadora do SIM ativo do modem (vazio se não houver SIM)
+get_sim_operator() {
+    local modem_id=$1
+    local sim
+    sim=$(mmcli -m "$modem_id" 2>/dev/null | grep -o "SIM/[0-9]*" | head -1 | cut -d/ -f2 || true)
+    if [ -z "$sim" ]; then
+        return 0
+    fi
+    mmcli -i "$sim" 2>/dev/null | grep -w "operator id:" | awk -F': ' '{print $2}' | tr -d "' " || true
+}
+
+# Define APN, USER, PASS e IP_TYPE do modem com a mesma regra da proxy-api:
+# APN fixado no modem (pelo IMEI/ICCID), perfil que lista o MCC/MNC do SIM ou
+# o APN padrão.
+resolve_apn() {
+    local modem_id=$1
+    local operator identity
+    operator=$(get_sim_operator "$modem_id")
+    identity=$(get_modem_identity "$modem_id")
+
+    APN="$DEFAULT_APN"
+    USER="$DEFAULT_APN_USER"
+    PASS="$DEFAULT_APN_PASS"
+    IP_TYPE="ipv4"
+    APN_SOURCE="padrão"
+
+    local profiles overrides
+    profiles=$(cat "$APN_PROFILES_FILE" 2>/dev/null || echo "[]")
+    overrides=$(cat "$APN_OVERRIDES_FILE" 2>/dev/null || echo "[]")
+
+    local -a fields=()
+    mapfile -t fields < <(jq -rn --arg id "$identity" --arg op "$operator" \
+        --argjson profiles "$profiles" --argjson overrides "$overrides" '
+        ($overrides | map(select($id != "" and .identity == $id)) | first) as $o
+        | (if $o == null then null
+           elif ($o.profile // "") != "" then
+             ($profiles | map(select(.id == $o.profile)) | first)
+             | if . == null then null else . + {source: "fixado no modem, perfil \(.id)"} end
+           else $o + {source: "fixado no modem"} end) as $fixed
+        | ($profiles | map(select((.operators // []) | index($op))) | first) as $matched
+        | ($fixed // (if $matched == null then null else $matched + {source: "perfil \($matched.id), operadora \($op)"} end))
+        | select(. != null)
+        | .apn, (.user // ""), (.password // ""), (.ip_type // "ipv4"), .source' 2>/dev/null || true)
+
+    if [ ${#fields[@]} -eq 5 ]; then
+        APN="${fields[0]}"
+        USER="${fields[1]}"
+        PASS="${fields[2]}"
+        IP_TYPE="${fields[3]}"
+        APN_SOURCE="${fields[4]}"
+    elif [ -n "$operator" ]; then
+        APN_SOURCE="padrão, operadora $operator"
+    fi
+}
+
+# ============================================================================
+# MAPA DE PORTAS (IMEI/ICCID → PORTAS)
+# ============================================================================
+
+# IMEI do modem ou, se ele não informar, ICCID do SIM (vazio se nenhum)
+get_modem_identity() {
+    local modem_id=$1
+    local output imei sim
+    output=$(mmcli -m "$modem_id" 2>/dev/null || true)
+
+    imei=$(echo "$output" | grep -w "equipment id:" | awk -F': ' '{print $2}' | tr -d "' " || true)
+    if [ -n "$imei" ]; then
+        echo "$imei"
+        return 0
+    fi
+
+    sim=$(echo "$output" | grep -o "SIM/[0-9]*" | head -1 | cut -d/ -f2 || true)
+    if [ -n "$sim" ]; then
+        mmcli -i "$sim" 2>/dev/null | grep -w "iccid:" | awk -F': ' '{print $2}' | tr -d "' " || true
+    fi
+}
+
+# Índice atual do modem com a identidade (o ModemManager renumera os modems
+# após reboot ou reconexão USB)
+find_modem_by_identity() {
+    local identity=$1
+    local modem_id
+    for modem_id in $(mmcli -L 2>/dev/null | grep -o "Modem/[0-9]\+" | cut -d'/' -f2); do
+        if [ "$(get_modem_identity "$modem_id")" = "$identity" ]; then
+            echo "$modem_id"
+            return 0
+        fi
+    done
+    return 1
+}
+
+# Define PROXY_PORT e SOCKS_PORT com a mesma regra da proxy-api: as portas
+# do mapa para a identidade ou o primeiro par livre, gravado no mapa. Sem
+# identidade, o par livre é usado sem gravar.
+assign_ports() {
+    local identity=$1
+    local map
+    map=$(cat "$PORT_MAP_FILE" 2>/dev/null || echo "[]")
+
+    PROXY_PORT=""
+    SOCKS_PORT=""
+
+    if [ -n "$identity" ]; then
+        local -a ports=()
+        mapfile -t ports < <(jq -rn --arg id "$identity" --argjson map "$map" \
+            '$map | map(select(.identity == $id)) | first | select(. != null) | .http_port, .socks_port' 2>/dev/null || true)
+        if [ ${#ports[@]} -eq 2 ]; then
+            PROXY_PORT="${ports[0]}"
+            SOCKS_PORT="${ports[1]}"
+            return 0
+        fi
+    fi
+
+    # Primeiro par livre: fora do mapa e não usado nesta detecção
+    local used=" $(jq -rn --argjson map "$map" '$map[] | .http_port, .socks_port' 2>/dev/null | tr '\n' ' ') ${DETECTED_PORTS[*]} ${DETECTED_SOCKS_PORTS[*]} "
+    local n
+    for n in $(seq 1 $MAX_MODEMS); do
+        local http=$((BASE_PROXY_PORT + n))
+        local socks=$((BASE_SOCKS_PORT + n))
+        if [[ "$used" != *" $http "* ]] && [[ "$used" != *" $socks "* ]]; then
+            PROXY_PORT=$http
+            SOCKS_PORT=$socks
+            break
+        fi
+    done
+
+    if [ -z "$PROXY_PORT" ] || [ -z "$identity" ]; then
+        return 0
+    fi
+
+    local kind="iccid"
+    [ ${#identity} -eq 15 ] && kind="imei"
+    local now
+    now=$(date -Iseconds)
+
+    mkdir -p "$API_DATA_DIR"
+    if jq -n --argjson map "$map" --arg id "$identity" --arg kind "$kind" --arg now "$now" \
+        --argjson http "$PROXY_PORT" --argjson socks "$SOCKS_PORT" \
+        '$map + [{identity: $id, kind: $kind, http_port: $http, socks_port: $socks, created_at: $now, updated_at: $now}] | sort_by(.http_port)' \
+        > "$PORT_MAP_FILE.tmp" 2>/dev/null; then
+        mv "$PORT_MAP_FILE.tmp" "$PORT_MAP_FILE"
+    else
+        rm -f "$PORT_MAP_FILE.tmp"
+        log_warning "  Não foi possível gravar $PORT_MAP_FILE"
+    fi
 }
 
 # ============================================================================
@@ -75,6 +231,8 @@ detect_all_modems() {
     DETECTED_GATEWAYS=()
     DETECTED_PREFIXES=()
     DETECTED_PORTS=()
+    DETECTED_SOCKS_PORTS=()
+    DETECTED_IDENTITIES=()
     
     # Listar todos os modems
     local MODEM_LIST=$(mmcli -L 2>/dev/null | grep -o "Modem/[0-9]\+" | cut -d'/' -f2 | sort -n)
@@ -103,8 +261,9 @@ detect_all_modems() {
         sleep 2
         
         # Conectar modem
-        log_info "  Conectando..."
-        if ! mmcli -m $MODEM_ID --simple-connect="apn=$APN,user=$USER,password=$PASS,ip-type=ipv4" 2>/dev/null; then
+        resolve_apn "$MODEM_ID"
+        log_info "  Conectando (APN $APN, $APN_SOURCE)..."
+        if ! mmcli -m $MODEM_ID --simple-connect="apn=$APN,user=$USER,password=$PASS,ip-type=$IP_TYPE" 2>/dev/null; then
             log_error "  Falha ao conectar modem $MODEM_ID"
             continue
         fi
@@ -143,9 +302,16 @@ detect_all_modems() {
             continue
         fi
         
-        # Modem funcional - adicionar aos arrays
-        local PROXY_PORT=$((BASE_PROXY_PORT + port_counter))
-        local SOCKS_PORT=$((BASE_SOCKS_PORT + port_counter))
+        # Modem funcional - portas pelo mapa de portas (IMEI/ICCID)
+        local IDENTITY=$(get_modem_identity "$MODEM_ID")
+        if [ -z "$IDENTITY" ]; then
+            log_warning "  Modem $MODEM_ID sem IMEI/ICCID: portas podem mudar no próximo start"
+        fi
+        assign_ports "$IDENTITY"
+        if [ -z "$PROXY_PORT" ]; then
+            log_error "  Modem $MODEM_ID: sem portas livres"
+            continue
+        fi
         
         DETECTED_MODEMS+=("$MODEM_ID")
         DETECTED_INTERFACES+=("$INTERFACE")
@@ -153,6 +319,8 @@ detect_all_modems() {
         DETECTED_GATEWAYS+=("$GATEWAY")
         DETECTED_PREFIXES+=("$PREFIX")
         DETECTED_PORTS+=("$PROXY_PORT")
+        DETECTED_SOCKS_PORTS+=("$SOCKS_PORT")
+        DETECTED_IDENTITIES+=("$IDENTITY")
         
         log_success "  Modem $MODEM_ID OK: $INTERFACE ($IP) → HTTP:$PROXY_PORT SOCKS:$SOCKS_PORT"
         
@@ -175,7 +343,7 @@ detect_all_modems() {
                 "${DETECTED_INTERFACES[$i]}" \
                 "${DETECTED_IPS[$i]}" \
                 "${DETECTED_PORTS[$i]}" \
-                $((${DETECTED_PORTS[$i]} + 1000))
+                "${DETECTED_SOCKS_PORTS[$i]}"
         done
         log_info "========================================="
         return 0
@@ -245,97 +413,6 @@ setup_routing() {
     ip route flush cache 2>/dev/null || true
 }
 
-create_proxy_config() {
-    local INDEX=$1
-    local MODEM_ID="${DETECTED_MODEMS[$INDEX]}"
-    local IFACE="${DETECTED_INTERFACES[$INDEX]}"
-    local IP="${DETECTED_IPS[$INDEX]}"
-    local PROXY_PORT="${DETECTED_PORTS[$INDEX]}"
-    local SOCKS_PORT=$((PROXY_PORT + 1000))
-    
-    local CONFIG_FILE="${CONFIG_DIR}/3proxy_${PROXY_PORT}.cfg"
-    local PID_FILE="${PID_DIR}/3proxy_${PROXY_PORT}.pid"
-    local LOG_FILE="${LOG_DIR}/3proxy_${PROXY_PORT}.log"
-    
-    cat > "$CONFIG_FILE" << EOF
-# Configuração 3proxy - Modem ${MODEM_ID}
-# Interface: ${IFACE}
-# IP: ${IP}
-# Gerado em: $(date)
-
-daemon
-pidfile ${PID_FILE}
-log ${LOG_FILE} D
-rotate 30
-auth none
-allow *
-
-# Proxies
-proxy -p${PROXY_PORT} -e${IP}
-socks -p${SOCKS_PORT} -e${IP}
-EOF
-    
-    log_success "  Config criado: $CONFIG_FILE"
-}
-
-start_proxy_instance() {
-    local PROXY_PORT=$1
-    local CONFIG_FILE="${CONFIG_DIR}/3proxy_${PROXY_PORT}.cfg"
-    local PID_FILE="${PID_DIR}/3proxy_${PROXY_PORT}.pid"
-    
-    if [ ! -f "$CONFIG_FILE" ]; then
-        log_error "Config não encontrado: $CONFIG_FILE"
-        return 1
-    fi
-    
-    # Parar instância se estiver rodando
-    if [ -f "$PID_FILE" ]; then
-        local OLD_PID=$(cat "$PID_FILE" 2>/dev/null)
-        if [ -n "$OLD_PID" ] && kill -0 "$OLD_PID" 2>/dev/null; then
-            kill "$OLD_PID" 2>/dev/null || true
-            sleep 1
-        fi
-    fi
-    
-    # Iniciar nova instância
-    /usr/local/bin/3proxy "$CONFIG_FILE"
-    
-    sleep 1
-    
-    # Verificar se iniciou
-    if [ -f "$PID_FILE" ]; then
-        local NEW_PID=$(cat "$PID_FILE" 2>/dev/null)
-        if [ -n "$NEW_PID" ] && kill -0 "$NEW_PID" 2>/dev/null; then
-            log_success "  3proxy iniciado: porta $PROXY_PORT (PID: $NEW_PID)"
-            return 0
-        fi
-    fi
-    
-    log_error "  Falha ao iniciar 3proxy na porta $PROXY_PORT"
-    return 1
-}
-
-setup_all_proxies() {
-    log_info "Configurando instâncias do 3proxy..."
-    
-    local success_count=0
-    
-    for i in "${!DETECTED_PORTS[@]}"; do
-        local PROXY_PORT="${DETECTED_PORTS[$i]}"
-        
-        create_proxy_config "$i"
-        
-        if start_proxy_instance "$PROXY_PORT"; then
-            success_count=$((success_count + 1))
-        fi
-    done
-    
-    echo ""
-    log_info "========================================="
-    log_success "Instâncias 3proxy iniciadas: $success_count/${#DETECTED_PORTS[@]}"
-    log_info "========================================="
-}
-
 save_status() {
     log_info "Salvando status do sistema..."
     
@@ -348,11 +425,12 @@ save_status() {
         [ $i -gt 0 ] && json+=","
         json+="{"
         json+="\"id\":\"${DETECTED_MODEMS[$i]}\","
+        json+="\"identity\":\"${DETECTED_IDENTITIES[$i]}\","
         json+="\"interface\":\"${DETECTED_INTERFACES[$i]}\","
         json+="\"ip\":\"${DETECTED_IPS[$i]}\","
         json+="\"gateway\":\"${DETECTED_GATEWAYS[$i]}\","
         json+="\"http_port\":${DETECTED_PORTS[$i]},"
-        json+="\"socks_port\":$((${DETECTED_PORTS[$i]} + 1000))"
+        json+="\"socks_port\":${DETECTED_SOCKS_PORTS[$i]}"
         json+="}"
     done
     
@@ -380,6 +458,8 @@ load_status_file() {
     DETECTED_GATEWAYS=()
     DETECTED_PREFIXES=()
     DETECTED_PORTS=()
+    DETECTED_SOCKS_PORTS=()
+    DETECTED_IDENTITIES=()
     
     # Ler JSON usando jq
     local modem_count
@@ -398,6 +478,8 @@ load_status_file() {
         local ip
         local gateway
         local http_port
+        local socks_port
+        local identity
         local prefix
         
         modem_id=$(jq -r ".modems[$i].id" "$STATUS_FILE" 2>/dev/null)
@@ -405,6 +487,8 @@ load_status_file() {
         ip=$(jq -r ".modems[$i].ip" "$STATUS_FILE" 2>/dev/null)
         gateway=$(jq -r ".modems[$i].gateway" "$STATUS_FILE" 2>/dev/null)
         http_port=$(jq -r ".modems[$i].http_port" "$STATUS_FILE" 2>/dev/null)
+        socks_port=$(jq -r ".modems[$i].socks_port // ($http_port + 1000)" "$STATUS_FILE" 2>/dev/null)
+        identity=$(jq -r ".modems[$i].identity // empty" "$STATUS_FILE" 2>/dev/null)
         
         # Prefix padrão
         prefix="24"
@@ -415,6 +499,8 @@ load_status_file() {
         DETECTED_GATEWAYS+=("$gateway")
         DETECTED_PREFIXES+=("$prefix")
         DETECTED_PORTS+=("$http_port")
+        DETECTED_SOCKS_PORTS+=("$socks_port")
+        DETECTED_IDENTITIES+=("$identity")
     done
     
     # Log após carregar tudo - TUDO para stderr
@@ -483,7 +569,21 @@ renew_ip_by_port() {
     local OLD_IP="${DETECTED_IPS[$MODEM_INDEX]}"
     local GATEWAY="${DETECTED_GATEWAYS[$MODEM_INDEX]}"
     local PREFIX="${DETECTED_PREFIXES[$MODEM_INDEX]}"
-    local SOCKS_PORT=$((TARGET_PORT + 1000))
+    local SOCKS_PORT="${DETECTED_SOCKS_PORTS[$MODEM_INDEX]}"
+    local IDENTITY="${DETECTED_IDENTITIES[$MODEM_INDEX]}"
+    
+    # A porta segue o modem físico: se o ModemManager o renumerou, usar o
+    # índice atual
+    if [ -n "$IDENTITY" ] && [ "$(get_modem_identity "$MODEM_ID")" != "$IDENTITY" ]; then
+        local CURRENT_ID
+        if ! CURRENT_ID=$(find_modem_by_identity "$IDENTITY"); then
+            log_error "Modem $IDENTITY da porta $TARGET_PORT não encontrado"
+            return 1
+        fi
+        log_info "Modem $IDENTITY mudou de índice: $MODEM_ID → $CURRENT_ID"
+        MODEM_ID="$CURRENT_ID"
+        DETECTED_MODEMS[$MODEM_INDEX]="$MODEM_ID"
+    fi
     
     log_info "Modem ID: $MODEM_ID"
     log_info "Interface atual: $OLD_IFACE"
@@ -522,8 +622,9 @@ renew_ip_by_port() {
         sleep 5
         
         # 5. Reconectar
-        log_info "Reconectando..."
-        if ! mmcli -m "$MODEM_ID" --simple-connect="apn=$APN,user=$USER,password=$PASS,ip-type=ipv4" 2>/dev/null; then
+        resolve_apn "$MODEM_ID"
+        log_info "Reconectando (APN $APN, $APN_SOURCE)..."
+        if ! mmcli -m "$MODEM_ID" --simple-connect="apn=$APN,user=$USER,password=$PASS,ip-type=$IP_TYPE" 2>/dev/null; then
             log_error "Falha ao reconectar"
             ATTEMPT=$((ATTEMPT + 1))
             continue
@@ -593,43 +694,7 @@ renew_ip_by_port() {
         
         log_success "Conectividade OK"
         
-        # 10. Reconfigurar 3proxy APENAS desta porta
-        log_info "Reconfigurando 3proxy..."
-        
-        # Atualizar config
-        local CONFIG_FILE="${CONFIG_DIR}/3proxy_${TARGET_PORT}.cfg"
-        local PID_FILE="${PID_DIR}/3proxy_${TARGET_PORT}.pid"
-        local LOG_FILE="${LOG_DIR}/3proxy_${TARGET_PORT}.log"
-        
-        cat > "$CONFIG_FILE" << EOF
-# Configuração 3proxy - Modem ${MODEM_ID}
-# Interface: ${NEW_IFACE}
-# IP: ${NEW_IP}
-# Atualizado em: $(date)
-
-daemon
-pidfile ${PID_FILE}
-log ${LOG_FILE} D
-rotate 30
-auth none
-allow *
-
-# Proxies
-proxy -p${TARGET_PORT} -e${NEW_IP}
-socks -p${SOCKS_PORT} -e${NEW_IP}
-EOF
-        
-        # Reiniciar APENAS esta instância
-        if [ -f "$PID_FILE" ]; then
-            local OLD_PID=$(cat "$PID_FILE" 2>/dev/null)
-            if [ -n "$OLD_PID" ]; then
-                kill "$OLD_PID" 2>/dev/null || true
-                sleep 1
-            fi
-        fi
-        
-        /usr/local/bin/3proxy "$CONFIG_FILE"
-        sleep 2
+        # 10. Os listeners da proxy-api são reapontados ao ler o status salvo abaixo
         
         # 11. Obter novo IP público
         log_info "Obtendo novo IP público..."
@@ -726,37 +791,33 @@ show_status() {
     fi
     
     echo ""
-    echo "🔧 INSTÂNCIAS 3PROXY:"
+    echo "🔧 PROXIES (proxy-api):"
     
     local proxy_count=0
     for PORT in $(seq $((BASE_PROXY_PORT + 1)) $((BASE_PROXY_PORT + MAX_MODEMS))); do
-        local PID_FILE="${PID_DIR}/3proxy_${PORT}.pid"
-        
-        if [ -f "$PID_FILE" ]; then
-            local PID=$(cat "$PID_FILE" 2>/dev/null)
+        if ss -ltn "sport = :${PORT}" 2>/dev/null | grep -q LISTEN; then
+            # Buscar IP de saída no status
+            local PROXY_IP="N/A"
+            local SOCKS=$((PORT + 1000))
             
-            if [ -n "$PID" ] && kill -0 "$PID" 2>/dev/null; then
-                # Buscar IP da config
-                local CONFIG_FILE="${CONFIG_DIR}/3proxy_${PORT}.cfg"
-                local PROXY_IP="N/A"
-                
-                if [ -f "$CONFIG_FILE" ]; then
-                    PROXY_IP=$(grep -oP "proxy.*-e\K[0-9.]+" "$CONFIG_FILE" 2>/dev/null || echo "N/A")
-                fi
-                
-                # Tentar obter IP público
-                local PUBLIC_IP=$(timeout 5 curl -s -x "http://127.0.0.1:${PORT}" https://api.ipify.org 2>/dev/null || echo "N/A")
-                
-                printf "  HTTP:%-5d SOCKS:%-5d | IP interno: %-15s | IP público: %s | PID: %s\n" \
-                    "$PORT" "$((PORT + 1000))" "$PROXY_IP" "$PUBLIC_IP" "$PID"
-                
-                proxy_count=$((proxy_count + 1))
+            if [ -f "$STATUS_FILE" ]; then
+                PROXY_IP=$(grep -oP "\"ip\":\"\K[0-9.]+(?=\",\"gateway\":\"[0-9.]*\",\"http_port\":${PORT},)" "$STATUS_FILE" 2>/dev/null || echo "N/A")
+                SOCKS=$(jq -r --argjson p "$PORT" '.modems[] | select(.http_port == $p) | .socks_port' "$STATUS_FILE" 2>/dev/null | head -1)
+                SOCKS=${SOCKS:-$((PORT + 1000))}
             fi
+            
+            # Tentar obter IP público
+            local PUBLIC_IP=$(timeout 5 curl -s -x "http://127.0.0.1:${PORT}" https://api.ipify.org 2>/dev/null || echo "N/A")
+            
+            printf "  HTTP:%-5d SOCKS:%-5d | IP interno: %-15s | IP público: %s\n" \
+                "$PORT" "$SOCKS" "$PROXY_IP" "$PUBLIC_IP"
+            
+            proxy_count=$((proxy_count + 1))
         fi
     done
     
     if [ $proxy_count -eq 0 ]; then
-        echo "  Nenhuma instância rodando"
+        echo "  Nenhum proxy escutando (a proxy-api está rodando?)"
     fi
     
     echo ""
@@ -778,29 +839,9 @@ show_status() {
 stop_system() {
     log_info "Parando sistema..."
     
-    # Parar todas as instâncias do 3proxy
-    log_info "Parando instâncias 3proxy..."
-    local stopped=0
-    
-    for PORT in $(seq $((BASE_PROXY_PORT + 1)) $((BASE_PROXY_PORT + MAX_MODEMS))); do
-        local PID_FILE="${PID_DIR}/3proxy_${PORT}.pid"
-        
-        if [ -f "$PID_FILE" ]; then
-            local PID=$(cat "$PID_FILE" 2>/dev/null)
-            
-            if [ -n "$PID" ] && kill -0 "$PID" 2>/dev/null; then
-                kill "$PID" 2>/dev/null || true
-                rm -f "$PID_FILE"
-                stopped=$((stopped + 1))
-            fi
-        fi
-    done
-    
-    # Fallback: killall
+    # Instâncias 3proxy de versões anteriores
     killall 3proxy 2>/dev/null || true
     
-    log_success "Instâncias 3proxy paradas: $stopped"
-    
     # Desconectar modems
     log_info "Desconectando modems..."
     local MODEM_LIST=$(mmcli -L 2>/dev/null | grep -o "Modem/[0-9]\+" | cut -d'/' -f2)
@@ -839,11 +880,6 @@ start_system() {
     
     echo ""
     
-    # Configurar proxies
-    setup_all_proxies
-    
-    echo ""
-    
     # Salvar status
     save_status
     
@@ -854,8 +890,9 @@ start_system() {
     echo ""
     echo "📊 RESUMO:"
     echo "  Modems funcionais: ${#DETECTED_MODEMS[@]}"
-    echo "  Portas HTTP: $((BASE_PROXY_PORT + 1)) - $((BASE_PROXY_PORT + ${#DETECTED_MODEMS[@]}))"
-    echo "  Portas SOCKS5: $((BASE_SOCKS_PORT + 1)) - $((BASE_SOCKS_PORT + ${#DETECTED_MODEMS[@]}))"
+    echo "  Portas HTTP: ${DETECTED_PORTS[*]}"
+    echo "  Portas SOCKS5: ${DETECTED_SOCKS_PORTS[*]}"
+    echo "  Mapa de portas: $PORT_MAP_FILE"
     echo ""
     echo "🌐 API Dashboard: http://SEU_IP:5000"
     echo ""